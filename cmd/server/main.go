@@ -1,16 +1,28 @@
 package main
 
 import (
+	"asyn-subpub-service/internal/auth"
 	"asyn-subpub-service/internal/config"
+	"asyn-subpub-service/internal/gateway"
+	"asyn-subpub-service/internal/metrics"
+	"asyn-subpub-service/internal/msglog"
 	"asyn-subpub-service/internal/services"
 	"asyn-subpub-service/internal/subpub"
+	"asyn-subpub-service/internal/tlsconfig"
 	pb "asyn-subpub-service/pb/proto/api"
 	"asyn-subpub-service/pkg/logger"
 	"context"
+	"errors"
+	"fmt"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
@@ -45,9 +57,98 @@ func run() error {
 	}
 
 	// Initialize subpub and gRPC server
-	subPub := subpub.NewSubPub(cfg.SubPub.BufferSize)
-	s := grpc.NewServer()
-	pb.RegisterPubSubServer(s, services.NewServer(subPub))
+	policy, err := subpub.ParseOverflowPolicy(cfg.SubPub.OverflowPolicy)
+	if err != nil {
+		logger.GetLoggerFromContext(ctx).Fatal("invalid subpub config", zap.Error(err))
+		return err
+	}
+	strategy, err := subpub.ParseQueueStrategy(cfg.SubPub.QueueStrategy)
+	if err != nil {
+		logger.GetLoggerFromContext(ctx).Fatal("invalid subpub config", zap.Error(err))
+		return err
+	}
+	m := metrics.New()
+	healthServer := health.NewServer()
+	opts := []subpub.Option{
+		subpub.WithOverflowPolicy(policy),
+		subpub.WithBlockTimeout(cfg.SubPub.BlockTimeout),
+		subpub.WithQueueStrategy(strategy),
+		subpub.WithRetainedLimit(cfg.SubPub.RetainedLimit),
+		subpub.WithObserver(m),
+		// Stop reporting SERVING as soon as subscriptions start being torn down.
+		subpub.OnClose(healthServer.Shutdown),
+	}
+	for _, r := range cfg.SubPub.TTL {
+		// Every valid pattern covers itself.
+		if !subpub.Covers(r.Subject, r.Subject) || r.TTL <= 0 {
+			err := fmt.Errorf("invalid TTL rule %q: %v", r.Subject, r.TTL)
+			logger.GetLoggerFromContext(ctx).Fatal("invalid subpub config", zap.Error(err))
+			return err
+		}
+		opts = append(opts, subpub.WithDefaultTTL(r.Subject, r.TTL))
+	}
+	if cfg.SubPub.LogDir != "" {
+		msgLog, err := msglog.Open(cfg.SubPub.LogDir,
+			msglog.WithSegmentSize(cfg.SubPub.LogSegmentSize),
+			msglog.WithSync(cfg.SubPub.LogSync),
+			msglog.WithMaxOpenSegments(cfg.SubPub.LogMaxOpenSegments),
+		)
+		if err != nil {
+			logger.GetLoggerFromContext(ctx).Fatal("failed to open message log", zap.Error(err))
+			return err
+		}
+		defer msgLog.Close()
+		opts = append(opts, subpub.WithLog(msgLog))
+	}
+	subPub := subpub.NewSubPub(cfg.SubPub.BufferSize, opts...)
+	m.Watch(subPub)
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(m.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(m.StreamServerInterceptor()),
+	}
+	authenticator, acl, err := newAuth(cfg)
+	if err != nil {
+		logger.GetLoggerFromContext(ctx).Fatal("invalid auth config", zap.Error(err))
+		return err
+	}
+	if authenticator != nil {
+		serverOpts = append(serverOpts,
+			grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(authenticator)),
+			grpc.ChainStreamInterceptor(auth.StreamServerInterceptor(authenticator)),
+		)
+	}
+	var certs *tlsconfig.Reloader
+	if cfg.TLS.CertFile != "" {
+		certs, err = tlsconfig.New(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			logger.GetLoggerFromContext(ctx).Fatal("failed to load TLS certificates", zap.Error(err))
+			return err
+		}
+		watchCtx, stopWatch := context.WithCancel(ctx)
+		defer stopWatch()
+		go certs.Watch(watchCtx, cfg.TLS.ReloadInterval)
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(certs.TLSConfig())))
+	}
+	s := grpc.NewServer(serverOpts...)
+	pubSubServer := services.NewServer(subPub,
+		services.WithAckDeadline(cfg.Ack.Deadline),
+		services.WithMaxDeliveries(cfg.Ack.MaxDeliveries),
+		services.WithDeadLetterPrefix(cfg.Ack.DeadLetterPrefix),
+		services.WithMaxInFlight(cfg.Ack.MaxInFlight),
+		services.WithForwardedMetadata(cfg.Server.ForwardMetadata...),
+		services.WithRequireDelivery(cfg.Server.RequireDelivery),
+		services.WithRequestTimeout(cfg.Server.RequestTimeout),
+		services.WithACL(acl),
+	)
+	pb.RegisterPubSubServer(s, pubSubServer)
+	if cfg.Server.Admin {
+		pb.RegisterAdminServer(s, services.NewAdminServer(pubSubServer))
+	}
+	healthpb.RegisterHealthServer(s, healthServer)
+	healthServer.SetServingStatus(pb.PubSub_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	if cfg.Server.Reflection {
+		reflection.Register(s)
+	}
 
 	// Start server in a goroutine
 	go func() {
@@ -57,19 +158,115 @@ func run() error {
 		}
 	}()
 
+	// Expose metrics over HTTP
+	var metricsServer *http.Server
+	if !cfg.Metrics.Disabled {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m.Handler())
+		metricsServer = &http.Server{Addr: ":" + strconv.Itoa(cfg.Metrics.Port), Handler: mux}
+		go func() {
+			logger.GetLoggerFromContext(ctx).Info("Metrics listening on", zap.String("port", strconv.Itoa(cfg.Metrics.Port)))
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.GetLoggerFromContext(ctx).Error("failed to serve metrics", zap.Error(err))
+			}
+		}()
+	}
+
+	// Serve the HTTP gateway with the same service, credentials and certificates
+	var httpServer *http.Server
+	if !cfg.Server.HTTPDisabled {
+		gatewayOpts := []gateway.Option{gateway.WithAllowedOrigins(cfg.Server.AllowedOrigins...)}
+		if authenticator != nil {
+			gatewayOpts = append(gatewayOpts, gateway.WithAuthenticator(authenticator))
+		}
+		httpServer = &http.Server{
+			Addr:    ":" + strconv.Itoa(cfg.Server.HTTPPort),
+			Handler: gateway.New(pubSubServer, gatewayOpts...),
+		}
+		go func() {
+			logger.GetLoggerFromContext(ctx).Info("HTTP gateway listening on", zap.String("port", strconv.Itoa(cfg.Server.HTTPPort)))
+			var err error
+			if certs != nil {
+				httpServer.TLSConfig = certs.TLSConfig()
+				err = httpServer.ListenAndServeTLS("", "")
+			} else {
+				err = httpServer.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.GetLoggerFromContext(ctx).Error("failed to serve HTTP gateway", zap.Error(err))
+			}
+		}()
+	}
+
 	// Handle signals for graceful shutdown
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	<-sigs
 
 	// Perform graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	healthServer.Shutdown()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if err := subPub.Close(ctx); err != nil {
+	if err := subPub.Close(shutdownCtx); err != nil {
 		logger.GetLoggerFromContext(ctx).Fatal("failed to close subPub", zap.Error(err))
 		return err
 	}
-	s.GracefulStop()
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		// Health watchers keep their streams open until the client goes away.
+		s.Stop()
+	}
+	if httpServer != nil {
+		// Event streams have ended with subPub.Close.
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			logger.GetLoggerFromContext(ctx).Error("failed to stop HTTP gateway", zap.Error(err))
+		}
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			logger.GetLoggerFromContext(ctx).Error("failed to stop metrics server", zap.Error(err))
+		}
+	}
 	log.Println("Server stopped gracefully")
 	return nil
 }
+
+// newAuth builds the authenticator for the configured mode, nil when callers are
+// not authenticated, and the ACL, nil when no rules are configured.
+func newAuth(cfg *config.Config) (auth.Authenticator, *auth.ACL, error) {
+	var authenticator auth.Authenticator
+	switch cfg.Auth.Mode {
+	case "", "none":
+	case "token":
+		if len(cfg.Auth.Tokens) == 0 {
+			return nil, nil, errors.New("token auth requires AUTH_TOKENS")
+		}
+		authenticator = auth.NewStaticTokens(cfg.Auth.Tokens)
+	case "jwt":
+		jwtAuth, err := auth.NewJWT(cfg.Auth.JWKSFile, cfg.Auth.JWTIssuer, cfg.Auth.JWTAudience)
+		if err != nil {
+			return nil, nil, err
+		}
+		authenticator = jwtAuth
+	default:
+		return nil, nil, fmt.Errorf("unknown auth mode %q", cfg.Auth.Mode)
+	}
+	if len(cfg.Auth.ACL) == 0 {
+		return authenticator, nil, nil
+	}
+	rules := make([]auth.Rule, 0, len(cfg.Auth.ACL))
+	for _, r := range cfg.Auth.ACL {
+		rules = append(rules, auth.Rule{Principal: r.Principal, Publish: r.Publish, Subscribe: r.Subscribe, Admin: r.Admin})
+	}
+	acl, err := auth.NewACL(rules)
+	if err != nil {
+		return nil, nil, err
+	}
+	return authenticator, acl, nil
+}
@@ -1,7 +1,16 @@
 package main
 
 import (
+	pb "asyn-subpub-service/pb/proto/api"
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"syscall"
 	"testing"
@@ -11,10 +20,13 @@ import (
 func TestRun(t *testing.T) {
 	t.Run("Graceful Shutdown", func(t *testing.T) {
 		yamlContent := `
-			server:
-			  GRPC_PORT: 0
-			subpub:
-			  buffer_size: 100`
+SERVER:
+  GRPC_PORT: 0
+  HTTP_DISABLED: true
+SUBPUB:
+  BUFFER_SIZE: 100
+METRICS:
+  METRICS_DISABLED: true`
 		tmpFile, err := ioutil.TempFile("", "config-*.yaml")
 		if err != nil {
 			t.Fatalf("Failed to create temp file: %v", err)
@@ -46,4 +58,188 @@ func TestRun(t *testing.T) {
 			t.Fatal("Run did not complete in time")
 		}
 	})
+	t.Run("Health And Reflection", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to pick a port: %v", err)
+		}
+		port := lis.Addr().(*net.TCPAddr).Port
+		lis.Close()
+
+		tmpFile, err := ioutil.TempFile("", "config-*.yaml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+		fmt.Fprintf(tmpFile, "SERVER:\n  GRPC_PORT: %d\n  HTTP_DISABLED: true\n  REFLECTION: true\nMETRICS:\n  METRICS_DISABLED: true\n", port)
+		tmpFile.Close()
+		os.Setenv("CONFIG_PATH", tmpFile.Name())
+		defer os.Unsetenv("CONFIG_PATH")
+
+		done := make(chan error)
+		go func() {
+			done <- run()
+		}()
+
+		conn, err := grpc.NewClient(fmt.Sprintf("127.0.0.1:%d", port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		health := healthpb.NewHealthClient(conn)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, service := range []string{"", pb.PubSub_ServiceDesc.ServiceName} {
+			resp, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: service}, grpc.WaitForReady(true))
+			if err != nil {
+				t.Fatalf("Health check of %q failed: %v", service, err)
+			}
+			if resp.Status != healthpb.HealthCheckResponse_SERVING {
+				t.Errorf("Expected %q to be SERVING, got %v", service, resp.Status)
+			}
+		}
+
+		refl, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		if err != nil {
+			t.Fatalf("Reflection failed: %v", err)
+		}
+		refl.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		})
+		resp, err := refl.Recv()
+		if err != nil {
+			t.Fatalf("Reflection failed: %v", err)
+		}
+		found := false
+		for _, service := range resp.GetListServicesResponse().GetService() {
+			found = found || service.Name == pb.PubSub_ServiceDesc.ServiceName
+		}
+		if !found {
+			t.Errorf("Expected PubSub in reflected services, got %v", resp.GetListServicesResponse())
+		}
+		refl.CloseSend()
+
+		watchCtx, stopWatch := context.WithCancel(ctx)
+		watch, err := health.Watch(watchCtx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		if first, err := watch.Recv(); err != nil || first.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Fatalf("Expected SERVING, got %v (err %v)", first, err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+		if next, err := watch.Recv(); err != nil || next.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("Expected NOT_SERVING on shutdown, got %v (err %v)", next, err)
+		}
+		stopWatch()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+		case <-time.After(6 * time.Second):
+			t.Fatal("Run did not complete in time")
+		}
+	})
+	t.Run("Metrics Disabled", func(t *testing.T) {
+		for _, disabled := range []bool{false, true} {
+			grpcPort, metricsPort := freePort(t), freePort(t)
+			done := startRun(t, fmt.Sprintf("SERVER:\n  GRPC_PORT: %d\n  HTTP_DISABLED: true\nMETRICS:\n  METRICS_PORT: %d\n  METRICS_DISABLED: %t\n", grpcPort, metricsPort, disabled))
+			waitServing(t, grpcPort)
+
+			if err := waitHTTP(fmt.Sprintf("http://127.0.0.1:%d/metrics", metricsPort)); disabled && err == nil {
+				t.Errorf("Expected no metrics server on port %d", metricsPort)
+			} else if !disabled && err != nil {
+				t.Errorf("Expected metrics on port %d: %v", metricsPort, err)
+			}
+			stopRun(t, done)
+		}
+	})
+	t.Run("Gateway Disabled", func(t *testing.T) {
+		for _, disabled := range []bool{false, true} {
+			grpcPort, httpPort := freePort(t), freePort(t)
+			done := startRun(t, fmt.Sprintf("SERVER:\n  GRPC_PORT: %d\n  HTTP_PORT: %d\n  HTTP_DISABLED: %t\nMETRICS:\n  METRICS_DISABLED: true\n", grpcPort, httpPort, disabled))
+			waitServing(t, grpcPort)
+
+			if err := waitHTTP(fmt.Sprintf("http://127.0.0.1:%d/", httpPort)); disabled && err == nil {
+				t.Errorf("Expected no HTTP gateway on port %d", httpPort)
+			} else if !disabled && err != nil {
+				t.Errorf("Expected the HTTP gateway on port %d: %v", httpPort, err)
+			}
+			stopRun(t, done)
+		}
+	})
+}
+
+// freePort returns a port that was free a moment ago.
+func freePort(t *testing.T) int {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to pick a port: %v", err)
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+// startRun writes config to a temporary file and runs the server with it.
+func startRun(t *testing.T, config string) chan error {
+	t.Helper()
+	path := t.TempDir() + "/config.yaml"
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+	done := make(chan error, 1)
+	go func() {
+		done <- run()
+	}()
+	return done
+}
+
+// waitServing blocks until the gRPC server on port reports SERVING.
+func waitServing(t *testing.T, port int) {
+	t.Helper()
+	conn, err := grpc.NewClient(fmt.Sprintf("127.0.0.1:%d", port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatalf("Health check failed: %v", err)
+	}
+}
+
+// waitHTTP retries a GET of url for a while, since HTTP servers start
+// listening after gRPC reports SERVING.
+func waitHTTP(url string) error {
+	var err error
+	for i := 0; i < 20; i++ {
+		var resp *http.Response
+		if resp, err = http.Get(url); err == nil {
+			resp.Body.Close()
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return err
+}
+
+// stopRun sends SIGTERM and waits for run to return.
+func stopRun(t *testing.T, done chan error) {
+	t.Helper()
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	case <-time.After(6 * time.Second):
+		t.Fatal("Run did not complete in time")
+	}
 }
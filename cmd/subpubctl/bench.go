@@ -0,0 +1,136 @@
+package main
+
+import (
+	pb "asyn-subpub-service/pb/proto/api"
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// benchSentHeader carries the time a benchmark message was sent, in Unix nanoseconds.
+const benchSentHeader = "bench-sent-at"
+
+// bench publishes messages from concurrent publishers and, unless disabled,
+// measures how long they take to come back on a subscription of the key.
+func bench(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("bench", "[flags] [key]")
+	n := fs.Int("n", 10000, "number of messages to publish")
+	size := fs.Int("size", 128, "payload size in bytes")
+	concurrency := fs.Int("c", 4, "number of concurrent publishers")
+	subscribe := fs.Bool("sub", true, "subscribe to the key and measure delivery latency")
+	wait := fs.Duration("wait", 5*time.Second, "how long to wait for deliveries once everything is published")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 || *n <= 0 || *size < 0 || *concurrency <= 0 {
+		fs.Usage()
+		return errUsage
+	}
+	key := "bench"
+	if fs.NArg() == 1 {
+		key = fs.Arg(0)
+	}
+
+	var latencies []time.Duration
+	allReceived := make(chan struct{})
+	receiverDone := make(chan struct{})
+	subCtx, stopSub := context.WithCancel(ctx)
+	defer stopSub()
+	if *subscribe {
+		stream, err := a.api.Subscribe(subCtx, &pb.SubscribeRequest{Key: key})
+		if err != nil {
+			return err
+		}
+		// The server sends the headers once the subscription is registered.
+		md, err := stream.Header()
+		if err == nil && md == nil {
+			_, err = stream.Recv()
+		}
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer close(receiverDone)
+			for {
+				event, err := stream.Recv()
+				if err != nil {
+					return
+				}
+				sent, err := strconv.ParseInt(event.Headers[benchSentHeader], 10, 64)
+				if err != nil {
+					continue
+				}
+				latencies = append(latencies, time.Since(time.Unix(0, sent)))
+				if len(latencies) == *n {
+					close(allReceived)
+				}
+			}
+		}()
+	} else {
+		close(receiverDone)
+	}
+
+	payload := bytes.Repeat([]byte("x"), *size)
+	var next, failed atomic.Int64
+	var firstErr error
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next.Add(1) <= int64(*n) {
+				req := &pb.PublishRequest{
+					Key:     key,
+					Data:    payload,
+					Headers: map[string]string{benchSentHeader: strconv.FormatInt(time.Now().UnixNano(), 10)},
+				}
+				if _, err := a.api.Publish(ctx, req); err != nil {
+					failed.Add(1)
+					errOnce.Do(func() { firstErr = err })
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if int(failed.Load()) == *n {
+		return firstErr
+	}
+	rate := float64(*n) / elapsed.Seconds()
+	fmt.Fprintf(a.stdout, "published %d messages of %d bytes in %v: %.0f msg/s, %.2f MiB/s\n",
+		*n, *size, elapsed.Round(time.Millisecond), rate, rate*float64(*size)/(1<<20))
+	if failed.Load() > 0 {
+		fmt.Fprintf(a.stdout, "failed    %d, first error: %v\n", failed.Load(), firstErr)
+	}
+	if !*subscribe {
+		return nil
+	}
+
+	select {
+	case <-allReceived:
+	case <-time.After(*wait):
+	case <-ctx.Done():
+	}
+	stopSub()
+	<-receiverDone
+	fmt.Fprintf(a.stdout, "received  %d of %d messages\n", len(latencies), *n)
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		fmt.Fprintf(a.stdout, "latency   min=%v p50=%v p90=%v p99=%v max=%v\n",
+			latencies[0], percentile(latencies, 0.5), percentile(latencies, 0.9),
+			percentile(latencies, 0.99), latencies[len(latencies)-1])
+	}
+	return nil
+}
+
+// percentile returns the q-th quantile of the sorted durations.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	return sorted[int(q*float64(len(sorted)-1))].Round(time.Microsecond)
+}
@@ -0,0 +1,166 @@
+// Command subpubctl publishes to, subscribes to and inspects a PubSub server.
+package main
+
+import (
+	pb "asyn-subpub-service/pb/proto/api"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errUsage is returned after the usage has been printed.
+var errUsage = errors.New("invalid usage")
+
+// app holds what every command needs.
+type app struct {
+	conn    *grpc.ClientConn
+	api     pb.PubSubClient
+	timeout time.Duration
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, a *app, args []string) error
+}
+
+var commands = []command{
+	{"pub", "publish a message to a key", pub},
+	{"sub", "print the events of one or more keys", sub},
+	{"bench", "measure publish throughput and delivery latency", bench},
+	{"stats", "print the health and broker metrics of the server", stats},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, errUsage) && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "subpubctl: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("subpubctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("addr", envOr("SUBPUB_ADDR", "localhost:50051"), "address of the gRPC server (env SUBPUB_ADDR)")
+	token := fs.String("token", os.Getenv("SUBPUB_TOKEN"), "bearer token sent with every call (env SUBPUB_TOKEN)")
+	useTLS := fs.Bool("tls", false, "connect over TLS, verifying the server with the system roots")
+	caFile := fs.String("ca", "", "CA certificate to verify the server with; implies -tls")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of unary calls")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: subpubctl [flags] <command> [command flags] [args]\n\nCommands:\n")
+		for _, c := range commands {
+			fmt.Fprintf(stderr, "  %-6s %s\n", c.name, c.summary)
+		}
+		fmt.Fprintf(stderr, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == fs.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "subpubctl: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return errUsage
+	}
+
+	creds := insecure.NewCredentials()
+	if *useTLS || *caFile != "" {
+		cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+		if *caFile != "" {
+			pem, err := os.ReadFile(*caFile)
+			if err != nil {
+				return err
+			}
+			cfg.RootCAs = x509.NewCertPool()
+			if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates in %s", *caFile)
+			}
+		}
+		creds = credentials.NewTLS(cfg)
+	}
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if *token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+*token)
+	}
+	a := &app{
+		conn:    conn,
+		api:     pb.NewPubSubClient(conn),
+		timeout: *timeout,
+		stdin:   stdin,
+		stdout:  stdout,
+		stderr:  stderr,
+	}
+	return cmd.run(ctx, a, fs.Args()[1:])
+}
+
+// flagSet creates the flag set of a command; usage lists its arguments.
+func (a *app) flagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(a.stderr, "Usage: subpubctl %s %s\n\nFlags:\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// headerFlag collects repeated key=value flags.
+type headerFlag map[string]string
+
+func (h headerFlag) String() string {
+	pairs := make([]string, 0, len(h))
+	for k, v := range h {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h headerFlag) Set(v string) error {
+	k, val, ok := strings.Cut(v, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("header %q is not key=value", v)
+	}
+	h[k] = val
+	return nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
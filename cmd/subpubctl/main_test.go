@@ -0,0 +1,266 @@
+package main
+
+import (
+	"asyn-subpub-service/internal/metrics"
+	"asyn-subpub-service/internal/services"
+	"asyn-subpub-service/internal/subpub"
+	pb "asyn-subpub-service/pb/proto/api"
+	"bytes"
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer serves sp on a local port and returns its address.
+func startServer(t *testing.T, sp subpub.SubPub) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterPubSubServer(s, services.NewServer(sp))
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(pb.PubSub_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, healthServer)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// syncBuffer lets a test read the output of a command that is still running.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func runCommand(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+	var stdout, stderr syncBuffer
+	err := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestPub(t *testing.T) {
+	sp := subpub.NewSubPub(100)
+	received := make(chan *subpub.Message, 10)
+	sp.Subscribe("orders", func(msg interface{}) { received <- msg.(*subpub.Message) })
+	addr := startServer(t, sp)
+
+	t.Run("Argument", func(t *testing.T) {
+		out, err := runCommand(t, "", "-addr", addr, "pub", "-H", "tenant=acme", "-content-type", "text/plain", "orders", "hello")
+		if err != nil {
+			t.Fatalf("pub failed: %v", err)
+		}
+		if !strings.Contains(out, "matched=1 enqueued=1 dropped=0") {
+			t.Errorf("Unexpected output %q", out)
+		}
+		m := <-received
+		if string(m.Data) != "hello" || m.Headers["tenant"] != "acme" || m.ContentType != "text/plain" {
+			t.Errorf("Unexpected message %+v", m)
+		}
+	})
+
+	t.Run("Stdin", func(t *testing.T) {
+		if _, err := runCommand(t, `{"id":1}`, "-addr", addr, "pub", "orders"); err != nil {
+			t.Fatalf("pub failed: %v", err)
+		}
+		if m := <-received; string(m.Data) != `{"id":1}` {
+			t.Errorf("Unexpected message %+v", m)
+		}
+	})
+
+	t.Run("Lines", func(t *testing.T) {
+		out, err := runCommand(t, "a\n\nb\nc\n", "-addr", addr, "pub", "-lines", "-f", "-", "orders")
+		if err != nil {
+			t.Fatalf("pub failed: %v", err)
+		}
+		if !strings.Contains(out, "published=3 accepted=3") {
+			t.Errorf("Unexpected output %q", out)
+		}
+		for _, want := range []string{"a", "b", "c"} {
+			if m := <-received; string(m.Data) != want {
+				t.Errorf("Expected %s, got %+v", want, m)
+			}
+		}
+	})
+
+	t.Run("Request", func(t *testing.T) {
+		sp.Subscribe("greet", func(msg interface{}) {
+			req := msg.(*subpub.Message)
+			sp.Publish(req.ReplyTo(), &subpub.Message{Data: []byte("hello " + string(req.Data))})
+		})
+		out, err := runCommand(t, "", "-addr", addr, "pub", "-request", "greet", "bob")
+		if err != nil {
+			t.Fatalf("pub failed: %v", err)
+		}
+		if !strings.Contains(out, "hello bob") {
+			t.Errorf("Unexpected output %q", out)
+		}
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		for _, args := range [][]string{{"pub"}, {"pub", "a", "b", "c"}, {"nope"}, {}} {
+			if _, err := runCommand(t, "", append([]string{"-addr", addr}, args...)...); !errors.Is(err, errUsage) {
+				t.Errorf("%v: expected a usage error, got %v", args, err)
+			}
+		}
+	})
+}
+
+func TestSub(t *testing.T) {
+	sp := subpub.NewSubPub(100)
+	addr := startServer(t, sp)
+	sp.Publish("status", &subpub.Message{Data: []byte("up"), Retain: true})
+
+	var stdout, stderr syncBuffer
+	done := make(chan error, 1)
+	go func() {
+		done <- run(context.Background(), []string{"-addr", addr, "sub", "-n", "3", "orders.*", "status"}, nil, &stdout, &stderr)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sp.Subscriptions()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	sp.Publish("orders.eu", &subpub.Message{Data: []byte(`{"id":1}`), Headers: map[string]string{"tenant": "acme"}})
+	sp.Publish("orders.us", &subpub.Message{Data: []byte("plain")})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("sub failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("sub did not exit, output %q", stdout.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{"[status]", "retained", "[orders.eu]", "  tenant: acme", "  \"id\": 1", "[orders.us]", "plain"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in output %q", want, out)
+		}
+	}
+	if !strings.Contains(stderr.String(), "subscribed to orders.*") {
+		t.Errorf("Expected a subscribed notice, got %q", stderr.String())
+	}
+
+	t.Run("Rejected Key", func(t *testing.T) {
+		if _, err := runCommand(t, "", "-addr", addr, "sub", "a..b"); err == nil || !strings.Contains(err.Error(), "a..b") {
+			t.Errorf("Expected an error naming the key, got %v", err)
+		}
+	})
+}
+
+func TestPrintEvent(t *testing.T) {
+	published := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		name  string
+		event *pb.Event
+		want  []string
+	}{
+		{
+			"JSON",
+			&pb.Event{Subject: "orders", Sequence: 7, Id: "x1", Data: []byte(`{"a":[1]}`), ContentType: "application/json",
+				Headers: map[string]string{"b": "2", "a": "1"}, PublishedAt: timestamppb.New(published)},
+			[]string{"[orders] seq=7 id=x1 at=", "  content-type: application/json\n  a: 1\n  b: 2\n", "{\n  \"a\": [\n    1\n  ]\n}\n"},
+		},
+		{
+			"Binary",
+			&pb.Event{Subject: "raw", Data: []byte{0xff, 0x00}},
+			[]string{"[raw]\n", "base64:/wA=\n"},
+		},
+		{
+			"Typed Payload",
+			&pb.Event{Subject: "typed", Payload: &anypb.Any{TypeUrl: "type.googleapis.com/x.Y", Value: []byte{1, 2}}},
+			[]string{"  payload: type.googleapis.com/x.Y (2 bytes)\n"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := printEvent(&b, c.event); err != nil {
+				t.Fatalf("printEvent failed: %v", err)
+			}
+			for _, want := range c.want {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("Expected %q in %q", want, b.String())
+				}
+			}
+		})
+	}
+}
+
+func TestBench(t *testing.T) {
+	addr := startServer(t, subpub.NewSubPub(1000))
+	out, err := runCommand(t, "", "-addr", addr, "bench", "-n", "200", "-c", "2", "-size", "16")
+	if err != nil {
+		t.Fatalf("bench failed: %v", err)
+	}
+	for _, want := range []string{"published 200 messages of 16 bytes", "received  200 of 200 messages", "latency   min="} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in output %q", want, out)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	m := metrics.New()
+	sp := subpub.NewSubPub(100, subpub.WithObserver(m))
+	m.Watch(sp)
+	sp.Subscribe("orders.*", func(msg interface{}) {})
+	sp.Publish("orders.eu", "x")
+	sp.Publish("alerts", "y")
+	addr := startServer(t, sp)
+	metricsServer := httptest.NewServer(m.Handler())
+	defer metricsServer.Close()
+
+	// Delivery is counted asynchronously.
+	deadline := time.Now().Add(time.Second)
+	var out string
+	var row []string
+	for row == nil || row[4] != "1" && time.Now().Before(deadline) {
+		var err error
+		out, err = runCommand(t, "", "-addr", addr, "stats", "-metrics", metricsServer.URL)
+		if err != nil {
+			t.Fatalf("stats failed: %v", err)
+		}
+		row = nil
+		for _, line := range strings.Split(out, "\n") {
+			if fields := strings.Fields(line); len(fields) == 8 && fields[0] == "orders.*" {
+				row = fields
+			}
+		}
+		if row == nil {
+			t.Fatalf("No row for orders.* in %q", out)
+		}
+	}
+	// SUBSCRIPTIONS, CAPACITY and DELIVERED.
+	if row[1] != "1" || row[3] != "100" || row[4] != "1" {
+		t.Errorf("Unexpected subscription row %v", row)
+	}
+	for _, want := range []string{"status: SERVING", "alerts", "orders.eu", "SUBSCRIBED"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in output %q", want, out)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	pb "asyn-subpub-service/pb/proto/api"
+	"bufio"
+	"context"
+	"fmt"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"io"
+	"os"
+	"strings"
+)
+
+// pub publishes the payload given as argument, read from a file or from stdin.
+func pub(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("pub", "[flags] <key> [data]")
+	file := fs.String("f", "", "read the payload from a file, - for stdin (default: stdin when no data is given)")
+	contentType := fs.String("content-type", "", "MIME type of the payload")
+	headers := headerFlag{}
+	fs.Var(headers, "H", "header as key=value; may be repeated")
+	retain := fs.Bool("retain", false, "keep the message as the last value of the key")
+	ttl := fs.Duration("ttl", 0, "discard the message if it is not delivered within this time")
+	lines := fs.Bool("lines", false, "publish every line of the payload as a separate message")
+	request := fs.Bool("request", false, "send the message as a request and print the reply")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 || (fs.NArg() == 2 && *file != "") {
+		fs.Usage()
+		return errUsage
+	}
+
+	var payload io.Reader
+	switch {
+	case fs.NArg() == 2:
+		payload = strings.NewReader(fs.Arg(1))
+	case *file != "" && *file != "-":
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		payload = f
+	default:
+		payload = a.stdin
+	}
+	newRequest := func(data []byte) *pb.PublishRequest {
+		req := &pb.PublishRequest{Key: fs.Arg(0), Data: data, ContentType: *contentType, Retain: *retain}
+		if len(headers) > 0 {
+			req.Headers = headers
+		}
+		if *ttl != 0 {
+			req.Ttl = durationpb.New(*ttl)
+		}
+		return req
+	}
+
+	if *lines {
+		if *request {
+			return fmt.Errorf("-lines cannot be combined with -request")
+		}
+		return publishLines(ctx, a, payload, newRequest)
+	}
+	data, err := io.ReadAll(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	if *request {
+		reply, err := a.api.Request(ctx, newRequest(data))
+		if err != nil {
+			return err
+		}
+		return printEvent(a.stdout, reply)
+	}
+	resp, err := a.api.Publish(ctx, newRequest(data))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "id=%s matched=%d enqueued=%d dropped=%d\n", resp.Id, resp.Matched, resp.Enqueued, resp.Dropped)
+	return nil
+}
+
+// publishLines streams one message per non-empty line and summarizes the outcomes.
+func publishLines(ctx context.Context, a *app, payload io.Reader, newRequest func([]byte) *pb.PublishRequest) error {
+	stream, err := a.api.PublishStream(ctx)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(payload)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		// The scanner reuses its buffer.
+		line := append([]byte(nil), scanner.Bytes()...)
+		if err := stream.Send(newRequest(line)); err != nil {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		stream.CloseSend()
+		return err
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	counts := make(map[pb.PublishResult_Status]int)
+	for i, r := range resp.Results {
+		counts[r.Status]++
+		if r.Status == pb.PublishResult_REJECTED {
+			fmt.Fprintf(a.stderr, "line %d rejected: %s\n", i+1, r.Error)
+		}
+	}
+	fmt.Fprintf(a.stdout, "published=%d accepted=%d dropped=%d no_subscribers=%d rejected=%d\n", len(resp.Results),
+		counts[pb.PublishResult_ACCEPTED], counts[pb.PublishResult_DROPPED],
+		counts[pb.PublishResult_NO_SUBSCRIBERS], counts[pb.PublishResult_REJECTED])
+	return nil
+}
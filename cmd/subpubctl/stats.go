@@ -0,0 +1,134 @@
+package main
+
+import (
+	pb "asyn-subpub-service/pb/proto/api"
+	"context"
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"net/http"
+	"sort"
+	"text/tabwriter"
+)
+
+// stats prints the health of the PubSub service and the broker metrics the
+// server exposes on its metrics port.
+func stats(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("stats", "[flags]")
+	metricsURL := fs.String("metrics", envOr("SUBPUB_METRICS", "http://localhost:9090/metrics"),
+		"URL of the server metrics (env SUBPUB_METRICS); empty skips them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errUsage
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	health, err := healthpb.NewHealthClient(a.conn).Check(ctx,
+		&healthpb.HealthCheckRequest{Service: pb.PubSub_ServiceDesc.ServiceName})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "status: %s\n", health.Status)
+	if *metricsURL == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *metricsURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching metrics: %s", resp.Status)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return fmt.Errorf("parsing metrics: %w", err)
+	}
+	return printStats(a.stdout, families)
+}
+
+// printStats writes a table of published messages by subject and one of
+// subscriptions by subscribed subject.
+func printStats(w io.Writer, families map[string]*dto.MetricFamily) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	published := map[string]map[string]float64{}
+	for _, m := range families["subpub_published_messages_total"].GetMetric() {
+		subject, status := label(m, "subject"), label(m, "status")
+		if published[subject] == nil {
+			published[subject] = map[string]float64{}
+		}
+		published[subject][status] += value(m)
+	}
+	fmt.Fprintln(tw, "\nPUBLISHED\tACCEPTED\tDROPPED\tNO_SUBSCRIBERS")
+	for _, subject := range sortedKeys(published) {
+		counts := published[subject]
+		fmt.Fprintf(tw, "%s\t%.0f\t%.0f\t%.0f\n", subject, counts["accepted"], counts["dropped"], counts["no_subscribers"])
+	}
+
+	columns := []string{
+		"subpub_subscriptions",
+		"subpub_queue_depth",
+		"subpub_queue_capacity",
+		"subpub_delivered_messages_total",
+		"subpub_dropped_messages_total",
+		"subpub_evicted_messages_total",
+		"subpub_expired_messages_total",
+	}
+	subscribed := map[string][]float64{}
+	for i, name := range columns {
+		for _, m := range families[name].GetMetric() {
+			subject := label(m, "subject")
+			if subscribed[subject] == nil {
+				subscribed[subject] = make([]float64, len(columns))
+			}
+			subscribed[subject][i] += value(m)
+		}
+	}
+	fmt.Fprintln(tw, "\nSUBSCRIBED\tSUBSCRIPTIONS\tPENDING\tCAPACITY\tDELIVERED\tDROPPED\tEVICTED\tEXPIRED")
+	for _, subject := range sortedKeys(subscribed) {
+		fmt.Fprint(tw, subject)
+		for _, v := range subscribed[subject] {
+			fmt.Fprintf(tw, "\t%.0f", v)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func label(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func value(m *dto.Metric) float64 {
+	if m.Counter != nil {
+		return m.Counter.GetValue()
+	}
+	return m.GetGauge().GetValue()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
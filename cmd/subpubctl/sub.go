@@ -0,0 +1,160 @@
+package main
+
+import (
+	pb "asyn-subpub-service/pb/proto/api"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// sub tails any number of keys over a single session until interrupted.
+func sub(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("sub", "[flags] <key>...")
+	queue := fs.String("queue", "", "join the queue group on every key")
+	start := fs.String("start", "latest", "where to start: latest, earliest, a sequence number or an RFC 3339 time")
+	asJSON := fs.Bool("json", false, "print every event as a line of JSON")
+	count := fs.Int("n", 0, "exit after this many events; 0 means never")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	startFrom, err := parseStart(*start)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := a.api.Session(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range fs.Args() {
+		req := &pb.SubscribeRequest{Key: key, QueueGroup: *queue, StartFrom: startFrom}
+		// Subscriptions are named after their key.
+		if err := stream.Send(&pb.SessionRequest{Id: key, Command: &pb.SessionRequest_Subscribe{Subscribe: req}}); err != nil {
+			return err
+		}
+	}
+
+	active := fs.NArg()
+	received := 0
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		switch r := resp.Response.(type) {
+		case *pb.SessionResponse_Subscribed:
+			fmt.Fprintf(a.stderr, "subscribed to %s\n", resp.Id)
+		case *pb.SessionResponse_Error:
+			return fmt.Errorf("%s: %w", resp.Id, status.Error(codes.Code(r.Error.Code), r.Error.Message))
+		case *pb.SessionResponse_Unsubscribed:
+			if active--; active == 0 {
+				return nil
+			}
+		case *pb.SessionResponse_Event:
+			if *asJSON {
+				line, err := protojson.Marshal(r.Event)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(a.stdout, "%s\n", line)
+			} else if err := printEvent(a.stdout, r.Event); err != nil {
+				return err
+			}
+			if received++; *count > 0 && received >= *count {
+				return nil
+			}
+		}
+	}
+}
+
+// printEvent writes a summary line, the headers and the payload of the event.
+// JSON is indented, other text printed as is and binary data base64-encoded.
+func printEvent(w io.Writer, e *pb.Event) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s]", e.Subject)
+	if e.Sequence > 0 {
+		fmt.Fprintf(&b, " seq=%d", e.Sequence)
+	}
+	if e.Id != "" {
+		fmt.Fprintf(&b, " id=%s", e.Id)
+	}
+	if e.PublishedAt != nil {
+		fmt.Fprintf(&b, " at=%s", e.PublishedAt.AsTime().Local().Format(time.RFC3339Nano))
+	}
+	if e.Retained {
+		b.WriteString(" retained")
+	}
+	b.WriteString("\n")
+
+	if e.ContentType != "" {
+		fmt.Fprintf(&b, "  content-type: %s\n", e.ContentType)
+	}
+	keys := make([]string, 0, len(e.Headers))
+	for k := range e.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "  %s: %s\n", k, e.Headers[k])
+	}
+
+	var indented bytes.Buffer
+	switch {
+	case e.Payload != nil:
+		fmt.Fprintf(&b, "  payload: %s (%d bytes)\n", e.Payload.TypeUrl, len(e.Payload.Value))
+	case len(e.Data) == 0:
+	case json.Indent(&indented, e.Data, "", "  ") == nil:
+		b.Write(indented.Bytes())
+		b.WriteString("\n")
+	case utf8.Valid(e.Data):
+		b.Write(e.Data)
+		if !bytes.HasSuffix(e.Data, []byte("\n")) {
+			b.WriteString("\n")
+		}
+	default:
+		fmt.Fprintf(&b, "base64:%s\n", base64.StdEncoding.EncodeToString(e.Data))
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// parseStart accepts the positions of the HTTP gateway's start parameter.
+func parseStart(v string) (*pb.StartFrom, error) {
+	switch v {
+	case "", "latest":
+		return nil, nil
+	case "earliest":
+		return &pb.StartFrom{Position: &pb.StartFrom_Earliest{Earliest: &emptypb.Empty{}}}, nil
+	}
+	if seq, err := strconv.ParseUint(v, 10, 64); err == nil {
+		return &pb.StartFrom{Position: &pb.StartFrom_Sequence{Sequence: seq}}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return &pb.StartFrom{Position: &pb.StartFrom_Time{Time: timestamppb.New(t)}}, nil
+	}
+	return nil, fmt.Errorf("invalid start %q", v)
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"asyn-subpub-service/internal/subpub"
+	"fmt"
+)
+
+// Action is an operation a caller performs on a subject.
+type Action int
+
+const (
+	Publish Action = iota
+	Subscribe
+	// Admin inspects and manages the subscriptions of a subject.
+	Admin
+)
+
+func (a Action) String() string {
+	switch a {
+	case Publish:
+		return "publish"
+	case Subscribe:
+		return "subscribe"
+	default:
+		return "admin"
+	}
+}
+
+// AnyPrincipal in a rule matches every caller, including anonymous ones.
+const AnyPrincipal = "*"
+
+// Rule grants a principal access to the subjects matched by the given patterns.
+type Rule struct {
+	Principal string
+	Publish   []string
+	Subscribe []string
+	Admin     []string
+}
+
+// ACL decides which subjects callers may publish, subscribe to and administer. Access is
+// denied unless a rule grants it. A nil ACL allows everything.
+type ACL struct {
+	rules []Rule
+}
+
+// NewACL validates the subject patterns of the rules.
+func NewACL(rules []Rule) (*ACL, error) {
+	for _, r := range rules {
+		if r.Principal == "" {
+			return nil, fmt.Errorf("auth: ACL rule without principal")
+		}
+		for _, p := range append(append(append([]string(nil), r.Publish...), r.Subscribe...), r.Admin...) {
+			// Every valid pattern covers itself.
+			if !subpub.Covers(p, p) {
+				return nil, fmt.Errorf("auth: invalid subject pattern %q for %s", p, r.Principal)
+			}
+		}
+	}
+	return &ACL{rules: rules}, nil
+}
+
+// Allowed reports whether p may perform the action on subject. Subscribing to a
+// wildcard subject requires a grant covering every subject it matches.
+func (a *ACL) Allowed(p *Principal, action Action, subject string) bool {
+	if a == nil {
+		return true
+	}
+	name := ""
+	if p != nil {
+		name = p.Name
+	}
+	for _, r := range a.rules {
+		if r.Principal != AnyPrincipal && (name == "" || r.Principal != name) {
+			continue
+		}
+		patterns := r.Publish
+		switch action {
+		case Subscribe:
+			patterns = r.Subscribe
+		case Admin:
+			patterns = r.Admin
+		}
+		for _, pattern := range patterns {
+			if subpub.Covers(pattern, subject) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+// Package auth authenticates callers by bearer token and authorizes their access
+// to subjects with an access control list.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// ErrUnauthenticated is returned for missing, malformed or unknown credentials.
+var ErrUnauthenticated = errors.New("auth: invalid credentials")
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Name string
+}
+
+// Authenticator resolves a bearer token to the caller it identifies.
+type Authenticator interface {
+	Authenticate(token string) (*Principal, error)
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal stored in ctx, or nil for anonymous callers.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// StaticTokens authenticates callers against a fixed set of tokens.
+type StaticTokens struct {
+	tokens map[string]string
+}
+
+// NewStaticTokens creates an authenticator from a map of token to principal name.
+func NewStaticTokens(tokens map[string]string) *StaticTokens {
+	return &StaticTokens{tokens: tokens}
+}
+
+func (a *StaticTokens) Authenticate(token string) (*Principal, error) {
+	// Compare against every token so the time taken does not reveal a partial match.
+	var name string
+	for t, n := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			name = n
+		}
+	}
+	if token == "" || name == "" {
+		return nil, ErrUnauthenticated
+	}
+	return &Principal{Name: name}, nil
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer <token>" header value.
+func BearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// healthService is reachable without credentials so that probes keep working.
+const healthService = "/grpc.health.v1.Health/"
+
+// UnaryServerInterceptor authenticates unary RPCs and stores the principal in their context.
+func UnaryServerInterceptor(a Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthService) {
+			return handler(ctx, req)
+		}
+		ctx, err := authenticate(ctx, a)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authenticates streaming RPCs and stores the principal in their context.
+func StreamServerInterceptor(a Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, healthService) {
+			return handler(srv, ss)
+		}
+		ctx, err := authenticate(ss.Context(), a)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func authenticate(ctx context.Context, a Authenticator) (context.Context, error) {
+	values := metadata.ValueFromIncomingContext(ctx, "authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	token, ok := BearerToken(values[0])
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "malformed authorization header")
+	}
+	p, err := a.Authenticate(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid bearer token")
+	}
+	return NewContext(ctx, p), nil
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaticTokens(t *testing.T) {
+	a := NewStaticTokens(map[string]string{"secret": "ingest", "other": "reader"})
+	p, err := a.Authenticate("secret")
+	if err != nil || p.Name != "ingest" {
+		t.Errorf("Expected ingest, got %v (err %v)", p, err)
+	}
+	for _, token := range []string{"", "secre", "unknown"} {
+		if _, err := a.Authenticate(token); err == nil {
+			t.Errorf("Expected %q to be rejected", token)
+		}
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	a := NewStaticTokens(map[string]string{"secret": "ingest"})
+	unary := UnaryServerInterceptor(a)
+	var seen *Principal
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		seen = FromContext(ctx)
+		return nil, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/PubSub/Publish"}
+
+	cases := []struct {
+		name   string
+		header []string
+		code   codes.Code
+	}{
+		{"Valid Token", []string{"authorization", "Bearer secret"}, codes.OK},
+		{"Lower Case Scheme", []string{"authorization", "bearer secret"}, codes.OK},
+		{"Missing Header", nil, codes.Unauthenticated},
+		{"Wrong Scheme", []string{"authorization", "Basic secret"}, codes.Unauthenticated},
+		{"Unknown Token", []string{"authorization", "Bearer nope"}, codes.Unauthenticated},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			seen = nil
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(c.header...))
+			_, err := unary(ctx, nil, info, handler)
+			if status.Code(err) != c.code {
+				t.Fatalf("Expected %v, got %v", c.code, err)
+			}
+			if c.code == codes.OK && (seen == nil || seen.Name != "ingest") {
+				t.Errorf("Expected principal in handler context, got %v", seen)
+			}
+		})
+	}
+
+	t.Run("Health Is Exempt", func(t *testing.T) {
+		_, err := unary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, handler)
+		if err != nil {
+			t.Errorf("Expected health check without credentials, got %v", err)
+		}
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		stream := StreamServerInterceptor(a)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+		err := stream(nil, &fakeStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/PubSub/Subscribe"},
+			func(srv interface{}, ss grpc.ServerStream) error {
+				seen = FromContext(ss.Context())
+				return nil
+			})
+		if err != nil || seen == nil || seen.Name != "ingest" {
+			t.Errorf("Expected principal in stream context, got %v (err %v)", seen, err)
+		}
+		err = stream(nil, &fakeStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/PubSub/Subscribe"},
+			func(srv interface{}, ss grpc.ServerStream) error { return nil })
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Expected Unauthenticated, got %v", err)
+		}
+	})
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+	}})
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, jwks, 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	a, err := NewJWT(file, "issuer", "subpub")
+	if err != nil {
+		t.Fatalf("NewJWT failed: %v", err)
+	}
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("SignedString failed: %v", err)
+		}
+		return s
+	}
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"sub": "ingest", "iss": "issuer", "aud": "subpub", "exp": time.Now().Add(time.Hour).Unix()}
+	}
+
+	for _, token := range []string{
+		sign(jwt.SigningMethodRS256, "rsa", rsaKey, valid()),
+		sign(jwt.SigningMethodES256, "ec", ecKey, valid()),
+	} {
+		p, err := a.Authenticate(token)
+		if err != nil || p.Name != "ingest" {
+			t.Errorf("Expected ingest, got %v (err %v)", p, err)
+		}
+	}
+
+	expired := valid()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	noExpiry := valid()
+	delete(noExpiry, "exp")
+	wrongAudience := valid()
+	wrongAudience["aud"] = "other"
+	noSubject := valid()
+	delete(noSubject, "sub")
+	rejected := map[string]string{
+		"Expired":        sign(jwt.SigningMethodRS256, "rsa", rsaKey, expired),
+		"No Expiry":      sign(jwt.SigningMethodRS256, "rsa", rsaKey, noExpiry),
+		"Wrong Audience": sign(jwt.SigningMethodRS256, "rsa", rsaKey, wrongAudience),
+		"No Subject":     sign(jwt.SigningMethodRS256, "rsa", rsaKey, noSubject),
+		"Wrong Key":      sign(jwt.SigningMethodRS256, "ec", rsaKey, valid()),
+		"Unknown Key":    sign(jwt.SigningMethodRS256, "missing", rsaKey, valid()),
+		"HMAC":           sign(jwt.SigningMethodHS256, "hmac", []byte("secret"), valid()),
+		"Garbage":        "not.a.token",
+	}
+	for name, token := range rejected {
+		if _, err := a.Authenticate(token); err == nil {
+			t.Errorf("%s: expected token to be rejected", name)
+		}
+	}
+
+	if _, err := NewJWT(filepath.Join(t.TempDir(), "missing.json"), "", ""); err == nil {
+		t.Error("Expected error for missing JWKS file")
+	}
+}
+
+func TestACL(t *testing.T) {
+	acl, err := NewACL([]Rule{
+		{Principal: "ingest", Publish: []string{"telemetry.>"}},
+		{Principal: "dashboard", Subscribe: []string{"telemetry.*.summary", "alerts"}},
+		{Principal: AnyPrincipal, Subscribe: []string{"public.>"}},
+		{Principal: "operator", Admin: []string{"telemetry.>"}},
+	})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	ingest, dashboard, operator := &Principal{Name: "ingest"}, &Principal{Name: "dashboard"}, &Principal{Name: "operator"}
+	cases := []struct {
+		p       *Principal
+		action  Action
+		subject string
+		want    bool
+	}{
+		{ingest, Publish, "telemetry.eu.cpu", true},
+		{ingest, Subscribe, "telemetry.eu.cpu", false},
+		{ingest, Publish, "alerts", false},
+		{dashboard, Subscribe, "telemetry.eu.summary", true},
+		{dashboard, Subscribe, "telemetry.*.summary", true},
+		{dashboard, Subscribe, "telemetry.>", false},
+		{dashboard, Publish, "alerts", false},
+		{nil, Subscribe, "public.news", true},
+		{nil, Subscribe, "alerts", false},
+		{ingest, Subscribe, "public.>", true},
+		{operator, Admin, "telemetry.eu.cpu", true},
+		{operator, Admin, ">", false},
+		{operator, Publish, "telemetry.eu.cpu", false},
+		{ingest, Admin, "telemetry.eu.cpu", false},
+	}
+	for _, c := range cases {
+		if got := acl.Allowed(c.p, c.action, c.subject); got != c.want {
+			t.Errorf("Allowed(%v, %v, %q) = %v, want %v", c.p, c.action, c.subject, got, c.want)
+		}
+	}
+
+	var none *ACL
+	if !none.Allowed(nil, Publish, "anything") {
+		t.Error("Expected nil ACL to allow everything")
+	}
+	if _, err := NewACL([]Rule{{Principal: "x", Publish: []string{"a.>.b"}}}); err == nil {
+		t.Error("Expected invalid pattern to be rejected")
+	}
+	if _, err := NewACL([]Rule{{Publish: []string{"a"}}}); err == nil {
+		t.Error("Expected rule without principal to be rejected")
+	}
+}
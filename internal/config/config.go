@@ -1,21 +1,120 @@
 package config
 
 import (
+	"errors"
 	"github.com/ilyakaznacheev/cleanenv"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"time"
 )
 
 type Config struct {
 	Server struct {
 		GRPCPort int `yaml:"GRPC_PORT" env:"GRPC_PORT" env-default:"50051"`
-	}
+		// HTTPPort serves the HTTP/JSON and Server-Sent Events gateway.
+		HTTPPort int `yaml:"HTTP_PORT" env:"HTTP_PORT" env-default:"8080"`
+		// HTTPDisabled turns the gateway off; a zero HTTPPort falls back to the default.
+		HTTPDisabled bool `yaml:"HTTP_DISABLED" env:"HTTP_DISABLED" env-default:"false"`
+		// AllowedOrigins lists the browser origins, besides the gateway's own, that may open WebSocket connections.
+		AllowedOrigins  []string `yaml:"ALLOWED_ORIGINS" env:"ALLOWED_ORIGINS"`
+		ForwardMetadata []string `yaml:"FORWARD_METADATA" env:"FORWARD_METADATA" env-default:"x-request-id,traceparent,tracestate"`
+		RequireDelivery bool     `yaml:"REQUIRE_DELIVERY" env:"REQUIRE_DELIVERY" env-default:"false"`
+		Reflection      bool     `yaml:"REFLECTION" env:"REFLECTION" env-default:"false"`
+		// Admin registers the Admin service for inspecting and managing subscriptions.
+		Admin bool `yaml:"ADMIN" env:"ADMIN" env-default:"false"`
+		// RequestTimeout bounds how long a Request call waits for its reply.
+		RequestTimeout time.Duration `yaml:"REQUEST_TIMEOUT" env:"REQUEST_TIMEOUT" env-default:"30s"`
+	} `yaml:"SERVER"`
 	SubPub struct {
-		BufferSize int `yaml:"BUFFER_SIZE" env:"BUFFER_SIZE" env-default:"100"`
+		BufferSize     int           `yaml:"BUFFER_SIZE" env:"BUFFER_SIZE" env-default:"100"`
+		OverflowPolicy string        `yaml:"OVERFLOW_POLICY" env:"OVERFLOW_POLICY" env-default:"drop_newest"`
+		BlockTimeout   time.Duration `yaml:"BLOCK_TIMEOUT" env:"BLOCK_TIMEOUT" env-default:"1s"`
+		QueueStrategy  string        `yaml:"QUEUE_STRATEGY" env:"QUEUE_STRATEGY" env-default:"round_robin"`
+		LogDir         string        `yaml:"LOG_DIR" env:"LOG_DIR"`
+		LogSegmentSize int64         `yaml:"LOG_SEGMENT_SIZE" env:"LOG_SEGMENT_SIZE" env-default:"67108864"`
+		LogSync        bool          `yaml:"LOG_SYNC" env:"LOG_SYNC" env-default:"false"`
+		// LogMaxOpenSegments caps the segment files kept open for appending.
+		LogMaxOpenSegments int `yaml:"LOG_MAX_OPEN_SEGMENTS" env:"LOG_MAX_OPEN_SEGMENTS" env-default:"256"`
+		// RetainedLimit caps the memory in bytes held by retained messages; a negative
+		// value means no cap, while 0 falls back to the default.
+		RetainedLimit int64 `yaml:"RETAINED_LIMIT" env:"RETAINED_LIMIT" env-default:"67108864"`
+		// TTL sets default message TTLs by subject pattern; the first matching rule applies.
+		TTL []TTLRule `yaml:"TTL"`
+	} `yaml:"SUBPUB"`
+	Ack struct {
+		Deadline         time.Duration `yaml:"ACK_DEADLINE" env:"ACK_DEADLINE" env-default:"30s"`
+		MaxDeliveries    int           `yaml:"MAX_DELIVERIES" env:"MAX_DELIVERIES" env-default:"5"`
+		DeadLetterPrefix string        `yaml:"DEAD_LETTER_PREFIX" env:"DEAD_LETTER_PREFIX" env-default:"$DLQ"`
+		// MaxInFlight caps the events of a stream awaiting their ack.
+		MaxInFlight int `yaml:"MAX_IN_FLIGHT" env:"MAX_IN_FLIGHT" env-default:"1000"`
+	} `yaml:"ACK"`
+	TLS struct {
+		// TLS is enabled when a certificate is set; a client CA enables mutual TLS.
+		CertFile       string        `yaml:"TLS_CERT_FILE" env:"TLS_CERT_FILE"`
+		KeyFile        string        `yaml:"TLS_KEY_FILE" env:"TLS_KEY_FILE"`
+		ClientCAFile   string        `yaml:"TLS_CLIENT_CA_FILE" env:"TLS_CLIENT_CA_FILE"`
+		ReloadInterval time.Duration `yaml:"TLS_RELOAD_INTERVAL" env:"TLS_RELOAD_INTERVAL" env-default:"10s"`
+	} `yaml:"TLS"`
+	Auth struct {
+		// Mode is none, token or jwt.
+		Mode string `yaml:"AUTH_MODE" env:"AUTH_MODE" env-default:"none"`
+		// Tokens maps static bearer tokens to principal names.
+		Tokens      map[string]string `yaml:"AUTH_TOKENS" env:"AUTH_TOKENS"`
+		JWKSFile    string            `yaml:"JWKS_FILE" env:"JWKS_FILE"`
+		JWTIssuer   string            `yaml:"JWT_ISSUER" env:"JWT_ISSUER"`
+		JWTAudience string            `yaml:"JWT_AUDIENCE" env:"JWT_AUDIENCE"`
+		// ACL grants principals access to subject patterns; without rules everything is allowed.
+		ACL []ACLRule `yaml:"ACL"`
+	} `yaml:"AUTH"`
+	Metrics struct {
+		// Port of the HTTP server exposing /metrics.
+		Port int `yaml:"METRICS_PORT" env:"METRICS_PORT" env-default:"9090"`
+		// Disabled turns the metrics server off; a zero Port falls back to the default.
+		Disabled bool `yaml:"METRICS_DISABLED" env:"METRICS_DISABLED" env-default:"false"`
+	} `yaml:"METRICS"`
+}
+
+// TTLRule discards messages published to subjects matched by Subject once TTL
+// has passed, unless the publisher sets a TTL of its own.
+type TTLRule struct {
+	Subject string        `yaml:"SUBJECT"`
+	TTL     time.Duration `yaml:"TTL"`
+}
+
+// ACLRule grants a principal, or "*" for every caller, publish, subscribe and admin access.
+type ACLRule struct {
+	Principal string   `yaml:"PRINCIPAL"`
+	Publish   []string `yaml:"PUBLISH"`
+	Subscribe []string `yaml:"SUBSCRIBE"`
+	Admin     []string `yaml:"ADMIN"`
+}
+
+// legacySections maps the lower case section names read before the sections
+// were given upper case names to their current names.
+var legacySections = map[string]string{
+	"server": "SERVER",
+	"subpub": "SUBPUB",
+}
+
+// UnmarshalYAML reads the sections under their current names and, for older
+// config files, under the lower case names in legacySections.
+func (c *Config) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			if name, ok := legacySections[value.Content[i].Value]; ok {
+				value.Content[i].Value = name
+			}
+		}
 	}
+	type plain Config
+	return value.Decode((*plain)(c))
 }
 
+// New reads the config file at path, if it exists, and then the environment.
 func New(path string) (*Config, error) {
 	var cfg Config
-	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
+	if err := cleanenv.ReadConfig(path, &cfg); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
 	}
 
 	if err := cleanenv.ReadEnv(&cfg); err != nil {
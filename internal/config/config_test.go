@@ -4,16 +4,19 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
 	t.Run("Valid YAML file", func(t *testing.T) {
 		yamlContent := `
-			server:
-			  GRPC_PORT: 50051
-			subpub:
-			  BUFFER_SIZE: 100`
+server:
+  GRPC_PORT: 50051
+subpub:
+  BUFFER_SIZE: 100`
 
 		tmpFile, err := ioutil.TempFile("", "config-*.yaml")
 		if err != nil {
@@ -39,6 +42,173 @@ func TestNew(t *testing.T) {
 		if cfg.SubPub.BufferSize != 100 {
 			t.Errorf("Expected BUFFER_SIZE 100, got %d", cfg.SubPub.BufferSize)
 		}
+		if cfg.SubPub.OverflowPolicy != "drop_newest" {
+			t.Errorf("Expected default OVERFLOW_POLICY drop_newest, got %s", cfg.SubPub.OverflowPolicy)
+		}
+		if cfg.SubPub.BlockTimeout != time.Second {
+			t.Errorf("Expected default BLOCK_TIMEOUT 1s, got %v", cfg.SubPub.BlockTimeout)
+		}
+		if cfg.SubPub.RetainedLimit != 64<<20 {
+			t.Errorf("Expected default RETAINED_LIMIT of 64 MiB, got %d", cfg.SubPub.RetainedLimit)
+		}
+		if strings.Join(cfg.Server.ForwardMetadata, ",") != "x-request-id,traceparent,tracestate" {
+			t.Errorf("Expected default FORWARD_METADATA, got %v", cfg.Server.ForwardMetadata)
+		}
+		if cfg.Server.RequireDelivery {
+			t.Error("Expected REQUIRE_DELIVERY to be off by default")
+		}
+		if cfg.Server.Reflection {
+			t.Error("Expected REFLECTION to be off by default")
+		}
+		if cfg.Server.Admin {
+			t.Error("Expected ADMIN to be off by default")
+		}
+		if cfg.Server.RequestTimeout != 30*time.Second {
+			t.Errorf("Expected default REQUEST_TIMEOUT of 30s, got %v", cfg.Server.RequestTimeout)
+		}
+		if cfg.TLS.CertFile != "" || cfg.TLS.ReloadInterval != 10*time.Second {
+			t.Errorf("Expected plaintext with a 10s reload interval by default, got %+v", cfg.TLS)
+		}
+		if cfg.Auth.Mode != "none" || len(cfg.Auth.ACL) != 0 {
+			t.Errorf("Expected auth to be disabled by default, got %+v", cfg.Auth)
+		}
+		if cfg.Server.HTTPPort != 8080 {
+			t.Errorf("Expected default HTTP_PORT 8080, got %d", cfg.Server.HTTPPort)
+		}
+		if cfg.Metrics.Port != 9090 {
+			t.Errorf("Expected default METRICS_PORT 9090, got %d", cfg.Metrics.Port)
+		}
+		if cfg.Server.HTTPDisabled {
+			t.Error("Expected the HTTP gateway to be on by default")
+		}
+		if cfg.Metrics.Disabled {
+			t.Error("Expected the metrics server to be on by default")
+		}
+		if cfg.Ack.Deadline != 30*time.Second || cfg.Ack.MaxDeliveries != 5 || cfg.Ack.DeadLetterPrefix != "$DLQ" || cfg.Ack.MaxInFlight != 1000 {
+			t.Errorf("Expected default ack settings, got %+v", cfg.Ack)
+		}
+	})
+
+	t.Run("Upper Case Sections", func(t *testing.T) {
+		yamlContent := "SERVER:\n  GRPC_PORT: 6000\nSUBPUB:\n  BUFFER_SIZE: 7\n  OVERFLOW_POLICY: block\nACK:\n  ACK_DEADLINE: 2s\n  MAX_DELIVERIES: 3\n  DEAD_LETTER_PREFIX: dead\n"
+		tmpFile, err := ioutil.TempFile("", "config-*.yaml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+
+		if _, err := tmpFile.Write([]byte(yamlContent)); err != nil {
+			t.Fatalf("Failed to write to temp file: %v", err)
+		}
+		tmpFile.Close()
+
+		cfg, err := New(tmpFile.Name())
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if cfg.Server.GRPCPort != 6000 || cfg.SubPub.BufferSize != 7 || cfg.SubPub.OverflowPolicy != "block" {
+			t.Errorf("Expected server and subpub sections to be read, got %+v %+v", cfg.Server, cfg.SubPub)
+		}
+		if cfg.Ack.Deadline != 2*time.Second || cfg.Ack.MaxDeliveries != 3 || cfg.Ack.DeadLetterPrefix != "dead" {
+			t.Errorf("Expected ack section to be read, got %+v", cfg.Ack)
+		}
+	})
+
+	t.Run("Lower Case Sections", func(t *testing.T) {
+		tmpFile, err := ioutil.TempFile("", "config-*.yaml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+		tmpFile.Write([]byte("server:\n  GRPC_PORT: 6001\nsubpub:\n  BUFFER_SIZE: 8\n"))
+		tmpFile.Close()
+
+		cfg, err := New(tmpFile.Name())
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if cfg.Server.GRPCPort != 6001 || cfg.SubPub.BufferSize != 8 {
+			t.Errorf("Expected lower case server and subpub sections to be read, got %+v %+v", cfg.Server, cfg.SubPub)
+		}
+	})
+
+	t.Run("Auth Section", func(t *testing.T) {
+		yamlContent := `
+AUTH:
+  AUTH_MODE: token
+  AUTH_TOKENS:
+    secret: ingest
+  ACL:
+    - PRINCIPAL: ingest
+      PUBLISH: [telemetry.>]
+    - PRINCIPAL: "*"
+      SUBSCRIBE: [telemetry.*]
+      ADMIN: [telemetry.>]
+`
+		tmpFile, err := ioutil.TempFile("", "config-*.yaml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+		tmpFile.Write([]byte(yamlContent))
+		tmpFile.Close()
+
+		cfg, err := New(tmpFile.Name())
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if cfg.Auth.Mode != "token" || cfg.Auth.Tokens["secret"] != "ingest" {
+			t.Errorf("Expected token auth, got %+v", cfg.Auth)
+		}
+		if len(cfg.Auth.ACL) != 2 || cfg.Auth.ACL[0].Publish[0] != "telemetry.>" || cfg.Auth.ACL[1].Principal != "*" ||
+			len(cfg.Auth.ACL[1].Admin) != 1 {
+			t.Errorf("Expected two ACL rules, got %+v", cfg.Auth.ACL)
+		}
+	})
+
+	t.Run("TTL Rules", func(t *testing.T) {
+		yamlContent := `
+SUBPUB:
+  TTL:
+    - SUBJECT: status.>
+      TTL: 30s
+    - SUBJECT: ">"
+      TTL: 1h
+`
+		tmpFile, err := ioutil.TempFile("", "config-*.yaml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+		tmpFile.Write([]byte(yamlContent))
+		tmpFile.Close()
+
+		cfg, err := New(tmpFile.Name())
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		want := []TTLRule{{Subject: "status.>", TTL: 30 * time.Second}, {Subject: ">", TTL: time.Hour}}
+		if !reflect.DeepEqual(cfg.SubPub.TTL, want) {
+			t.Errorf("Expected %+v, got %+v", want, cfg.SubPub.TTL)
+		}
+	})
+
+	t.Run("Unlimited Retained Messages", func(t *testing.T) {
+		tmpFile, err := ioutil.TempFile("", "config-*.yaml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+		tmpFile.Write([]byte("SUBPUB:\n  RETAINED_LIMIT: -1\n"))
+		tmpFile.Close()
+
+		cfg, err := New(tmpFile.Name())
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if cfg.SubPub.RetainedLimit != -1 {
+			t.Errorf("Expected RETAINED_LIMIT -1, got %d", cfg.SubPub.RetainedLimit)
+		}
 	})
 
 	t.Run("Missing file with env vars", func(t *testing.T) {
@@ -64,10 +234,10 @@ func TestNew(t *testing.T) {
 
 	t.Run("Invalid YAML file", func(t *testing.T) {
 		yamlContent := `
-			server:
-			  GRPC_PORT: invalid
-			subpub:
-			  BUFFER_SIZE: 20`
+server:
+  GRPC_PORT: invalid
+subpub:
+  BUFFER_SIZE: 20`
 		tmpFile, err := ioutil.TempFile("", "config-*.yaml")
 		if err != nil {
 			t.Fatalf("Failed to create temp file: %v", err)
@@ -80,7 +250,7 @@ func TestNew(t *testing.T) {
 		tmpFile.Close()
 
 		cfg, err := New(tmpFile.Name())
-		if err != nil {
+		if err == nil {
 			t.Fatalf("Expected error for invalid YAML, got cfg: %+v", cfg)
 		}
 	})
@@ -0,0 +1,316 @@
+// Package gateway exposes the PubSub service over HTTP for clients that cannot
+// speak gRPC: messages are published with POST requests and subscriptions are
+// streamed as Server-Sent Events. Requests go through the same services.Server as
+// gRPC calls, so authorization, metadata forwarding and delivery limits match.
+package gateway
+
+import (
+	"asyn-subpub-service/internal/auth"
+	"asyn-subpub-service/internal/services"
+	"asyn-subpub-service/pb/proto/api"
+	"context"
+	"encoding/json"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxPublishSize is the largest request body accepted by the publish endpoint,
+// matching the default gRPC message size limit.
+const MaxPublishSize = 4 << 20
+
+// DefaultKeepAlive is how often an idle event stream receives a comment line, so
+// that proxies do not close it.
+const DefaultKeepAlive = 15 * time.Second
+
+// Gateway serves POST /v1/publish/{key}, GET /v1/subscribe/{key},
+// DELETE /v1/retained/{key} and the WebSocket endpoint GET /v1/ws.
+type Gateway struct {
+	server         *services.Server
+	authenticator  auth.Authenticator
+	keepAlive      time.Duration
+	allowedOrigins []string
+	mux            *http.ServeMux
+}
+
+// Option configures a Gateway created by New.
+type Option func(*Gateway)
+
+// WithAuthenticator requires every request to carry a bearer token accepted by a.
+func WithAuthenticator(a auth.Authenticator) Option {
+	return func(g *Gateway) {
+		g.authenticator = a
+	}
+}
+
+// WithAllowedOrigins lets browser pages from other origins open WebSocket
+// connections; "*" allows every origin.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(g *Gateway) {
+		g.allowedOrigins = append(g.allowedOrigins, origins...)
+	}
+}
+
+// WithKeepAlive sets how often idle event streams receive a keepalive comment and
+// WebSocket connections a ping.
+func WithKeepAlive(d time.Duration) Option {
+	return func(g *Gateway) {
+		if d > 0 {
+			g.keepAlive = d
+		}
+	}
+}
+
+func New(server *services.Server, opts ...Option) *Gateway {
+	if server == nil {
+		panic("server is nil")
+	}
+	g := &Gateway{
+		server:    server,
+		keepAlive: DefaultKeepAlive,
+		mux:       http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.mux.HandleFunc("POST /v1/publish/{key}", g.publish)
+	g.mux.HandleFunc("GET /v1/subscribe/{key}", g.subscribe)
+	g.mux.HandleFunc("DELETE /v1/retained/{key}", g.clearRetained)
+	g.mux.HandleFunc("GET /v1/ws", g.websocket)
+	return g
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := metadata.NewIncomingContext(r.Context(), incomingMetadata(r.Header))
+	if g.authenticator != nil {
+		token, ok := auth.BearerToken(r.Header.Get("Authorization"))
+		if !ok && r.Header.Get("Authorization") == "" {
+			// Browsers cannot set headers on EventSource and WebSocket requests.
+			token = r.URL.Query().Get("access_token")
+			ok = token != ""
+		}
+		if !ok {
+			writeError(w, status.Error(codes.Unauthenticated, "missing bearer token"))
+			return
+		}
+		p, err := g.authenticator.Authenticate(token)
+		if err != nil {
+			writeError(w, status.Error(codes.Unauthenticated, "invalid bearer token"))
+			return
+		}
+		ctx = auth.NewContext(ctx, p)
+	}
+	g.mux.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// publish sends the request body as the message data, typed by its Content-Type.
+// The query parameter retain=true keeps it as the last value of the key and ttl,
+// a duration such as 30s, discards it when it cannot be delivered in time.
+func (g *Gateway) publish(w http.ResponseWriter, r *http.Request) {
+	retain, err := parseBool(r.URL.Query().Get("retain"))
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "invalid retain %q", r.URL.Query().Get("retain")))
+		return
+	}
+	ttl, err := parseTTL(r.URL.Query().Get("ttl"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxPublishSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeErrorStatus(w, http.StatusRequestEntityTooLarge, codes.ResourceExhausted, "message too large")
+			return
+		}
+		writeError(w, status.Errorf(codes.InvalidArgument, "failed to read body: %v", err))
+		return
+	}
+	resp, err := g.server.Publish(r.Context(), &pb.PublishRequest{
+		Key:         r.PathValue("key"),
+		Data:        body,
+		ContentType: r.Header.Get("Content-Type"),
+		Retain:      retain,
+		Ttl:         ttl,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeProto(w, http.StatusOK, resp)
+}
+
+func (g *Gateway) clearRetained(w http.ResponseWriter, r *http.Request) {
+	resp, err := g.server.ClearRetained(r.Context(), &pb.ClearRetainedRequest{Key: r.PathValue("key")})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeProto(w, http.StatusOK, resp)
+}
+
+// parseTTL parses a duration such as 30s, returning nil for an empty value.
+func parseTTL(v string) (*durationpb.Duration, error) {
+	if v == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid ttl %q", v)
+	}
+	return durationpb.New(d), nil
+}
+
+func parseBool(v string) (bool, error) {
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+// subscribe streams the events of the key. The query parameters queue_group and
+// start (earliest, latest, a sequence number or an RFC 3339 time) mirror
+// SubscribeRequest; a Last-Event-ID header resumes after the given sequence.
+func (g *Gateway) subscribe(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, status.Error(codes.Internal, "streaming is not supported"))
+		return
+	}
+	start, err := startFrom(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	req := &pb.SubscribeRequest{
+		Key:        r.PathValue("key"),
+		QueueGroup: r.URL.Query().Get("queue_group"),
+		StartFrom:  start,
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	stream := &eventStream{w: w, flusher: flusher, cancel: cancel}
+	keepAliveDone := make(chan struct{})
+	go func() {
+		defer close(keepAliveDone)
+		stream.keepAlive(ctx, g.keepAlive)
+	}()
+	err = g.server.StreamEvents(ctx, req, stream.start, stream.send)
+	// The response must not be written once the handler returns.
+	cancel()
+	<-keepAliveDone
+	started := stream.close()
+	switch {
+	case err == nil:
+	case started:
+		stream.sendError(err)
+	default:
+		writeError(w, err)
+	}
+}
+
+func startFrom(r *http.Request) (*pb.StartFrom, error) {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		seq, err := parseSequence(id)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid Last-Event-ID %q", id)
+		}
+		return &pb.StartFrom{Position: &pb.StartFrom_Sequence{Sequence: seq + 1}}, nil
+	}
+	return parseStart(r.URL.Query().Get("start"))
+}
+
+// incomingMetadata exposes the request headers to the service the way gRPC metadata would be.
+func incomingMetadata(h http.Header) metadata.MD {
+	md := make(metadata.MD, len(h))
+	for name, values := range h {
+		md[strings.ToLower(name)] = values
+	}
+	return md
+}
+
+var protoJSON = protojson.MarshalOptions{UseProtoNames: true}
+
+func writeProto(w http.ResponseWriter, code int, m proto.Message) {
+	body, err := protoJSON.Marshal(m)
+	if err != nil {
+		writeError(w, status.Errorf(codes.Internal, "failed to encode response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// errorBody is the JSON body of failed requests and of error events.
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newErrorBody(code codes.Code, message string) errorBody {
+	return errorBody{Code: strings.ToUpper(snakeCase(code.String())), Message: message}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	writeErrorStatus(w, httpStatus(st.Code()), st.Code(), st.Message())
+}
+
+func writeErrorStatus(w http.ResponseWriter, httpCode int, code codes.Code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpCode)
+	json.NewEncoder(w).Encode(newErrorBody(code, message))
+}
+
+// httpStatus maps gRPC status codes to HTTP status codes.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		return 499
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// snakeCase turns a CamelCase status code name into snake_case.
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
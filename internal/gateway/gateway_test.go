@@ -0,0 +1,311 @@
+package gateway
+
+import (
+	"asyn-subpub-service/internal/auth"
+	"asyn-subpub-service/internal/msglog"
+	"asyn-subpub-service/internal/services"
+	"asyn-subpub-service/internal/subpub"
+	"asyn-subpub-service/pb/proto/api"
+	"bufio"
+	"context"
+	"encoding/json"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublish(t *testing.T) {
+	sp := subpub.NewSubPub(100)
+	received := make(chan *subpub.Message, 1)
+	if _, err := sp.Subscribe("orders", func(msg interface{}) { received <- msg.(*subpub.Message) }); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	srv := httptest.NewServer(New(services.NewServer(sp, services.WithForwardedMetadata("x-request-id"))))
+	defer srv.Close()
+
+	t.Run("Successful Publish", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/publish/orders", strings.NewReader(`{"id":1}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-Id", "abc")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", resp.StatusCode)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		var out pb.PublishResponse
+		if err := protojson.Unmarshal(body, &out); err != nil {
+			t.Fatalf("Invalid response %s: %v", body, err)
+		}
+		if out.Id == "" || out.Matched != 1 || out.Enqueued != 1 {
+			t.Errorf("Unexpected response %s", body)
+		}
+		select {
+		case m := <-received:
+			if string(m.Data) != `{"id":1}` || m.ContentType != "application/json" || m.Headers["x-request-id"] != "abc" {
+				t.Errorf("Unexpected message %+v", m)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Message not delivered")
+		}
+	})
+
+	t.Run("Invalid Key", func(t *testing.T) {
+		resp, err := http.Post(srv.URL+"/v1/publish/a..b", "text/plain", strings.NewReader("x"))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var body errorBody
+		json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != http.StatusBadRequest || body.Code != "INVALID_ARGUMENT" {
+			t.Errorf("Expected 400 INVALID_ARGUMENT, got %d %+v", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		resp, err := http.Post(srv.URL+"/v1/publish/orders?ttl=30s", "text/plain", strings.NewReader("x"))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		if m := <-received; m.TTL != 30*time.Second {
+			t.Errorf("Expected a 30s TTL, got %v", m.TTL)
+		}
+		for _, ttl := range []string{"soon", "-1s"} {
+			resp, err := http.Post(srv.URL+"/v1/publish/orders?ttl="+ttl, "text/plain", strings.NewReader("x"))
+			if err != nil {
+				t.Fatalf("POST failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected 400 for ttl %s, got %d", ttl, resp.StatusCode)
+			}
+		}
+	})
+
+	t.Run("Too Large", func(t *testing.T) {
+		resp, err := http.Post(srv.URL+"/v1/publish/orders", "text/plain", strings.NewReader(strings.Repeat("x", MaxPublishSize+1)))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected 413, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Retain And Clear", func(t *testing.T) {
+		resp, err := http.Post(srv.URL+"/v1/publish/status?retain=true", "text/plain", strings.NewReader("up"))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/v1/retained/status", nil)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("DELETE failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var out pb.ClearRetainedResponse
+		body, _ := io.ReadAll(resp.Body)
+		if err := protojson.Unmarshal(body, &out); err != nil || out.Cleared != 1 {
+			t.Errorf("Expected one cleared key, got %s (err %v)", body, err)
+		}
+	})
+
+	t.Run("Wrong Method", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/v1/publish/orders")
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("Expected 405, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Run("Stream Events", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		srv := httptest.NewServer(New(services.NewServer(sp), WithKeepAlive(20*time.Millisecond)))
+		defer srv.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		resp, events := subscribe(t, ctx, srv.URL+"/v1/subscribe/orders.*", nil)
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Expected text/event-stream, got %q", ct)
+		}
+		// The headers are sent once the subscription is registered.
+		if err := sp.Publish("orders.new", &subpub.Message{Data: []byte("hello")}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		e := next(t, events)
+		if e.name != "message" || string(e.event.Data) != "hello" || e.event.Subject != "orders.new" {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	})
+
+	t.Run("Resume From Last Event ID", func(t *testing.T) {
+		l, err := msglog.Open(t.TempDir())
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer l.Close()
+		sp := subpub.NewSubPub(100, subpub.WithLog(l))
+		for _, data := range []string{"a", "b", "c"} {
+			sp.Publish("orders", &subpub.Message{Data: []byte(data)})
+		}
+		srv := httptest.NewServer(New(services.NewServer(sp)))
+		defer srv.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		resp, events := subscribe(t, ctx, srv.URL+"/v1/subscribe/orders", http.Header{"Last-Event-ID": {"1"}})
+		defer resp.Body.Close()
+		for i, want := range []string{"b", "c"} {
+			e := next(t, events)
+			if string(e.event.Data) != want || e.id != e.event.Sequence || e.id != uint64(i+2) {
+				t.Errorf("Expected %s with id %d, got %+v", want, i+2, e)
+			}
+		}
+	})
+
+	t.Run("Invalid Start", func(t *testing.T) {
+		srv := httptest.NewServer(New(services.NewServer(subpub.NewSubPub(100))))
+		defer srv.Close()
+		for _, url := range []string{"/v1/subscribe/orders?start=yesterday", "/v1/subscribe/orders?start=earliest"} {
+			resp, err := http.Get(srv.URL + url)
+			if err != nil {
+				t.Fatalf("GET failed: %v", err)
+			}
+			resp.Body.Close()
+			// Replay without a message log is a failed precondition.
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", url, resp.StatusCode)
+			}
+		}
+	})
+
+	t.Run("Closed SubPub", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		srv := httptest.NewServer(New(services.NewServer(sp)))
+		defer srv.Close()
+		resp, events := subscribe(t, context.Background(), srv.URL+"/v1/subscribe/orders", nil)
+		defer resp.Body.Close()
+		sp.Close(context.Background())
+		if _, ok := <-events; ok {
+			t.Error("Expected the stream to end")
+		}
+	})
+}
+
+func TestAuthentication(t *testing.T) {
+	acl, err := auth.NewACL([]auth.Rule{{Principal: "ingest", Publish: []string{"orders"}}})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	sp := subpub.NewSubPub(100)
+	g := New(services.NewServer(sp, services.WithACL(acl)),
+		WithAuthenticator(auth.NewStaticTokens(map[string]string{"secret": "ingest"})))
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{"Missing Token", http.MethodPost, "/v1/publish/orders", "", http.StatusUnauthorized},
+		{"Invalid Token", http.MethodPost, "/v1/publish/orders", "nope", http.StatusUnauthorized},
+		{"Allowed", http.MethodPost, "/v1/publish/orders", "secret", http.StatusOK},
+		{"Denied Publish", http.MethodPost, "/v1/publish/alerts", "secret", http.StatusForbidden},
+		{"Denied Subscribe", http.MethodGet, "/v1/subscribe/orders", "secret", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest(c.method, srv.URL+c.path, strings.NewReader("x"))
+			if c.token != "" {
+				req.Header.Set("Authorization", "Bearer "+c.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != c.code {
+				t.Errorf("Expected %d, got %d", c.code, resp.StatusCode)
+			}
+		})
+	}
+}
+
+type sseEvent struct {
+	id    uint64
+	name  string
+	event *pb.Event
+}
+
+// subscribe opens an event stream and parses its events until the body ends.
+func subscribe(t *testing.T, ctx context.Context, url string, header http.Header) (*http.Response, <-chan sseEvent) {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	events := make(chan sseEvent, 10)
+	go func() {
+		defer close(events)
+		var e sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				json.Unmarshal([]byte(line[4:]), &e.id)
+			case strings.HasPrefix(line, "event: "):
+				e.name = line[7:]
+			case strings.HasPrefix(line, "data: "):
+				e.event = &pb.Event{}
+				protojson.Unmarshal([]byte(line[6:]), e.event)
+			case line == "" && e.name != "":
+				events <- e
+				e = sseEvent{}
+			}
+		}
+	}()
+	return resp, events
+}
+
+func next(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("Stream ended")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("No event received")
+	}
+	return sseEvent{}
+}
@@ -0,0 +1,139 @@
+package gateway
+
+import (
+	"asyn-subpub-service/pb/proto/api"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errStreamClosed = errors.New("event stream closed")
+
+// eventStream writes Server-Sent Events. The response headers are only sent once
+// the subscription is registered, so that subscribe errors can still be reported
+// with an HTTP status.
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	started bool
+	closed  bool
+}
+
+func (s *eventStream) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.startLocked()
+}
+
+func (s *eventStream) startLocked() {
+	if s.started {
+		return
+	}
+	s.started = true
+	h := s.w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	s.w.WriteHeader(http.StatusOK)
+	s.flusher.Flush()
+}
+
+func (s *eventStream) send(event *pb.Event) error {
+	data, err := protoJSON.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStreamClosed
+	}
+	s.startLocked()
+	if event.Sequence > 0 {
+		fmt.Fprintf(s.w, "id: %d\n", event.Sequence)
+	}
+	return s.writeLocked("message", data)
+}
+
+// sendError reports an error that ended a started stream.
+func (s *eventStream) sendError(err error) {
+	st := status.Convert(err)
+	data, _ := json.Marshal(newErrorBody(st.Code(), st.Message()))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeLocked("error", data)
+}
+
+func (s *eventStream) writeLocked(event string, data []byte) error {
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		// The client went away: end the subscription.
+		s.cancel()
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// close stops further events and keepalives and reports whether the headers were sent.
+func (s *eventStream) close() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.started
+}
+
+// keepAlive writes a comment line every interval until ctx is done.
+func (s *eventStream) keepAlive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		if s.started && !s.closed {
+			if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+				s.cancel()
+			} else {
+				s.flusher.Flush()
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// parseStart parses the start query parameter: earliest, latest, a sequence
+// number or an RFC 3339 time. An empty value starts with new events.
+func parseStart(v string) (*pb.StartFrom, error) {
+	switch v {
+	case "", "latest":
+		return nil, nil
+	case "earliest":
+		return &pb.StartFrom{Position: &pb.StartFrom_Earliest{Earliest: &emptypb.Empty{}}}, nil
+	}
+	if seq, err := parseSequence(v); err == nil {
+		return &pb.StartFrom{Position: &pb.StartFrom_Sequence{Sequence: seq}}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return &pb.StartFrom{Position: &pb.StartFrom_Time{Time: timestamppb.New(t)}}, nil
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "invalid start %q", v)
+}
+
+func parseSequence(v string) (uint64, error) {
+	return strconv.ParseUint(v, 10, 64)
+}
@@ -0,0 +1,285 @@
+package gateway
+
+import (
+	"asyn-subpub-service/pb/proto/api"
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// writeWait bounds a single WebSocket write. Events are written by the delivery
+// goroutine of their subscription, so like a gRPC stream a slow connection fills
+// the subscription buffer and the overflow policy applies; a connection that does
+// not accept writes for writeWait is closed.
+const writeWait = 10 * time.Second
+
+// Frame types of the WebSocket protocol.
+const (
+	frameSubscribe    = "subscribe"
+	frameUnsubscribe  = "unsubscribe"
+	framePublish      = "publish"
+	frameSubscribed   = "subscribed"
+	frameUnsubscribed = "unsubscribed"
+	frameEvent        = "event"
+	framePublished    = "published"
+	frameError        = "error"
+)
+
+// clientFrame is a command sent by the client. ID names the subscription of
+// subscribe and unsubscribe frames and correlates the reply to a publish frame.
+// TTL is a duration such as "30s".
+type clientFrame struct {
+	Type        string            `json:"type"`
+	ID          string            `json:"id"`
+	Key         string            `json:"key,omitempty"`
+	QueueGroup  string            `json:"queue_group,omitempty"`
+	Start       string            `json:"start,omitempty"`
+	Data        []byte            `json:"data,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Retain      bool              `json:"retain,omitempty"`
+	TTL         string            `json:"ttl,omitempty"`
+}
+
+// serverFrame is an event or a reply sent to the client.
+type serverFrame struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id,omitempty"`
+	Event  json.RawMessage `json:"event,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *errorBody      `json:"error,omitempty"`
+}
+
+// websocket multiplexes subscriptions and publishes over one connection.
+func (g *Gateway) websocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: g.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has replied with an HTTP error.
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	c := &wsConn{
+		gateway: g,
+		conn:    conn,
+		ctx:     ctx,
+		cancel:  cancel,
+		subs:    make(map[string]context.CancelFunc),
+	}
+	c.serve()
+}
+
+// checkOrigin accepts same-origin requests, requests without an Origin header
+// and the origins allowed by WithAllowedOrigins.
+func (g *Gateway) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range g.allowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return origin == "http://"+r.Host || origin == "https://"+r.Host
+}
+
+type wsConn struct {
+	gateway *Gateway
+	conn    *websocket.Conn
+	ctx     context.Context
+	cancel  context.CancelFunc
+	writeMu sync.Mutex
+	wg      sync.WaitGroup
+
+	mu   sync.Mutex
+	subs map[string]context.CancelFunc
+}
+
+// serve reads commands until the connection fails, then ends every subscription.
+func (c *wsConn) serve() {
+	defer c.conn.Close()
+	defer c.wg.Wait()
+	defer c.cancel()
+
+	// A client that neither sends nor answers pings for two intervals is gone.
+	interval := c.gateway.keepAlive
+	c.conn.SetReadLimit(2 * MaxPublishSize)
+	c.conn.SetReadDeadline(time.Now().Add(2 * interval))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(2 * interval))
+	})
+	c.wg.Add(1)
+	go c.ping(interval)
+
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(2 * interval))
+		var f clientFrame
+		if err := json.Unmarshal(msg, &f); err != nil {
+			c.writeError("", status.Errorf(codes.InvalidArgument, "malformed frame: %v", err))
+			continue
+		}
+		switch f.Type {
+		case frameSubscribe:
+			c.subscribe(f)
+		case frameUnsubscribe:
+			c.unsubscribe(f.ID)
+		case framePublish:
+			c.publish(f)
+		default:
+			c.writeError(f.ID, status.Errorf(codes.InvalidArgument, "unknown frame type %q", f.Type))
+		}
+	}
+}
+
+func (c *wsConn) ping(interval time.Duration) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.writeMu.Lock()
+		err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+		c.writeMu.Unlock()
+		if err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+func (c *wsConn) subscribe(f clientFrame) {
+	if f.ID == "" {
+		c.writeError("", status.Error(codes.InvalidArgument, "subscribe frame requires an id"))
+		return
+	}
+	start, err := parseStart(f.Start)
+	if err != nil {
+		c.writeError(f.ID, err)
+		return
+	}
+	c.mu.Lock()
+	if _, ok := c.subs[f.ID]; ok {
+		c.mu.Unlock()
+		c.writeError(f.ID, status.Errorf(codes.AlreadyExists, "subscription %q already exists", f.ID))
+		return
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.subs[f.ID] = cancel
+	c.mu.Unlock()
+
+	req := &pb.SubscribeRequest{Key: f.Key, QueueGroup: f.QueueGroup, StartFrom: start}
+	ready := func() {
+		c.write(serverFrame{Type: frameSubscribed, ID: f.ID})
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		err := c.gateway.server.StreamEvents(ctx, req, ready, func(event *pb.Event) error {
+			data, err := protoJSON.Marshal(event)
+			if err != nil {
+				return err
+			}
+			return c.write(serverFrame{Type: frameEvent, ID: f.ID, Event: data})
+		})
+		c.mu.Lock()
+		delete(c.subs, f.ID)
+		c.mu.Unlock()
+		cancel()
+		if c.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.writeError(f.ID, err)
+			return
+		}
+		c.write(serverFrame{Type: frameUnsubscribed, ID: f.ID})
+	}()
+}
+
+// unsubscribe ends the subscription; the unsubscribed frame follows once no more
+// events will be sent for it.
+func (c *wsConn) unsubscribe(id string) {
+	c.mu.Lock()
+	cancel, ok := c.subs[id]
+	c.mu.Unlock()
+	if !ok {
+		c.writeError(id, status.Errorf(codes.NotFound, "subscription %q not found", id))
+		return
+	}
+	cancel()
+}
+
+func (c *wsConn) publish(f clientFrame) {
+	ttl, err := parseTTL(f.TTL)
+	if err != nil {
+		c.writeError(f.ID, err)
+		return
+	}
+	resp, err := c.gateway.server.Publish(c.ctx, &pb.PublishRequest{
+		Key:         f.Key,
+		Data:        f.Data,
+		ContentType: f.ContentType,
+		Headers:     f.Headers,
+		Retain:      f.Retain,
+		Ttl:         ttl,
+	})
+	if err != nil {
+		c.writeError(f.ID, err)
+		return
+	}
+	c.writeProto(framePublished, f.ID, resp)
+}
+
+func (c *wsConn) writeProto(typ, id string, m proto.Message) {
+	data, err := protoJSON.Marshal(m)
+	if err != nil {
+		c.writeError(id, status.Errorf(codes.Internal, "failed to encode response: %v", err))
+		return
+	}
+	c.write(serverFrame{Type: typ, ID: id, Result: data})
+}
+
+func (c *wsConn) writeError(id string, err error) {
+	st := status.Convert(err)
+	body := newErrorBody(st.Code(), st.Message())
+	c.write(serverFrame{Type: frameError, ID: id, Error: &body})
+}
+
+// write sends a frame; a failed write closes the connection.
+func (c *wsConn) write(f serverFrame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	err := c.conn.WriteJSON(f)
+	if err != nil {
+		if c.ctx.Err() == nil {
+			log.Printf("Error writing WebSocket frame: %v", err)
+		}
+		c.close()
+	}
+	return err
+}
+
+// close ends every subscription and unblocks the read loop.
+func (c *wsConn) close() {
+	c.cancel()
+	// Closing the socket is safe while serve is inside ReadMessage, unlike
+	// moving its read deadline.
+	c.conn.NetConn().Close()
+}
@@ -0,0 +1,186 @@
+package gateway
+
+import (
+	"asyn-subpub-service/internal/auth"
+	"asyn-subpub-service/internal/services"
+	"asyn-subpub-service/internal/subpub"
+	"asyn-subpub-service/pb/proto/api"
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebSocket(t *testing.T) {
+	sp := subpub.NewSubPub(100)
+	srv := httptest.NewServer(New(services.NewServer(sp)))
+	defer srv.Close()
+
+	t.Run("Multiplexed Subscriptions", func(t *testing.T) {
+		conn := dial(t, srv.URL+"/v1/ws", nil)
+		defer conn.Close()
+		send(t, conn, clientFrame{Type: frameSubscribe, ID: "s1", Key: "orders.*"})
+		expectFrame(t, conn, frameSubscribed, "s1")
+		send(t, conn, clientFrame{Type: frameSubscribe, ID: "s2", Key: "orders.new"})
+		expectFrame(t, conn, frameSubscribed, "s2")
+
+		send(t, conn, clientFrame{Type: framePublish, ID: "p1", Key: "orders.new", Data: []byte("hello")})
+		seen := map[string]bool{}
+		for i := 0; i < 3; i++ {
+			f := readFrame(t, conn)
+			seen[f.Type+":"+f.ID] = true
+			if f.Type == frameEvent {
+				var event pb.Event
+				if err := protojson.Unmarshal(f.Event, &event); err != nil || string(event.Data) != "hello" {
+					t.Errorf("Unexpected event %s (err %v)", f.Event, err)
+				}
+			}
+			if f.Type == framePublished {
+				var resp pb.PublishResponse
+				if err := protojson.Unmarshal(f.Result, &resp); err != nil || resp.Matched != 2 {
+					t.Errorf("Unexpected publish result %s (err %v)", f.Result, err)
+				}
+			}
+		}
+		for _, want := range []string{"event:s1", "event:s2", "published:p1"} {
+			if !seen[want] {
+				t.Errorf("Expected %s frame, got %v", want, seen)
+			}
+		}
+
+		send(t, conn, clientFrame{Type: frameUnsubscribe, ID: "s1"})
+		expectFrame(t, conn, frameUnsubscribed, "s1")
+		sp.Publish("orders.new", &subpub.Message{Data: []byte("again")})
+		expectFrame(t, conn, frameEvent, "s2")
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		conn := dial(t, srv.URL+"/v1/ws", nil)
+		defer conn.Close()
+		send(t, conn, clientFrame{Type: frameSubscribe, ID: "s1", Key: "orders"})
+		expectFrame(t, conn, frameSubscribed, "s1")
+
+		cases := []struct {
+			name  string
+			frame string
+			id    string
+			code  string
+		}{
+			{"Duplicate ID", `{"type":"subscribe","id":"s1","key":"orders"}`, "s1", "ALREADY_EXISTS"},
+			{"Missing ID", `{"type":"subscribe","key":"orders"}`, "", "INVALID_ARGUMENT"},
+			{"Invalid Key", `{"type":"subscribe","id":"s2","key":"a..b"}`, "s2", "INVALID_ARGUMENT"},
+			{"Unknown Subscription", `{"type":"unsubscribe","id":"s9"}`, "s9", "NOT_FOUND"},
+			{"Unknown Type", `{"type":"nope","id":"x"}`, "x", "INVALID_ARGUMENT"},
+			{"Malformed", `{"type":`, "", "INVALID_ARGUMENT"},
+			{"Publish Wildcard", `{"type":"publish","id":"p1","key":"orders.*"}`, "p1", "INVALID_ARGUMENT"},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				if err := conn.WriteMessage(websocket.TextMessage, []byte(c.frame)); err != nil {
+					t.Fatalf("WriteMessage failed: %v", err)
+				}
+				f := expectFrame(t, conn, frameError, c.id)
+				if f.Error == nil || f.Error.Code != c.code {
+					t.Errorf("Expected %s, got %+v", c.code, f.Error)
+				}
+			})
+		}
+
+		// The connection still works after errors.
+		sp.Publish("orders", &subpub.Message{Data: []byte("ok")})
+		expectFrame(t, conn, frameEvent, "s1")
+	})
+
+	t.Run("Origin", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL(srv.URL+"/v1/ws"), http.Header{"Origin": {"https://evil.example"}})
+		if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected cross-origin request to be rejected, got %v", err)
+		}
+
+		allowed := httptest.NewServer(New(services.NewServer(sp), WithAllowedOrigins("https://app.example")))
+		defer allowed.Close()
+		conn := dial(t, allowed.URL+"/v1/ws", http.Header{"Origin": {"https://app.example"}})
+		conn.Close()
+	})
+}
+
+func TestWebSocketKeepAlive(t *testing.T) {
+	srv := httptest.NewServer(New(services.NewServer(subpub.NewSubPub(100)), WithKeepAlive(20*time.Millisecond)))
+	defer srv.Close()
+	conn := dial(t, srv.URL+"/v1/ws", nil)
+	defer conn.Close()
+
+	var pings atomic.Int32
+	conn.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	// Reading processes control frames; the connection stays open as pongs are sent.
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err := conn.ReadMessage()
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("Expected read timeout, got %v", err)
+	}
+	if pings.Load() < 3 {
+		t.Errorf("Expected pings, got %d", pings.Load())
+	}
+}
+
+func TestWebSocketAuthentication(t *testing.T) {
+	sp := subpub.NewSubPub(100)
+	srv := httptest.NewServer(New(services.NewServer(sp),
+		WithAuthenticator(auth.NewStaticTokens(map[string]string{"secret": "ingest"}))))
+	defer srv.Close()
+
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL(srv.URL+"/v1/ws"), nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %v", err)
+	}
+	conn := dial(t, srv.URL+"/v1/ws?access_token=secret", nil)
+	defer conn.Close()
+	send(t, conn, clientFrame{Type: framePublish, ID: "p1", Key: "orders"})
+	expectFrame(t, conn, framePublished, "p1")
+}
+
+func wsURL(url string) string {
+	return "ws" + strings.TrimPrefix(url, "http")
+}
+
+func dial(t *testing.T, url string, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(url), header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, f clientFrame) {
+	t.Helper()
+	if err := conn.WriteJSON(f); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) serverFrame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var f serverFrame
+	if err := conn.ReadJSON(&f); err != nil {
+		t.Fatalf("ReadJSON failed: %v", err)
+	}
+	return f
+}
+
+func expectFrame(t *testing.T, conn *websocket.Conn, typ, id string) serverFrame {
+	t.Helper()
+	f := readFrame(t, conn)
+	if f.Type != typ || f.ID != id {
+		t.Fatalf("Expected %s frame for %q, got %+v", typ, id, f)
+	}
+	return f
+}
@@ -0,0 +1,240 @@
+// Package metrics exports broker and gRPC server metrics in the Prometheus format.
+package metrics
+
+import (
+	"asyn-subpub-service/internal/subpub"
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"time"
+)
+
+// Metrics collects the metrics of the service in its own registry. It implements
+// subpub.Observer; published messages are labelled with their literal subject,
+// everything measured on a subscription with the subject pattern it was made on.
+// Request inboxes share the label inboxLabel, since each is used only once.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	published       *prometheus.CounterVec
+	delivered       *prometheus.CounterVec
+	dropped         *prometheus.CounterVec
+	evicted         *prometheus.CounterVec
+	expired         *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+
+	grpcStarted  *prometheus.CounterVec
+	grpcHandled  *prometheus.CounterVec
+	grpcDuration *prometheus.HistogramVec
+	grpcReceived *prometheus.CounterVec
+	grpcSent     *prometheus.CounterVec
+}
+
+// inboxLabel stands in for every subject made by subpub.Request.
+const inboxLabel = "_INBOX"
+
+// subjectLabel returns the value of the subject label for subject.
+func subjectLabel(subject string) string {
+	if subpub.IsInbox(subject) {
+		return inboxLabel
+	}
+	return subject
+}
+
+// New creates the metrics, including the Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "subpub_published_messages_total",
+			Help: "Messages published, by subject and outcome.",
+		}, []string{"subject", "status"}),
+		delivered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "subpub_delivered_messages_total",
+			Help: "Messages passed to subscription handlers, by subscribed subject.",
+		}, []string{"subject"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "subpub_dropped_messages_total",
+			Help: "Messages discarded because the subscription buffer was full, by subscribed subject.",
+		}, []string{"subject"}),
+		evicted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "subpub_evicted_messages_total",
+			Help: "Buffered messages discarded by the drop_oldest policy, by subscribed subject.",
+		}, []string{"subject"}),
+		expired: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "subpub_expired_messages_total",
+			Help: "Messages discarded because their TTL passed before delivery, by subscribed subject.",
+		}, []string{"subject"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "subpub_handler_duration_seconds",
+			Help:    "Time subscription handlers take to process a message, by subscribed subject.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 9),
+		}, []string{"subject"}),
+		grpcStarted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_started_total",
+			Help: "RPCs started on the server, by method.",
+		}, []string{"method"}),
+		grpcHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "RPCs completed on the server, by method and status code.",
+		}, []string{"method", "code"}),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Time from the start to the completion of an RPC, by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		grpcReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_msg_received_total",
+			Help: "Stream messages received by the server, by method.",
+		}, []string{"method"}),
+		grpcSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_msg_sent_total",
+			Help: "Stream messages sent by the server, by method.",
+		}, []string{"method"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.published, m.delivered, m.dropped, m.evicted, m.expired, m.handlerDuration,
+		m.grpcStarted, m.grpcHandled, m.grpcDuration, m.grpcReceived, m.grpcSent,
+	)
+	return m
+}
+
+// Handler serves the collected metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Watch exports the subscription and buffer gauges of sp, read at scrape time.
+func (m *Metrics) Watch(sp subpub.SubPub) {
+	m.registry.MustRegister(newSubPubCollector(sp))
+}
+
+func (m *Metrics) Published(subject string, r subpub.PublishResult) {
+	m.published.WithLabelValues(subjectLabel(subject), r.Status().String()).Inc()
+}
+
+func (m *Metrics) Delivered(subject string, d time.Duration) {
+	m.delivered.WithLabelValues(subjectLabel(subject)).Inc()
+	m.handlerDuration.WithLabelValues(subjectLabel(subject)).Observe(d.Seconds())
+}
+
+func (m *Metrics) Dropped(subject string) {
+	m.dropped.WithLabelValues(subjectLabel(subject)).Inc()
+}
+
+func (m *Metrics) Evicted(subject string) {
+	m.evicted.WithLabelValues(subjectLabel(subject)).Inc()
+}
+
+func (m *Metrics) Expired(subject string) {
+	m.expired.WithLabelValues(subjectLabel(subject)).Inc()
+}
+
+// UnaryServerInterceptor records the count, status and latency of unary RPCs.
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		done := m.start(info.FullMethod)
+		resp, err := handler(ctx, req)
+		done(err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor records the count, status, duration and message counts of streaming RPCs.
+func (m *Metrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done := m.start(info.FullMethod)
+		err := handler(srv, &monitoredStream{ServerStream: ss, method: info.FullMethod, metrics: m})
+		done(err)
+		return err
+	}
+}
+
+func (m *Metrics) start(method string) func(error) {
+	m.grpcStarted.WithLabelValues(method).Inc()
+	start := time.Now()
+	return func(err error) {
+		m.grpcHandled.WithLabelValues(method, status.Code(err).String()).Inc()
+		m.grpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	}
+}
+
+type monitoredStream struct {
+	grpc.ServerStream
+	method  string
+	metrics *Metrics
+}
+
+func (s *monitoredStream) SendMsg(msg interface{}) error {
+	err := s.ServerStream.SendMsg(msg)
+	if err == nil {
+		s.metrics.grpcSent.WithLabelValues(s.method).Inc()
+	}
+	return err
+}
+
+func (s *monitoredStream) RecvMsg(msg interface{}) error {
+	err := s.ServerStream.RecvMsg(msg)
+	if err == nil {
+		s.metrics.grpcReceived.WithLabelValues(s.method).Inc()
+	}
+	return err
+}
+
+// subPubCollector reports the live subscriptions of a SubPub, aggregated by subscribed subject.
+type subPubCollector struct {
+	sp            subpub.SubPub
+	subjects      *prometheus.Desc
+	subscriptions *prometheus.Desc
+	pending       *prometheus.Desc
+	capacity      *prometheus.Desc
+}
+
+func newSubPubCollector(sp subpub.SubPub) *subPubCollector {
+	return &subPubCollector{
+		sp: sp,
+		subjects: prometheus.NewDesc("subpub_subjects",
+			"Distinct subjects with at least one subscription.", nil, nil),
+		subscriptions: prometheus.NewDesc("subpub_subscriptions",
+			"Live subscriptions, by subscribed subject.", []string{"subject"}, nil),
+		pending: prometheus.NewDesc("subpub_queue_depth",
+			"Messages buffered and waiting for the handler, by subscribed subject.", []string{"subject"}, nil),
+		capacity: prometheus.NewDesc("subpub_queue_capacity",
+			"Total buffer size of the subscriptions, by subscribed subject.", []string{"subject"}, nil),
+	}
+}
+
+func (c *subPubCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.subjects
+	ch <- c.subscriptions
+	ch <- c.pending
+	ch <- c.capacity
+}
+
+func (c *subPubCollector) Collect(ch chan<- prometheus.Metric) {
+	type totals struct{ subscriptions, pending, capacity int }
+	bySubject := make(map[string]*totals)
+	for _, info := range c.sp.Subscriptions() {
+		subject := subjectLabel(info.Subject)
+		t, ok := bySubject[subject]
+		if !ok {
+			t = &totals{}
+			bySubject[subject] = t
+		}
+		t.subscriptions++
+		t.pending += info.Pending
+		t.capacity += info.Capacity
+	}
+	ch <- prometheus.MustNewConstMetric(c.subjects, prometheus.GaugeValue, float64(len(bySubject)))
+	for subject, t := range bySubject {
+		ch <- prometheus.MustNewConstMetric(c.subscriptions, prometheus.GaugeValue, float64(t.subscriptions), subject)
+		ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(t.pending), subject)
+		ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(t.capacity), subject)
+	}
+}
@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"asyn-subpub-service/internal/subpub"
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("Broker Counters And Gauges", func(t *testing.T) {
+		m := New()
+		sp := subpub.NewSubPub(1, subpub.WithObserver(m))
+		m.Watch(sp)
+
+		release := make(chan struct{})
+		delivered := make(chan struct{}, 10)
+		if _, err := sp.Subscribe("orders.*", func(msg interface{}) {
+			<-release
+			delivered <- struct{}{}
+		}); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		sp.Publish("orders.eu", "first")
+		time.Sleep(10 * time.Millisecond)
+		sp.Publish("orders.eu", "second")
+		sp.Publish("orders.eu", "third")
+		sp.Publish("users", "nobody")
+
+		if v := testutil.ToFloat64(m.published.WithLabelValues("orders.eu", "accepted")); v != 2 {
+			t.Errorf("Expected 2 accepted publishes, got %v", v)
+		}
+		if v := testutil.ToFloat64(m.published.WithLabelValues("orders.eu", "dropped")); v != 1 {
+			t.Errorf("Expected 1 dropped publish, got %v", v)
+		}
+		if v := testutil.ToFloat64(m.published.WithLabelValues("users", "no_subscribers")); v != 1 {
+			t.Errorf("Expected 1 publish without subscribers, got %v", v)
+		}
+		if v := testutil.ToFloat64(m.dropped.WithLabelValues("orders.*")); v != 1 {
+			t.Errorf("Expected 1 drop on orders.*, got %v", v)
+		}
+
+		want := `
+# HELP subpub_queue_depth Messages buffered and waiting for the handler, by subscribed subject.
+# TYPE subpub_queue_depth gauge
+subpub_queue_depth{subject="orders.*"} 1
+# HELP subpub_subscriptions Live subscriptions, by subscribed subject.
+# TYPE subpub_subscriptions gauge
+subpub_subscriptions{subject="orders.*"} 1
+`
+		if err := testutil.GatherAndCompare(m.registry, strings.NewReader(want), "subpub_queue_depth", "subpub_subscriptions"); err != nil {
+			t.Error(err)
+		}
+
+		close(release)
+		for i := 0; i < 2; i++ {
+			<-delivered
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		sp.Close(ctx)
+		if v := testutil.ToFloat64(m.delivered.WithLabelValues("orders.*")); v != 2 {
+			t.Errorf("Expected 2 deliveries, got %v", v)
+		}
+		if n := testutil.CollectAndCount(m.handlerDuration); n != 1 {
+			t.Errorf("Expected one handler histogram, got %d", n)
+		}
+	})
+
+	t.Run("gRPC Interceptors", func(t *testing.T) {
+		m := New()
+		unary := m.UnaryServerInterceptor()
+		info := &grpc.UnaryServerInfo{FullMethod: "/PubSub/Publish"}
+		unary(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		unary(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.InvalidArgument, "bad key")
+		})
+		if v := testutil.ToFloat64(m.grpcStarted.WithLabelValues("/PubSub/Publish")); v != 2 {
+			t.Errorf("Expected 2 started RPCs, got %v", v)
+		}
+		if v := testutil.ToFloat64(m.grpcHandled.WithLabelValues("/PubSub/Publish", "InvalidArgument")); v != 1 {
+			t.Errorf("Expected 1 InvalidArgument, got %v", v)
+		}
+
+		stream := m.StreamServerInterceptor()
+		err := stream(nil, &fakeStream{}, &grpc.StreamServerInfo{FullMethod: "/PubSub/Subscribe"},
+			func(srv interface{}, ss grpc.ServerStream) error {
+				ss.RecvMsg(nil)
+				for i := 0; i < 3; i++ {
+					ss.SendMsg(nil)
+				}
+				return nil
+			})
+		if err != nil {
+			t.Fatalf("Stream handler failed: %v", err)
+		}
+		if v := testutil.ToFloat64(m.grpcSent.WithLabelValues("/PubSub/Subscribe")); v != 3 {
+			t.Errorf("Expected 3 sent messages, got %v", v)
+		}
+		if v := testutil.ToFloat64(m.grpcReceived.WithLabelValues("/PubSub/Subscribe")); v != 1 {
+			t.Errorf("Expected 1 received message, got %v", v)
+		}
+		if v := testutil.ToFloat64(m.grpcHandled.WithLabelValues("/PubSub/Subscribe", "OK")); v != 1 {
+			t.Errorf("Expected 1 OK stream, got %v", v)
+		}
+	})
+
+	t.Run("Request Inboxes Share A Label", func(t *testing.T) {
+		m := New()
+		sp := subpub.NewSubPub(10, subpub.WithObserver(m))
+		m.Watch(sp)
+		defer sp.Close(context.Background())
+		if _, err := sp.Subscribe("echo", func(msg interface{}) {
+			sp.Publish(msg.(*subpub.Message).ReplyTo(), msg)
+		}); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		for i := 0; i < 20; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, err := sp.Request(ctx, "echo", "ping")
+			cancel()
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+		}
+		if v := testutil.ToFloat64(m.published.WithLabelValues(inboxLabel, "accepted")); v != 20 {
+			t.Errorf("Expected 20 replies under %s, got %v", inboxLabel, v)
+		}
+		if n := testutil.CollectAndCount(m.published); n != 2 {
+			t.Errorf("Expected published series for echo and %s only, got %d", inboxLabel, n)
+		}
+		if n := testutil.CollectAndCount(m.delivered); n != 2 {
+			t.Errorf("Expected delivered series for echo and %s only, got %d", inboxLabel, n)
+		}
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		m := New()
+		m.Published("orders", subpub.PublishResult{Matched: 1, Enqueued: 1})
+		rec := httptest.NewRecorder()
+		m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		body, _ := io.ReadAll(rec.Body)
+		if !strings.Contains(string(body), `subpub_published_messages_total{status="accepted",subject="orders"} 1`) {
+			t.Errorf("Expected published counter in output, got:\n%s", body)
+		}
+	})
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+}
+
+func (fakeStream) SendMsg(interface{}) error { return nil }
+func (fakeStream) RecvMsg(interface{}) error { return nil }
@@ -0,0 +1,512 @@
+// Package msglog implements an append-only, segmented on-disk message log.
+// Every subject has its own directory of segment files and its own sequence,
+// starting at 1 and increasing by one with every appended record.
+package msglog
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSegmentSize is the size after which a new segment file is started.
+	DefaultSegmentSize = 64 << 20
+	// DefaultMaxOpenSegments is how many subjects keep their active segment open
+	// for appending by default.
+	DefaultMaxOpenSegments = 256
+	// MaxRecordSize is the largest payload a record can hold.
+	MaxRecordSize int64 = math.MaxUint32 - metaSize
+
+	segmentExt = ".seg"
+	// headerSize covers the length and checksum prefix of a record.
+	headerSize = 8
+	// metaSize covers the sequence number and timestamp stored before the payload.
+	metaSize = 16
+)
+
+// ErrClosed is returned when the log is used after Close.
+var ErrClosed = errors.New("msglog: log is closed")
+
+// Record is a single message stored in the log.
+type Record struct {
+	Seq  uint64
+	Time time.Time
+	Data []byte
+}
+
+// Option configures a Log opened by Open.
+type Option func(*Log)
+
+// WithSegmentSize sets the size after which a new segment file is started.
+func WithSegmentSize(n int64) Option {
+	return func(l *Log) {
+		if n > 0 {
+			l.segmentSize = n
+		}
+	}
+}
+
+// WithMaxOpenSegments caps the number of segment files kept open for appending.
+// The least recently appended subjects have their segment closed, and reopened
+// on their next append, so the log needs no file per subject it ever stored.
+func WithMaxOpenSegments(n int) Option {
+	return func(l *Log) {
+		if n > 0 {
+			l.maxOpen = n
+		}
+	}
+}
+
+// WithSync makes every append wait for the data to reach stable storage.
+func WithSync(sync bool) Option {
+	return func(l *Log) {
+		l.sync = sync
+	}
+}
+
+// Log is a collection of per-subject append-only logs rooted in one directory.
+type Log struct {
+	dir         string
+	segmentSize int64
+	maxOpen     int
+	sync        bool
+
+	mu       sync.Mutex
+	closed   bool
+	subjects map[string]*subjectLog
+	// open lists the subjects with an open active segment, most recently appended
+	// first. It may also hold subjects whose segment was closed meanwhile.
+	open *list.List
+}
+
+type subjectLog struct {
+	mu       sync.Mutex
+	dir      string
+	segments []uint64 // first sequence number of every segment, ascending
+	active   *os.File
+	size     int64 // size of the last segment
+	lastSeq  uint64
+	elem     *list.Element // in Log.open, guarded by Log.mu
+}
+
+// Open opens the log in dir, creating the directory if needed and recovering
+// every subject found in it. A torn record at the tail of a segment is truncated.
+func Open(dir string, opts ...Option) (*Log, error) {
+	l := &Log{
+		dir:         dir,
+		segmentSize: DefaultSegmentSize,
+		maxOpen:     DefaultMaxOpenSegments,
+		subjects:    make(map[string]*subjectLog),
+		open:        list.New(),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		subject, err := url.PathUnescape(e.Name())
+		if err != nil {
+			continue
+		}
+		sl, err := openSubject(filepath.Join(dir, e.Name()))
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("msglog: recover %q: %w", subject, err)
+		}
+		l.subjects[subject] = sl
+	}
+	return l, nil
+}
+
+// Append stores data for the subject and returns the sequence number assigned to it.
+func (l *Log) Append(subject string, t time.Time, data []byte) (uint64, error) {
+	if int64(len(data)) > MaxRecordSize {
+		return 0, fmt.Errorf("msglog: record of %d bytes exceeds %d", len(data), MaxRecordSize)
+	}
+	sl, err := l.subject(subject, true)
+	if err != nil {
+		return 0, err
+	}
+	seq, evicted, err := l.append(sl, t, data)
+	// Segments are closed without holding sl.mu, which the evicted subjects'
+	// own appends take before Log.mu.
+	for _, e := range evicted {
+		e.closeActive()
+	}
+	return seq, err
+}
+
+func (l *Log) append(sl *subjectLog, t time.Time, data []byte) (uint64, []*subjectLog, error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	// The last segment is reopened even when it is full, so that a record left
+	// behind by a failed write is cut off before the next segment starts.
+	if sl.active == nil && len(sl.segments) > 0 {
+		if err := sl.reopen(); err != nil {
+			return 0, nil, err
+		}
+	}
+	if len(sl.segments) == 0 || sl.size >= l.segmentSize {
+		if err := sl.roll(); err != nil {
+			return 0, nil, err
+		}
+	}
+	evicted, err := l.touch(sl)
+	if err != nil {
+		// Close ran meanwhile and may have missed the segment just opened.
+		sl.active.Close()
+		sl.active = nil
+		return 0, nil, err
+	}
+	seq, err := sl.write(t, data, l.sync)
+	return seq, evicted, err
+}
+
+// touch marks the subject as the most recently appended and returns the subjects
+// whose segment has to be closed to stay within the cap.
+func (l *Log) touch(sl *subjectLog) ([]*subjectLog, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	if sl.elem == nil {
+		sl.elem = l.open.PushFront(sl)
+	} else {
+		l.open.MoveToFront(sl.elem)
+	}
+	var evicted []*subjectLog
+	for l.open.Len() > l.maxOpen {
+		e := l.open.Remove(l.open.Back()).(*subjectLog)
+		e.elem = nil
+		evicted = append(evicted, e)
+	}
+	return evicted, nil
+}
+
+// write appends a record to the active segment. Callers must hold sl.mu.
+func (sl *subjectLog) write(t time.Time, data []byte, sync bool) (uint64, error) {
+	seq := sl.lastSeq + 1
+	buf := make([]byte, headerSize+metaSize+len(data))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(metaSize+len(data)))
+	binary.BigEndian.PutUint64(buf[8:16], seq)
+	binary.BigEndian.PutUint64(buf[16:24], uint64(t.UnixNano()))
+	copy(buf[24:], data)
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(buf[8:]))
+
+	if _, err := sl.active.Write(buf); err != nil {
+		return 0, sl.undo(err)
+	}
+	if sync {
+		if err := sl.active.Sync(); err != nil {
+			return 0, sl.undo(err)
+		}
+	}
+	sl.size += int64(len(buf))
+	sl.lastSeq = seq
+	return seq, nil
+}
+
+// LastSeq returns the sequence number of the last record of the subject, or 0 if it has none.
+func (l *Log) LastSeq(subject string) uint64 {
+	sl, err := l.subject(subject, false)
+	if err != nil || sl == nil {
+		return 0
+	}
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.lastSeq
+}
+
+// Read calls fn for every record of the subject with from <= Seq <= to, in order.
+// Reading stops at the first error returned by fn, which is passed to the caller.
+func (l *Log) Read(subject string, from, to uint64, fn func(Record) error) error {
+	sl, err := l.subject(subject, false)
+	if err != nil || sl == nil {
+		return err
+	}
+	sl.mu.Lock()
+	segments := append([]uint64(nil), sl.segments...)
+	if to > sl.lastSeq {
+		to = sl.lastSeq
+	}
+	sl.mu.Unlock()
+	if from > to {
+		return nil
+	}
+
+	for i, base := range segments {
+		if base > to {
+			break
+		}
+		if i+1 < len(segments) && segments[i+1] <= from {
+			continue
+		}
+		done, err := readSegment(filepath.Join(sl.dir, segmentName(base)), from, to, fn)
+		if err != nil || done {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the active segment of every subject.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	subjects := make([]*subjectLog, 0, len(l.subjects))
+	for _, sl := range l.subjects {
+		subjects = append(subjects, sl)
+	}
+	l.open.Init()
+	l.mu.Unlock()
+
+	// Appends take sl.mu before Log.mu, so segments are closed without Log.mu.
+	var errs []error
+	for _, sl := range subjects {
+		errs = append(errs, sl.closeActive())
+	}
+	return errors.Join(errs...)
+}
+
+func (sl *subjectLog) closeActive() error {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.active == nil {
+		return nil
+	}
+	err := sl.active.Close()
+	sl.active = nil
+	return err
+}
+
+func (l *Log) subject(subject string, create bool) (*subjectLog, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	sl, ok := l.subjects[subject]
+	if ok || !create {
+		return sl, nil
+	}
+	if subject == "" || subject == "." || subject == ".." {
+		return nil, fmt.Errorf("msglog: invalid subject %q", subject)
+	}
+	dir := filepath.Join(l.dir, url.PathEscape(subject))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	sl = &subjectLog{dir: dir}
+	l.subjects[subject] = sl
+	return sl, nil
+}
+
+func openSubject(dir string) (*subjectLog, error) {
+	sl := &subjectLog{dir: dir}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		sl.segments = append(sl.segments, base)
+	}
+	sort.Slice(sl.segments, func(i, j int) bool { return sl.segments[i] < sl.segments[j] })
+	if len(sl.segments) == 0 {
+		return sl, nil
+	}
+
+	base := sl.segments[len(sl.segments)-1]
+	path := filepath.Join(dir, segmentName(base))
+	lastSeq, valid, err := scanSegment(path)
+	if err != nil {
+		return nil, err
+	}
+	if lastSeq == 0 {
+		lastSeq = base - 1
+	}
+	// The segment is opened for appending on the next append only.
+	if err := os.Truncate(path, valid); err != nil {
+		return nil, err
+	}
+	sl.size = valid
+	sl.lastSeq = lastSeq
+	return sl, nil
+}
+
+// reopen opens the last segment for appending after it was closed to save file
+// descriptors.
+// undo drops whatever part of a failed record reached the active segment, so
+// the next record is not written after it. If the segment cannot be truncated
+// it is closed and reopen retries before anything else is appended.
+func (sl *subjectLog) undo(err error) error {
+	if terr := sl.active.Truncate(sl.size); terr != nil {
+		sl.active.Close()
+		sl.active = nil
+	}
+	return err
+}
+
+func (sl *subjectLog) reopen() error {
+	path := filepath.Join(sl.dir, segmentName(sl.segments[len(sl.segments)-1]))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(sl.size); err != nil {
+		f.Close()
+		return err
+	}
+	sl.active = f
+	return nil
+}
+
+// roll starts a new segment beginning with the next sequence number.
+func (sl *subjectLog) roll() error {
+	base := sl.lastSeq + 1
+	f, err := os.OpenFile(filepath.Join(sl.dir, segmentName(base)), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if sl.active != nil {
+		if err := sl.active.Close(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if n := len(sl.segments); n > 0 && sl.segments[n-1] == base {
+		sl.segments = sl.segments[:n-1]
+	}
+	sl.segments = append(sl.segments, base)
+	sl.active = f
+	sl.size = 0
+	return nil
+}
+
+func segmentName(base uint64) string {
+	return fmt.Sprintf("%020d%s", base, segmentExt)
+}
+
+// scanSegment returns the last sequence number of a segment and the length of its valid prefix.
+func scanSegment(path string) (lastSeq uint64, valid int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	r := bufio.NewReader(f)
+	for {
+		rec, n, err := readRecord(r, info.Size()-valid)
+		if err != nil {
+			return lastSeq, valid, nil
+		}
+		lastSeq = rec.Seq
+		valid += n
+	}
+}
+
+func readSegment(path string, from, to uint64, fn func(Record) error) (done bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		rec, n, err := readRecord(r, info.Size()-offset)
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		offset += n
+		if rec.Seq < from {
+			continue
+		}
+		if rec.Seq > to {
+			return true, nil
+		}
+		if err := fn(rec); err != nil {
+			return true, err
+		}
+		// Records past the bound may still be in the middle of being written.
+		if rec.Seq == to {
+			return true, nil
+		}
+	}
+}
+
+var errCorrupt = errors.New("msglog: corrupt record")
+
+// readRecord reads the next record of a segment with remaining bytes left. A
+// length larger than that is corrupt and rejected before allocating the body.
+func readRecord(r io.Reader, remaining int64) (Record, int64, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return Record{}, 0, errCorrupt
+		}
+		return Record{}, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size < metaSize || int64(size) > remaining-headerSize {
+		return Record{}, 0, errCorrupt
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Record{}, 0, errCorrupt
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+		return Record{}, 0, errCorrupt
+	}
+	return Record{
+		Seq:  binary.BigEndian.Uint64(body[0:8]),
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(body[8:16]))),
+		Data: body[metaSize:],
+	}, headerSize + int64(size), nil
+}
@@ -0,0 +1,300 @@
+package msglog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	t.Run("Append and Read", func(t *testing.T) {
+		l, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer l.Close()
+
+		for i := 1; i <= 5; i++ {
+			seq, err := l.Append("orders", time.Unix(int64(i), 0), []byte(fmt.Sprintf("msg%d", i)))
+			if err != nil {
+				t.Fatalf("Append failed: %v", err)
+			}
+			if seq != uint64(i) {
+				t.Errorf("Expected seq %d, got %d", i, seq)
+			}
+		}
+		if seq, _ := l.Append("users", time.Now(), []byte("other")); seq != 1 {
+			t.Errorf("Expected independent sequence per subject, got %d", seq)
+		}
+
+		var got []string
+		err = l.Read("orders", 2, 4, func(r Record) error {
+			got = append(got, fmt.Sprintf("%d:%s:%d", r.Seq, r.Data, r.Time.Unix()))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		want := []string{"2:msg2:2", "3:msg3:3", "4:msg4:4"}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+		if l.LastSeq("orders") != 5 || l.LastSeq("missing") != 0 {
+			t.Errorf("Unexpected LastSeq: orders=%d missing=%d", l.LastSeq("orders"), l.LastSeq("missing"))
+		}
+	})
+
+	t.Run("Segments Roll Over", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := Open(dir, WithSegmentSize(64))
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer l.Close()
+
+		for i := 0; i < 10; i++ {
+			if _, err := l.Append("orders", time.Now(), []byte("0123456789")); err != nil {
+				t.Fatalf("Append failed: %v", err)
+			}
+		}
+		segments, _ := filepath.Glob(filepath.Join(dir, "orders", "*"+segmentExt))
+		if len(segments) < 3 {
+			t.Errorf("Expected several segments, got %v", segments)
+		}
+
+		var seqs []uint64
+		l.Read("orders", 4, 10, func(r Record) error {
+			seqs = append(seqs, r.Seq)
+			return nil
+		})
+		if len(seqs) != 7 || seqs[0] != 4 || seqs[6] != 10 {
+			t.Errorf("Expected seqs 4..10 across segments, got %v", seqs)
+		}
+	})
+
+	t.Run("Reopen Recovers Sequence", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := Open(dir, WithSegmentSize(64))
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		for i := 0; i < 5; i++ {
+			l.Append("a.b", time.Now(), []byte("0123456789"))
+		}
+		l.Close()
+
+		l, err = Open(dir, WithSegmentSize(64))
+		if err != nil {
+			t.Fatalf("Reopen failed: %v", err)
+		}
+		defer l.Close()
+		if l.LastSeq("a.b") != 5 {
+			t.Fatalf("Expected recovered seq 5, got %d", l.LastSeq("a.b"))
+		}
+		if seq, _ := l.Append("a.b", time.Now(), []byte("next")); seq != 6 {
+			t.Errorf("Expected seq 6 after reopen, got %d", seq)
+		}
+	})
+
+	t.Run("Torn Tail Is Truncated", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := Open(dir)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		l.Append("orders", time.Now(), []byte("first"))
+		l.Append("orders", time.Now(), []byte("second"))
+		l.Close()
+
+		path := filepath.Join(dir, "orders", segmentName(1))
+		info, _ := os.Stat(path)
+		if err := os.Truncate(path, info.Size()-3); err != nil {
+			t.Fatalf("Truncate failed: %v", err)
+		}
+
+		l, err = Open(dir)
+		if err != nil {
+			t.Fatalf("Reopen failed: %v", err)
+		}
+		defer l.Close()
+		if l.LastSeq("orders") != 1 {
+			t.Fatalf("Expected torn record to be dropped, last seq %d", l.LastSeq("orders"))
+		}
+		if seq, _ := l.Append("orders", time.Now(), []byte("again")); seq != 2 {
+			t.Errorf("Expected seq 2 to be reused, got %d", seq)
+		}
+		var data []string
+		l.Read("orders", 1, 2, func(r Record) error {
+			data = append(data, string(r.Data))
+			return nil
+		})
+		if fmt.Sprint(data) != "[first again]" {
+			t.Errorf("Expected [first again], got %v", data)
+		}
+	})
+
+	t.Run("Failed Write Is Cut Off", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := Open(dir)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer l.Close()
+		l.Append("orders", time.Now(), []byte("first"))
+
+		// A partial record on disk and a segment that can neither be written
+		// nor truncated, as after a failing disk.
+		path := filepath.Join(dir, "orders", segmentName(1))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		f.Write([]byte{0, 0, 0, 64, 1, 2})
+		f.Close()
+		ro, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Open segment failed: %v", err)
+		}
+		sl, _ := l.subject("orders", false)
+		sl.mu.Lock()
+		sl.active.Close()
+		sl.active = ro
+		sl.mu.Unlock()
+
+		if _, err := l.Append("orders", time.Now(), []byte("lost")); err == nil {
+			t.Fatal("Expected the write to fail")
+		}
+		if seq, err := l.Append("orders", time.Now(), []byte("second")); err != nil || seq != 2 {
+			t.Fatalf("Expected seq 2, got %d, %v", seq, err)
+		}
+		var data []string
+		l.Read("orders", 1, 2, func(r Record) error {
+			data = append(data, string(r.Data))
+			return nil
+		})
+		if fmt.Sprint(data) != "[first second]" {
+			t.Errorf("Expected [first second], got %v", data)
+		}
+	})
+
+	t.Run("Open Segments Are Capped", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := Open(dir, WithMaxOpenSegments(4))
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		for round := 1; round <= 2; round++ {
+			for i := 0; i < 50; i++ {
+				seq, err := l.Append(fmt.Sprintf("s%d", i), time.Now(), []byte("x"))
+				if err != nil {
+					t.Fatalf("Append failed: %v", err)
+				}
+				if seq != uint64(round) {
+					t.Errorf("Expected seq %d after reopening, got %d", round, seq)
+				}
+			}
+		}
+		open := 0
+		for _, sl := range l.subjects {
+			if sl.active != nil {
+				open++
+			}
+		}
+		if open > 4 || l.open.Len() > 4 {
+			t.Errorf("Expected at most 4 open segments, got %d (%d listed)", open, l.open.Len())
+		}
+		var n int
+		l.Read("s0", 1, 2, func(r Record) error {
+			n++
+			return nil
+		})
+		if n != 2 {
+			t.Errorf("Expected both records of an evicted subject, got %d", n)
+		}
+		l.Close()
+
+		// Recovery opens no segment until the subject is appended to.
+		l, err = Open(dir, WithMaxOpenSegments(4))
+		if err != nil {
+			t.Fatalf("Reopen failed: %v", err)
+		}
+		defer l.Close()
+		for _, sl := range l.subjects {
+			if sl.active != nil {
+				t.Fatal("Expected recovered segments to be closed")
+			}
+		}
+		if seq, _ := l.Append("s7", time.Now(), []byte("x")); seq != 3 {
+			t.Errorf("Expected seq 3, got %d", seq)
+		}
+	})
+
+	t.Run("Corrupt Length Is Rejected", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := Open(dir)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		l.Append("orders", time.Now(), []byte("first"))
+		l.Close()
+
+		// A header claiming a record of almost 4 GiB follows the valid one.
+		path := filepath.Join(dir, "orders", segmentName(1))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		f.Write([]byte{0xff, 0xff, 0xff, 0xf0, 0, 0, 0, 0, 1, 2, 3})
+		f.Close()
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		l, err = Open(dir)
+		if err != nil {
+			t.Fatalf("Reopen failed: %v", err)
+		}
+		defer l.Close()
+		runtime.ReadMemStats(&after)
+		if grown := after.TotalAlloc - before.TotalAlloc; grown > 1<<20 {
+			t.Errorf("Expected the corrupt length to be rejected before allocating, allocated %d bytes", grown)
+		}
+		if l.LastSeq("orders") != 1 {
+			t.Errorf("Expected the corrupt record to be truncated, last seq %d", l.LastSeq("orders"))
+		}
+	})
+
+	t.Run("Read Stops On Callback Error", func(t *testing.T) {
+		l, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer l.Close()
+		for i := 0; i < 3; i++ {
+			l.Append("orders", time.Now(), []byte("msg"))
+		}
+		stop := errors.New("stop")
+		calls := 0
+		err = l.Read("orders", 1, 3, func(r Record) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Errorf("Expected read to stop after first record, got err %v after %d calls", err, calls)
+		}
+	})
+
+	t.Run("Closed Log", func(t *testing.T) {
+		l, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		l.Close()
+		if _, err := l.Append("orders", time.Now(), nil); !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"asyn-subpub-service/internal/subpub"
+	"asyn-subpub-service/pb/proto/api"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SubscribeWithAck delivers events at least once on a single stream. Events still
+// unacknowledged when the stream ends are not redelivered elsewhere. Once
+// maxInFlight events await their ack, no more are taken from the subscription,
+// whose buffer then fills up under its overflow policy.
+func (s *Server) SubscribeWithAck(stream pb.PubSub_SubscribeWithAckServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	req := first.GetSubscribe()
+	if req == nil {
+		return status.Error(codes.InvalidArgument, "first message must be a subscribe request")
+	}
+
+	a := &ackStream{
+		server:  s,
+		stream:  stream,
+		pending: make(map[string]*pendingEvent),
+	}
+	a.room = sync.NewCond(&a.mu)
+	sub, err := s.subscribe(stream.Context(), req, func(msg interface{}) {
+		event, ok := toEvent(msg)
+		if !ok {
+			return
+		}
+		subject := req.Key
+		if m, ok := msg.(*subpub.Message); ok {
+			subject = m.Subject
+		}
+		if event.Id == "" {
+			event.Id = subpub.NewID()
+		}
+		a.deliver(event, subject)
+	})
+	if err != nil {
+		return err
+	}
+	// A handler waiting for room must return before the subscription can end.
+	end := func() error {
+		a.close()
+		return teardown(sub)
+	}
+
+	recvErr := make(chan error, 1)
+	go func() {
+		recvErr <- a.receive()
+	}()
+
+	ticker := time.NewTicker(a.checkInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return end()
+		case <-sub.Done():
+			return end()
+		case err := <-recvErr:
+			if teardownErr := end(); teardownErr != nil {
+				return teardownErr
+			}
+			// Recv also fails when the client goes away, which is not an error of the handler.
+			if errors.Is(err, io.EOF) || stream.Context().Err() != nil {
+				return nil
+			}
+			return err
+		case now := <-ticker.C:
+			a.redeliver(now)
+		}
+	}
+}
+
+// pendingEvent is an event sent on an acknowledged stream and waiting for its ack.
+type pendingEvent struct {
+	event    *pb.Event
+	subject  string
+	deadline time.Time
+}
+
+type ackStream struct {
+	server *Server
+	stream pb.PubSub_SubscribeWithAckServer
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	room    *sync.Cond // signalled on mu when pending shrinks or the stream ends
+	pending map[string]*pendingEvent
+	closed  bool
+}
+
+// checkInterval is how often pending events are checked against their deadlines.
+func (a *ackStream) checkInterval() time.Duration {
+	interval := a.server.ackDeadline / 4
+	if interval < 10*time.Millisecond {
+		return 10 * time.Millisecond
+	}
+	if interval > time.Second {
+		return time.Second
+	}
+	return interval
+}
+
+// deliver records the event as pending before sending it, so an early ack is never
+// missed. It waits while maxInFlight events are pending.
+func (a *ackStream) deliver(event *pb.Event, subject string) {
+	event.DeliveryAttempt = 1
+	a.mu.Lock()
+	for len(a.pending) >= a.server.maxInFlight && !a.closed {
+		a.room.Wait()
+	}
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.pending[event.Id] = &pendingEvent{
+		event:    event,
+		subject:  subject,
+		deadline: time.Now().Add(a.server.ackDeadline),
+	}
+	a.mu.Unlock()
+	a.send(event)
+}
+
+// close releases a handler waiting for room; nothing is delivered afterwards.
+func (a *ackStream) close() {
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+	a.room.Broadcast()
+}
+
+func (a *ackStream) send(event *pb.Event) {
+	a.sendMu.Lock()
+	defer a.sendMu.Unlock()
+	if err := a.stream.Send(event); err != nil {
+		log.Printf("Error sending event: %v", err)
+	}
+}
+
+// receive applies acks until the client stops sending.
+func (a *ackStream) receive() error {
+	for {
+		req, err := a.stream.Recv()
+		if err != nil {
+			return err
+		}
+		ack := req.GetAck()
+		if ack == nil {
+			return status.Error(codes.InvalidArgument, "expected ack after subscribe request")
+		}
+		a.mu.Lock()
+		for _, id := range ack.Ids {
+			delete(a.pending, id)
+		}
+		a.mu.Unlock()
+		a.room.Broadcast()
+	}
+}
+
+// redeliver resends events whose ack deadline passed and dead-letters those out of attempts.
+func (a *ackStream) redeliver(now time.Time) {
+	var resend []*pb.Event
+	var dead []*pendingEvent
+	a.mu.Lock()
+	for id, p := range a.pending {
+		if now.Before(p.deadline) {
+			continue
+		}
+		if int(p.event.DeliveryAttempt) >= a.server.maxDeliveries {
+			delete(a.pending, id)
+			dead = append(dead, p)
+			continue
+		}
+		// The sent event may still be referenced by the transport, so resend a copy.
+		event := proto.Clone(p.event).(*pb.Event)
+		event.DeliveryAttempt++
+		p.event = event
+		p.deadline = now.Add(a.server.ackDeadline)
+		resend = append(resend, event)
+	}
+	a.mu.Unlock()
+	if len(dead) > 0 {
+		a.room.Broadcast()
+	}
+
+	sort.Slice(resend, func(i, j int) bool { return resend[i].Sequence < resend[j].Sequence })
+	for _, event := range resend {
+		a.send(event)
+	}
+	for _, p := range dead {
+		a.server.deadLetter(p)
+	}
+}
+
+// deadLetter publishes an event that exhausted its deliveries under the dead-letter prefix.
+func (s *Server) deadLetter(p *pendingEvent) {
+	subject := s.deadLetterPrefix + "." + p.subject
+	if err := s.subpub.Publish(subject, messageFromEvent(p.event)); err != nil {
+		log.Printf("Error dead-lettering event %s to %s: %v", p.event.Id, subject, err)
+	}
+}
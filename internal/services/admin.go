@@ -0,0 +1,126 @@
+package services
+
+import (
+	"asyn-subpub-service/internal/auth"
+	"asyn-subpub-service/internal/subpub"
+	"asyn-subpub-service/pb/proto/api"
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"sort"
+)
+
+// AdminServer serves the Admin service on the broker and ACL of a Server.
+type AdminServer struct {
+	pb.UnimplementedAdminServer
+	server *Server
+}
+
+func NewAdminServer(server *Server) *AdminServer {
+	if server == nil {
+		panic("server is nil")
+	}
+	return &AdminServer{server: server}
+}
+
+// ListSubjects requires admin access to every subject.
+func (a *AdminServer) ListSubjects(ctx context.Context, _ *pb.ListSubjectsRequest) (*pb.ListSubjectsResponse, error) {
+	if err := a.server.authorize(ctx, auth.Admin, ">"); err != nil {
+		return nil, err
+	}
+	bySubject := make(map[string]*pb.SubjectInfo)
+	for _, info := range a.server.subpub.Subscriptions() {
+		s, ok := bySubject[info.Subject]
+		if !ok {
+			s = &pb.SubjectInfo{Subject: info.Subject}
+			bySubject[info.Subject] = s
+		}
+		s.Subscriptions++
+		s.Pending += uint64(info.Pending)
+	}
+	resp := &pb.ListSubjectsResponse{Subjects: make([]*pb.SubjectInfo, 0, len(bySubject))}
+	for _, s := range bySubject {
+		resp.Subjects = append(resp.Subjects, s)
+	}
+	sort.Slice(resp.Subjects, func(i, j int) bool { return resp.Subjects[i].Subject < resp.Subjects[j].Subject })
+	return resp, nil
+}
+
+// ListSubscriptions requires admin access to the subject filter.
+func (a *AdminServer) ListSubscriptions(ctx context.Context, req *pb.ListSubscriptionsRequest) (*pb.ListSubscriptionsResponse, error) {
+	filter := req.Subject
+	if filter == "" {
+		filter = ">"
+	}
+	if !subpub.Covers(filter, filter) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid subject %q", req.Subject)
+	}
+	if err := a.server.authorize(ctx, auth.Admin, filter); err != nil {
+		return nil, err
+	}
+	resp := &pb.ListSubscriptionsResponse{}
+	for _, info := range a.server.subpub.Subscriptions() {
+		if !subpub.Covers(filter, info.Subject) {
+			continue
+		}
+		resp.Subscriptions = append(resp.Subscriptions, &pb.SubscriptionInfo{
+			Id:         info.ID,
+			Subject:    info.Subject,
+			QueueGroup: info.Queue,
+			Peer:       info.Peer,
+			Pending:    uint32(info.Pending),
+			Capacity:   uint32(info.Capacity),
+			Delivered:  info.Delivered,
+			Dropped:    info.Dropped,
+			Evicted:    info.Evicted,
+			Expired:    info.Expired,
+		})
+	}
+	sort.Slice(resp.Subscriptions, func(i, j int) bool {
+		x, y := resp.Subscriptions[i], resp.Subscriptions[j]
+		if x.Subject != y.Subject {
+			return x.Subject < y.Subject
+		}
+		return x.Id < y.Id
+	})
+	return resp, nil
+}
+
+// DisconnectSubscription requires admin access to the subject of the subscription.
+// Unknown ids and subscriptions the caller may not administer are both NOT_FOUND,
+// so that ids of other subjects cannot be probed.
+func (a *AdminServer) DisconnectSubscription(ctx context.Context, req *pb.DisconnectSubscriptionRequest) (*emptypb.Empty, error) {
+	notFound := status.Errorf(codes.NotFound, "subscription %q not found", req.Id)
+	var subject string
+	for _, info := range a.server.subpub.Subscriptions() {
+		if info.ID == req.Id {
+			subject = info.Subject
+		}
+	}
+	if subject == "" || a.server.authorize(ctx, auth.Admin, subject) != nil {
+		return nil, notFound
+	}
+	if err := a.server.subpub.Disconnect(req.Id); errors.Is(err, subpub.ErrSubscriptionNotFound) {
+		return nil, notFound
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to disconnect: %v", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// DrainSubject requires admin access to the subject.
+func (a *AdminServer) DrainSubject(ctx context.Context, req *pb.DrainSubjectRequest) (*pb.DrainSubjectResponse, error) {
+	if err := a.server.authorize(ctx, auth.Admin, req.Subject); err != nil {
+		return nil, err
+	}
+	n, err := a.server.subpub.Drain(ctx, req.Subject)
+	switch {
+	case errors.Is(err, subpub.ErrInvalidSubject):
+		return nil, status.Errorf(codes.InvalidArgument, "invalid subject %q", req.Subject)
+	case err != nil:
+		return nil, status.FromContextError(err).Err()
+	}
+	return &pb.DrainSubjectResponse{Drained: uint32(n)}, nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"asyn-subpub-service/internal/auth"
+	"asyn-subpub-service/internal/subpub"
+	"asyn-subpub-service/pb/proto/api"
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/status"
+	"io"
+)
+
+// maxStreamBatch bounds how many streamed messages are published under one lock.
+const maxStreamBatch = 256
+
+func (s *Server) PublishBatch(ctx context.Context, req *pb.PublishBatchRequest) (*pb.PublishBatchResponse, error) {
+	return &pb.PublishBatchResponse{Results: s.publishBatch(ctx, req.Messages)}, nil
+}
+
+// PublishStream publishes messages in batches of whatever has arrived since the
+// previous batch, so a busy stream shares the lock without delaying a quiet one.
+func (s *Server) PublishStream(stream pb.PubSub_PublishStreamServer) error {
+	reqs := make(chan *pb.PublishRequest, maxStreamBatch)
+	recvErr := make(chan error, 1)
+	go func() {
+		defer close(reqs)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			reqs <- req
+		}
+	}()
+
+	var results []*pb.PublishResult
+	batch := make([]*pb.PublishRequest, 0, maxStreamBatch)
+	for req := range reqs {
+		batch = collect(append(batch[:0], req), reqs)
+		results = append(results, s.publishBatch(stream.Context(), batch)...)
+	}
+	if err := <-recvErr; !errors.Is(err, io.EOF) {
+		return err
+	}
+	return stream.SendAndClose(&pb.PublishBatchResponse{Results: results})
+}
+
+// collect appends the requests already waiting in reqs to batch without blocking.
+func collect(batch []*pb.PublishRequest, reqs <-chan *pb.PublishRequest) []*pb.PublishRequest {
+	for len(batch) < maxStreamBatch {
+		select {
+		case req, ok := <-reqs:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, req)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// publishBatch publishes the valid requests with a single SubPub.PublishBatch call
+// and returns one result per request.
+func (s *Server) publishBatch(ctx context.Context, reqs []*pb.PublishRequest) []*pb.PublishResult {
+	results := make([]*pb.PublishResult, len(reqs))
+	msgs := make([]subpub.BatchMessage, 0, len(reqs))
+	index := make([]int, 0, len(reqs))
+	for i, req := range reqs {
+		if err := s.authorize(ctx, auth.Publish, req.Key); err != nil {
+			results[i] = rejected(status.Convert(err).Message())
+			continue
+		}
+		msg, err := messageFromRequest(req)
+		if err != nil {
+			results[i] = rejected(status.Convert(err).Message())
+			continue
+		}
+		s.forwardMetadata(ctx, msg)
+		msg.ID = subpub.NewID()
+		results[i] = &pb.PublishResult{Id: msg.ID}
+		msgs = append(msgs, subpub.BatchMessage{Subject: req.Key, Msg: msg})
+		index = append(index, i)
+	}
+
+	for j, r := range s.subpub.PublishBatch(msgs) {
+		i := index[j]
+		switch {
+		case errors.Is(r.Err, subpub.ErrInvalidSubject):
+			results[i] = rejected(fmt.Sprintf("invalid key %q", reqs[i].Key))
+		case r.Err != nil:
+			results[i] = rejected(fmt.Sprintf("failed to publish: %v", r.Err))
+		default:
+			results[i].Status = publishStatus(r.Status())
+		}
+	}
+	return results
+}
+
+func rejected(reason string) *pb.PublishResult {
+	return &pb.PublishResult{Status: pb.PublishResult_REJECTED, Error: reason}
+}
+
+func publishStatus(s subpub.PublishStatus) pb.PublishResult_Status {
+	switch s {
+	case subpub.Accepted:
+		return pb.PublishResult_ACCEPTED
+	case subpub.Dropped:
+		return pb.PublishResult_DROPPED
+	case subpub.NoSubscribers:
+		return pb.PublishResult_NO_SUBSCRIBERS
+	default:
+		return pb.PublishResult_STATUS_UNSPECIFIED
+	}
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"asyn-subpub-service/internal/subpub"
+	"asyn-subpub-service/pb/proto/api"
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	contentTypeText   = "text/plain; charset=utf-8"
+	contentTypeBinary = "application/octet-stream"
+	contentTypeJSON   = "application/json"
+	// contentTypeAny prefixes the type URL of typed payloads stored as plain bytes.
+	contentTypeAny = "application/x-protobuf; type="
+)
+
+// messageFromRequest builds the envelope published for a PublishRequest.
+// Typed payloads travel through subpub as their serialized bytes.
+func messageFromRequest(req *pb.PublishRequest) (*subpub.Message, error) {
+	if req.Payload != nil && len(req.Data) > 0 {
+		return nil, status.Error(codes.InvalidArgument, "data and payload are mutually exclusive")
+	}
+	var ttl time.Duration
+	if req.Ttl != nil {
+		if err := req.Ttl.CheckValid(); err != nil || req.Ttl.AsDuration() <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "ttl must be positive")
+		}
+		ttl = req.Ttl.AsDuration()
+	}
+	m := &subpub.Message{
+		Data:        req.Data,
+		ContentType: req.ContentType,
+		Headers:     req.Headers,
+		Retain:      req.Retain,
+		TTL:         ttl,
+	}
+	if req.Payload != nil {
+		m.Data = req.Payload.Value
+		m.ContentType = contentTypeAny + req.Payload.TypeUrl
+	}
+	return m, nil
+}
+
+// messageFromEvent rebuilds the envelope of an event, e.g. to dead-letter it.
+func messageFromEvent(event *pb.Event) *subpub.Message {
+	m := &subpub.Message{ID: event.Id, Data: event.Data, ContentType: event.ContentType, Headers: event.Headers}
+	if event.Payload != nil {
+		m.Data = event.Payload.Value
+		m.ContentType = contentTypeAny + event.Payload.TypeUrl
+	}
+	return m
+}
+
+// toEvent converts a delivered message to an event. Messages published in-process
+// may be of any type: strings, bytes and protobuf messages map to their natural
+// representation, anything else is encoded as JSON. It reports false for values
+// that cannot be encoded, which are skipped instead of breaking the stream.
+func toEvent(msg interface{}) (*pb.Event, bool) {
+	switch m := msg.(type) {
+	case *subpub.Message:
+		event := &pb.Event{Sequence: m.Seq, Id: m.ID, Subject: m.Subject, Headers: m.Headers, Retained: m.Retain}
+		if !m.Time.IsZero() {
+			event.PublishedAt = timestamppb.New(m.Time)
+		}
+		if typeURL, ok := strings.CutPrefix(m.ContentType, contentTypeAny); ok {
+			event.Payload = &anypb.Any{TypeUrl: typeURL, Value: m.Data}
+			return event, true
+		}
+		event.Data = m.Data
+		event.ContentType = m.ContentType
+		return event, true
+	case string:
+		return &pb.Event{Data: []byte(m), ContentType: contentTypeText}, true
+	case []byte:
+		return &pb.Event{Data: m, ContentType: contentTypeBinary}, true
+	case proto.Message:
+		payload, err := anypb.New(m)
+		if err != nil {
+			log.Printf("Skipping event of type %T: %v", msg, err)
+			return nil, false
+		}
+		return &pb.Event{Payload: payload}, true
+	default:
+		data, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("Skipping event of unsupported type %T: %v", msg, err)
+			return nil, false
+		}
+		return &pb.Event{Data: data, ContentType: contentTypeJSON}, true
+	}
+}
+
+// forwardMetadata copies the configured incoming gRPC metadata keys into the headers
+// of msg. Headers set explicitly by the publisher take precedence.
+func (s *Server) forwardMetadata(ctx context.Context, msg *subpub.Message) {
+	if len(s.forwardedMetadata) == 0 {
+		return
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return
+	}
+	var headers map[string]string
+	for _, key := range s.forwardedMetadata {
+		values := md.Get(key)
+		if len(values) == 0 {
+			continue
+		}
+		if _, set := msg.Headers[key]; set {
+			continue
+		}
+		if headers == nil {
+			// Copy so the headers of the incoming request are left untouched.
+			headers = make(map[string]string, len(msg.Headers)+len(s.forwardedMetadata))
+			for k, v := range msg.Headers {
+				headers[k] = v
+			}
+		}
+		headers[key] = strings.Join(values, ",")
+	}
+	if headers != nil {
+		msg.Headers = headers
+	}
+}
@@ -1,47 +1,340 @@
 package services
 
 import (
+	"asyn-subpub-service/internal/auth"
 	"asyn-subpub-service/internal/subpub"
 	"asyn-subpub-service/pb/proto/api"
 	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/types/known/emptypb"
 	"log"
+	"strings"
+	"time"
+)
+
+// SubscriptionIDHeader carries the id of the subscription in the headers of a
+// Subscribe stream, as listed by the Admin service.
+const SubscriptionIDHeader = "subscription-id"
+
+// ErrorDomain is the domain of the ErrorInfo details that tell clients which
+// subpub error a status stands for, so they need not parse its message.
+const ErrorDomain = "subpub"
+
+// Reasons of the ErrorInfo details in ErrorDomain.
+const (
+	ReasonSlowConsumer = "SLOW_CONSUMER"
+	ReasonDisconnected = "DISCONNECTED"
+	ReasonDrained      = "DRAINED"
+	ReasonNoResponders = "NO_RESPONDERS"
 )
 
 type Server struct {
 	pb.UnimplementedPubSubServer
 	subpub subpub.SubPub
+
+	ackDeadline       time.Duration
+	maxDeliveries     int
+	maxInFlight       int
+	deadLetterPrefix  string
+	forwardedMetadata []string
+	requireDelivery   bool
+	requestTimeout    time.Duration
+	acl               *auth.ACL
+}
+
+// Option configures a Server created by NewServer.
+type Option func(*Server)
+
+// WithAckDeadline sets how long SubscribeWithAck waits for an acknowledgement before redelivering.
+func WithAckDeadline(d time.Duration) Option {
+	return func(s *Server) {
+		if d > 0 {
+			s.ackDeadline = d
+		}
+	}
+}
+
+// WithMaxDeliveries sets how many times SubscribeWithAck sends an event before dead-lettering it.
+func WithMaxDeliveries(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.maxDeliveries = n
+		}
+	}
+}
+
+// WithMaxInFlight sets how many events SubscribeWithAck sends without an ack
+// before it stops taking messages from the subscription.
+func WithMaxInFlight(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.maxInFlight = n
+		}
+	}
+}
+
+// WithDeadLetterPrefix sets the key prefix under which undeliverable events are published.
+func WithDeadLetterPrefix(prefix string) Option {
+	return func(s *Server) {
+		if prefix != "" {
+			s.deadLetterPrefix = prefix
+		}
+	}
+}
+
+// WithForwardedMetadata sets the incoming gRPC metadata keys copied into message headers on publish.
+func WithForwardedMetadata(keys ...string) Option {
+	return func(s *Server) {
+		for _, key := range keys {
+			if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+				s.forwardedMetadata = append(s.forwardedMetadata, key)
+			}
+		}
+	}
 }
 
-func NewServer(subpub subpub.SubPub) *Server {
+// WithRequireDelivery makes Publish fail with ResourceExhausted when no subscriber
+// buffered the message. A configured message log still keeps it.
+func WithRequireDelivery(require bool) Option {
+	return func(s *Server) {
+		s.requireDelivery = require
+	}
+}
+
+// WithRequestTimeout bounds how long Request waits for a reply, including calls
+// that carry a later deadline or none at all.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		if d > 0 {
+			s.requestTimeout = d
+		}
+	}
+}
+
+// WithACL restricts the keys callers may publish and subscribe to.
+func WithACL(acl *auth.ACL) Option {
+	return func(s *Server) {
+		s.acl = acl
+	}
+}
+
+func NewServer(subpub subpub.SubPub, opts ...Option) *Server {
 	if subpub == nil {
 		panic("subpub is nil")
 	}
-	return &Server{
-		subpub: subpub,
+	s := &Server{
+		subpub:           subpub,
+		ackDeadline:      30 * time.Second,
+		maxDeliveries:    5,
+		maxInFlight:      1000,
+		deadLetterPrefix: "$DLQ",
+		requestTimeout:   30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 func (s *Server) Subscribe(req *pb.SubscribeRequest, stream pb.PubSub_SubscribeServer) error {
-	_, err := s.subpub.Subscribe(req.Key, func(msg interface{}) {
-		event := &pb.Event{Data: msg.(string)}
-		if err := stream.Send(event); err != nil {
+	// The headers tell clients that the subscription is registered.
+	return s.streamEvents(stream.Context(), req, func(id string) error {
+		return stream.SendHeader(metadata.Pairs(SubscriptionIDHeader, id))
+	}, stream.Send)
+}
+
+// StreamEvents serves a subscription with the same authorization, replay and error
+// mapping as Subscribe, for transports other than gRPC. ready, if not nil, is called
+// once the subscription is registered, before the first send. It blocks until ctx
+// is done or the subscription ends, and send is never called after it returns.
+func (s *Server) StreamEvents(ctx context.Context, req *pb.SubscribeRequest, ready func(), send func(*pb.Event) error) error {
+	return s.streamEvents(ctx, req, func(string) error {
+		if ready != nil {
+			ready()
+		}
+		return nil
+	}, send)
+}
+
+// streamEvents is StreamEvents with ready told the id of the subscription. Events
+// wait for ready to return and are not sent at all if it fails.
+func (s *Server) streamEvents(ctx context.Context, req *pb.SubscribeRequest, ready func(id string) error, send func(*pb.Event) error) error {
+	started := make(chan struct{})
+	var readyErr error
+	sub, err := s.subscribe(ctx, req, func(msg interface{}) {
+		<-started
+		if readyErr != nil {
+			return
+		}
+		event, ok := toEvent(msg)
+		if !ok {
+			return
+		}
+		if err := send(event); err != nil {
 			log.Printf("Error sending event: %v", err)
 		}
 	})
 	if err != nil {
-		return status.Errorf(codes.Internal, "failed to subscribe: %v", err)
+		return err
 	}
-	<-stream.Context().Done()
-	return nil
+	readyErr = ready(sub.ID())
+	close(started)
+	if readyErr != nil {
+		teardown(sub)
+		return readyErr
+	}
+	select {
+	case <-ctx.Done():
+	case <-sub.Done():
+	}
+	return teardown(sub)
 }
 
-func (s *Server) Publish(ctx context.Context, req *pb.PublishRequest) (*emptypb.Empty, error) {
-	err := s.subpub.Publish(req.Key, req.Data)
+func (s *Server) Publish(ctx context.Context, req *pb.PublishRequest) (*pb.PublishResponse, error) {
+	if err := s.authorize(ctx, auth.Publish, req.Key); err != nil {
+		return nil, err
+	}
+	msg, err := messageFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	s.forwardMetadata(ctx, msg)
+	msg.ID = subpub.NewID()
+	res, err := s.subpub.PublishWithResult(req.Key, msg)
+	if errors.Is(err, subpub.ErrInvalidSubject) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid key %q", req.Key)
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to publish: %v", err)
 	}
-	return &emptypb.Empty{}, nil
+	if s.requireDelivery && res.Enqueued == 0 {
+		return nil, status.Errorf(codes.ResourceExhausted, "message was not delivered: %s", res.Status())
+	}
+	return &pb.PublishResponse{
+		Id:       msg.ID,
+		Matched:  uint32(res.Matched),
+		Enqueued: uint32(res.Enqueued),
+		Dropped:  uint32(res.Dropped),
+	}, nil
+}
+
+// ClearRetained requires publish access to every key matched by the request key.
+func (s *Server) ClearRetained(ctx context.Context, req *pb.ClearRetainedRequest) (*pb.ClearRetainedResponse, error) {
+	if err := s.authorize(ctx, auth.Publish, req.Key); err != nil {
+		return nil, err
+	}
+	n, err := s.subpub.ClearRetained(req.Key)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid key %q", req.Key)
+	}
+	return &pb.ClearRetainedResponse{Cleared: uint32(n)}, nil
+}
+
+// Request needs publish access to the key only; the reply key is subscribed to
+// in-process. The deadline of the incoming call bounds the wait for the reply.
+func (s *Server) Request(ctx context.Context, req *pb.PublishRequest) (*pb.Event, error) {
+	if err := s.authorize(ctx, auth.Publish, req.Key); err != nil {
+		return nil, err
+	}
+	msg, err := messageFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	s.forwardMetadata(ctx, msg)
+	msg.ID = subpub.NewID()
+	ctx, cancel := context.WithTimeout(ctx, s.requestTimeout)
+	defer cancel()
+	reply, err := s.subpub.Request(ctx, req.Key, msg)
+	switch {
+	case errors.Is(err, subpub.ErrInvalidSubject):
+		return nil, status.Errorf(codes.InvalidArgument, "invalid key %q", req.Key)
+	case errors.Is(err, subpub.ErrNoResponders):
+		return nil, reasonError(codes.Unavailable, ReasonNoResponders, fmt.Sprintf("no responders for key %q", req.Key))
+	case err != nil:
+		return nil, status.FromContextError(err).Err()
+	}
+	event, ok := toEvent(reply)
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "unsupported reply type %T", reply)
+	}
+	return event, nil
+}
+
+// subscribe registers handler for the request and maps subpub errors to gRPC statuses.
+func (s *Server) subscribe(ctx context.Context, req *pb.SubscribeRequest, handler subpub.MessageHandler) (subpub.Subscription, error) {
+	if err := s.authorize(ctx, auth.Subscribe, req.Key); err != nil {
+		return nil, err
+	}
+	opts := []subpub.SubscribeOption{subpub.StartAt(startPosition(req.StartFrom))}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		opts = append(opts, subpub.Peer(p.Addr.String()))
+	}
+	var sub subpub.Subscription
+	var err error
+	if req.QueueGroup != "" {
+		sub, err = s.subpub.SubscribeQueue(req.Key, req.QueueGroup, handler, opts...)
+	} else {
+		sub, err = s.subpub.Subscribe(req.Key, handler, opts...)
+	}
+	switch {
+	case errors.Is(err, subpub.ErrInvalidSubject):
+		return nil, status.Errorf(codes.InvalidArgument, "invalid key %q", req.Key)
+	case errors.Is(err, subpub.ErrReplayWildcard):
+		return nil, status.Error(codes.InvalidArgument, "start_from requires a key without wildcards")
+	case errors.Is(err, subpub.ErrNoLog):
+		return nil, status.Error(codes.FailedPrecondition, "start_from requires the server message log")
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "failed to subscribe: %v", err)
+	}
+	return sub, nil
+}
+
+// authorize checks the ACL for the caller stored in ctx.
+func (s *Server) authorize(ctx context.Context, action auth.Action, key string) error {
+	if s.acl.Allowed(auth.FromContext(ctx), action, key) {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "%s to key %q is not allowed", action, key)
+}
+
+// teardown stops delivery before the RPC handler returns: the stream must not be used afterwards.
+func teardown(sub subpub.Subscription) error {
+	sub.Unsubscribe()
+	<-sub.Done()
+	switch err := sub.Err(); {
+	case errors.Is(err, subpub.ErrSlowConsumer):
+		return reasonError(codes.ResourceExhausted, ReasonSlowConsumer, "subscriber too slow, disconnected")
+	case errors.Is(err, subpub.ErrDisconnected):
+		return reasonError(codes.Aborted, ReasonDisconnected, "subscription disconnected by an operator")
+	case errors.Is(err, subpub.ErrDrained):
+		return reasonError(codes.Aborted, ReasonDrained, "subscription drained by an operator")
+	}
+	return nil
+}
+
+// reasonError returns a status error with an ErrorInfo detail in ErrorDomain.
+func reasonError(code codes.Code, reason, msg string) error {
+	st := status.New(code, msg)
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func startPosition(from *pb.StartFrom) subpub.Position {
+	switch p := from.GetPosition().(type) {
+	case *pb.StartFrom_Earliest:
+		return subpub.Earliest
+	case *pb.StartFrom_Sequence:
+		return subpub.AtSequence(p.Sequence)
+	case *pb.StartFrom_Time:
+		return subpub.AtTime(p.Time.AsTime())
+	default:
+		return subpub.Latest
+	}
 }
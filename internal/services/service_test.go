@@ -1,13 +1,28 @@
 package services
 
 import (
+	"asyn-subpub-service/internal/auth"
+	"asyn-subpub-service/internal/msglog"
 	"asyn-subpub-service/internal/subpub"
 	"asyn-subpub-service/pb/proto/api"
 	"context"
+	"errors"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"io"
+	"net"
+	"net/netip"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -38,7 +53,7 @@ func TestServerPublish(t *testing.T) {
 	t.Run("Successful Publish", func(t *testing.T) {
 		sp := subpub.NewSubPub(100)
 		server := NewServer(sp)
-		req := &pb.PublishRequest{Key: "test", Data: "hello"}
+		req := &pb.PublishRequest{Key: "test", Data: []byte("hello")}
 		resp, err := server.Publish(context.Background(), req)
 		if err != nil {
 			t.Fatalf("Publish failed: %v", err)
@@ -47,6 +62,247 @@ func TestServerPublish(t *testing.T) {
 			t.Fatal("Response is nil")
 		}
 	})
+
+	t.Run("Delivery Counts", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		server := NewServer(sp)
+		received := make(chan *subpub.Message, 1)
+		if _, err := sp.Subscribe("orders", func(msg interface{}) {
+			received <- msg.(*subpub.Message)
+		}); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		resp, err := server.Publish(context.Background(), &pb.PublishRequest{Key: "orders", Data: []byte("hello")})
+		if err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		if resp.Matched != 1 || resp.Enqueued != 1 || resp.Dropped != 0 {
+			t.Errorf("Expected one enqueued subscriber, got %+v", resp)
+		}
+		if msg := <-received; msg.ID != resp.Id {
+			t.Errorf("Expected id %q, got %q", resp.Id, msg.ID)
+		}
+
+		resp, err = server.Publish(context.Background(), &pb.PublishRequest{Key: "users", Data: []byte("hello")})
+		if err != nil {
+			t.Fatalf("Publish without subscribers failed: %v", err)
+		}
+		if resp.Matched != 0 || resp.Enqueued != 0 {
+			t.Errorf("Expected no subscribers, got %+v", resp)
+		}
+	})
+
+	t.Run("Require Delivery", func(t *testing.T) {
+		sp := subpub.NewSubPub(1)
+		server := NewServer(sp, WithRequireDelivery(true))
+		_, err := server.Publish(context.Background(), &pb.PublishRequest{Key: "orders", Data: []byte("hello")})
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("Expected ResourceExhausted without subscribers, got %v", err)
+		}
+
+		release := make(chan struct{})
+		defer close(release)
+		if _, err := sp.Subscribe("orders", func(msg interface{}) { <-release }); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := server.Publish(context.Background(), &pb.PublishRequest{Key: "orders", Data: []byte("hello")}); err != nil {
+				t.Fatalf("Publish %d failed: %v", i, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		_, err = server.Publish(context.Background(), &pb.PublishRequest{Key: "orders", Data: []byte("hello")})
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("Expected ResourceExhausted with a full buffer, got %v", err)
+		}
+	})
+
+	t.Run("Wildcard Key Rejected", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(100))
+		_, err := server.Publish(context.Background(), &pb.PublishRequest{Key: "orders.*", Data: []byte("hello")})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument, got %v", err)
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		received := make(chan *subpub.Message, 1)
+		sp.Subscribe("orders", func(msg interface{}) { received <- msg.(*subpub.Message) })
+		server := NewServer(sp)
+		if _, err := server.Publish(context.Background(), &pb.PublishRequest{Key: "orders", Ttl: durationpb.New(time.Minute)}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		if m := <-received; m.TTL != time.Minute {
+			t.Errorf("Expected a TTL of one minute, got %v", m.TTL)
+		}
+		for _, ttl := range []*durationpb.Duration{durationpb.New(-time.Second), {Seconds: 1, Nanos: -1}} {
+			_, err := server.Publish(context.Background(), &pb.PublishRequest{Key: "orders", Ttl: ttl})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("Expected InvalidArgument for ttl %v, got %v", ttl, err)
+			}
+		}
+	})
+
+	t.Run("Retained", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(100))
+		if _, err := server.Publish(context.Background(), &pb.PublishRequest{Key: "status.eu", Data: []byte("up"), Retain: true}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		events := make(chan *pb.Event, 10)
+		stream := &mockPubSubStream{ctx: ctx, send: func(e *pb.Event) error {
+			events <- e
+			return nil
+		}}
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Subscribe(&pb.SubscribeRequest{Key: "status.*"}, stream)
+		}()
+		select {
+		case e := <-events:
+			if !e.Retained || string(e.Data) != "up" || e.Subject != "status.eu" {
+				t.Errorf("Expected the retained event, got %v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected retained event on subscribe")
+		}
+		cancel()
+		<-done
+
+		resp, err := server.ClearRetained(context.Background(), &pb.ClearRetainedRequest{Key: "status.>"})
+		if err != nil || resp.Cleared != 1 {
+			t.Errorf("Expected one cleared key, got %v (err %v)", resp, err)
+		}
+		if _, err := server.ClearRetained(context.Background(), &pb.ClearRetainedRequest{Key: "a..b"}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument, got %v", err)
+		}
+	})
+}
+
+func TestServerPublishBatch(t *testing.T) {
+	t.Run("Per-Message Results", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		server := NewServer(sp)
+		received := make(chan *subpub.Message, 10)
+		if _, err := sp.Subscribe("orders", func(msg interface{}) {
+			received <- msg.(*subpub.Message)
+		}); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		resp, err := server.PublishBatch(context.Background(), &pb.PublishBatchRequest{Messages: []*pb.PublishRequest{
+			{Key: "orders", Data: []byte("first")},
+			{Key: "orders.*", Data: []byte("wildcard")},
+			{Key: "users", Data: []byte("nobody")},
+			{Key: "orders", Data: []byte("both"), Payload: &anypb.Any{}},
+			{Key: "orders", Data: []byte("second")},
+		}})
+		if err != nil {
+			t.Fatalf("PublishBatch failed: %v", err)
+		}
+		want := []pb.PublishResult_Status{
+			pb.PublishResult_ACCEPTED,
+			pb.PublishResult_REJECTED,
+			pb.PublishResult_NO_SUBSCRIBERS,
+			pb.PublishResult_REJECTED,
+			pb.PublishResult_ACCEPTED,
+		}
+		if len(resp.Results) != len(want) {
+			t.Fatalf("Expected %d results, got %d", len(want), len(resp.Results))
+		}
+		for i, status := range want {
+			if resp.Results[i].Status != status {
+				t.Errorf("Message %d: expected %v, got %v", i, status, resp.Results[i].Status)
+			}
+		}
+		if resp.Results[1].Error == "" || resp.Results[1].Id != "" {
+			t.Errorf("Expected rejection reason without id, got %+v", resp.Results[1])
+		}
+
+		for _, i := range []int{0, 4} {
+			select {
+			case msg := <-received:
+				if msg.ID != resp.Results[i].Id {
+					t.Errorf("Expected id %q, got %q", resp.Results[i].Id, msg.ID)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Expected message")
+			}
+		}
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		sp := subpub.NewSubPub(1000)
+		server := NewServer(sp)
+		var count atomic.Int32
+		if _, err := sp.Subscribe("metrics", func(msg interface{}) {
+			count.Add(1)
+		}); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		stream := &mockPublishStream{recv: make(chan *pb.PublishRequest, 500)}
+		stream.ctx = context.Background()
+		for i := 0; i < 500; i++ {
+			stream.recv <- &pb.PublishRequest{Key: "metrics", Data: []byte("sample")}
+		}
+		close(stream.recv)
+		if err := server.PublishStream(stream); err != nil {
+			t.Fatalf("PublishStream failed: %v", err)
+		}
+		if len(stream.resp.GetResults()) != 500 {
+			t.Fatalf("Expected 500 results, got %d", len(stream.resp.GetResults()))
+		}
+		for _, r := range stream.resp.Results {
+			if r.Status != pb.PublishResult_ACCEPTED {
+				t.Fatalf("Expected all messages accepted, got %v", r.Status)
+			}
+		}
+		closeCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		sp.Close(closeCtx)
+		if count.Load() != 500 {
+			t.Errorf("Expected 500 delivered messages, got %d", count.Load())
+		}
+	})
+
+	t.Run("Stream Error", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(10))
+		ctx, cancel := context.WithCancel(context.Background())
+		stream := &mockPublishStream{recv: make(chan *pb.PublishRequest)}
+		stream.ctx = ctx
+		cancel()
+		if err := server.PublishStream(stream); status.Code(err) != codes.Canceled {
+			t.Errorf("Expected Canceled, got %v", err)
+		}
+		if stream.resp != nil {
+			t.Error("Expected no response on a broken stream")
+		}
+	})
+}
+
+type mockPublishStream struct {
+	mockPubSubStream
+	recv chan *pb.PublishRequest
+	resp *pb.PublishBatchResponse
+}
+
+func (m *mockPublishStream) Recv() (*pb.PublishRequest, error) {
+	select {
+	case req, ok := <-m.recv:
+		if !ok {
+			return nil, io.EOF
+		}
+		return req, nil
+	case <-m.ctx.Done():
+		return nil, status.FromContextError(m.ctx.Err()).Err()
+	}
+}
+
+func (m *mockPublishStream) SendAndClose(resp *pb.PublishBatchResponse) error {
+	m.resp = resp
+	return nil
 }
 
 func TestServerSubscribe(t *testing.T) {
@@ -59,7 +315,7 @@ func TestServerSubscribe(t *testing.T) {
 
 		stream := &mockPubSubStream{
 			send: func(event *pb.Event) error {
-				if event.Data != "hello" {
+				if string(event.Data) != "hello" {
 					t.Errorf("Expected data: hello, got %s", event.Data)
 				}
 				return nil
@@ -78,7 +334,7 @@ func TestServerSubscribe(t *testing.T) {
 
 		go func() {
 			time.Sleep(50 * time.Millisecond)
-			server.Publish(context.Background(), &pb.PublishRequest{Key: "test", Data: "hello"})
+			server.Publish(context.Background(), &pb.PublishRequest{Key: "test", Data: []byte("hello")})
 		}()
 
 		wg.Wait()
@@ -102,7 +358,7 @@ func TestServerSubscribe(t *testing.T) {
 			t.Errorf("Subscribe failed: %v", err)
 		}
 
-		server.Publish(context.Background(), &pb.PublishRequest{Key: "test", Data: "hello"})
+		server.Publish(context.Background(), &pb.PublishRequest{Key: "test", Data: []byte("hello")})
 		time.Sleep(50 * time.Millisecond)
 	})
 
@@ -128,6 +384,315 @@ func TestServerSubscribe(t *testing.T) {
 		}
 	})
 
+	t.Run("Client Disconnect Unsubscribes", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		server := NewServer(sp)
+		before := runtime.NumGoroutine()
+
+		for i := 0; i < 2000; i++ {
+			ctx, cancel := context.WithCancel(context.Background())
+			stream := &mockPubSubStream{
+				send: func(event *pb.Event) error { return nil },
+				ctx:  ctx,
+			}
+			done := make(chan error, 1)
+			go func() {
+				done <- server.Subscribe(&pb.SubscribeRequest{Key: "cycle"}, stream)
+			}()
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("Subscribe failed: %v", err)
+			}
+		}
+
+		deadline := time.Now().Add(2 * time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected at most %d goroutines, got %d", before, runtime.NumGoroutine())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("No Send After Disconnect", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		server := NewServer(sp)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var mu sync.Mutex
+		returned := false
+		stream := &mockPubSubStream{
+			send: func(event *pb.Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if returned {
+					t.Error("Send called after Subscribe returned")
+				}
+				return nil
+			},
+			ctx: ctx,
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Subscribe(&pb.SubscribeRequest{Key: "test"}, stream)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		for i := 0; i < 100; i++ {
+			server.Publish(context.Background(), &pb.PublishRequest{Key: "test", Data: []byte("hello")})
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		mu.Lock()
+		returned = true
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+	})
+
+	t.Run("Slow Subscriber Disconnected", func(t *testing.T) {
+		sp := subpub.NewSubPub(1, subpub.WithOverflowPolicy(subpub.Disconnect))
+		server := NewServer(sp)
+		release := make(chan struct{})
+		stream := &mockPubSubStream{
+			send: func(event *pb.Event) error {
+				<-release
+				return nil
+			},
+			ctx: context.Background(),
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Subscribe(&pb.SubscribeRequest{Key: "test"}, stream)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			server.Publish(context.Background(), &pb.PublishRequest{Key: "test", Data: []byte("hello")})
+		}
+		close(release)
+
+		select {
+		case err := <-done:
+			if status.Code(err) != codes.ResourceExhausted {
+				t.Errorf("Expected ResourceExhausted, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Subscribe did not return after disconnect")
+		}
+	})
+
+	t.Run("Wildcard Subscribe", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		server := NewServer(sp)
+		ctx, cancel := context.WithCancel(context.Background())
+		events := make(chan string, 2)
+		stream := &mockPubSubStream{
+			send: func(event *pb.Event) error {
+				events <- string(event.Data)
+				return nil
+			},
+			ctx: ctx,
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Subscribe(&pb.SubscribeRequest{Key: "orders.>"}, stream)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		server.Publish(context.Background(), &pb.PublishRequest{Key: "orders.eu.created", Data: []byte("hello")})
+		server.Publish(context.Background(), &pb.PublishRequest{Key: "users.created", Data: []byte("ignored")})
+
+		select {
+		case data := <-events:
+			if data != "hello" {
+				t.Errorf("Expected data: hello, got %s", data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected event for matching key")
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		if len(events) != 0 {
+			t.Errorf("Expected no event for non-matching key, got %s", <-events)
+		}
+	})
+
+	t.Run("Queue Group Subscribe", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		server := NewServer(sp)
+		ctx, cancel := context.WithCancel(context.Background())
+		var received atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				stream := &mockPubSubStream{
+					send: func(event *pb.Event) error {
+						received.Add(1)
+						return nil
+					},
+					ctx: ctx,
+				}
+				if err := server.Subscribe(&pb.SubscribeRequest{Key: "jobs", QueueGroup: "workers"}, stream); err != nil {
+					t.Errorf("Subscribe failed: %v", err)
+				}
+			}()
+		}
+		time.Sleep(20 * time.Millisecond)
+		for i := 0; i < 10; i++ {
+			server.Publish(context.Background(), &pb.PublishRequest{Key: "jobs", Data: []byte("hello")})
+		}
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		wg.Wait()
+
+		if received.Load() != 10 {
+			t.Errorf("Expected each event to be delivered once to the group, got %d", received.Load())
+		}
+	})
+
+	t.Run("Header Precedes Retained Event", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(100))
+		server.Publish(context.Background(), &pb.PublishRequest{Key: "status", Data: []byte("up"), Retain: true})
+		ctx, cancel := context.WithCancel(context.Background())
+		var mu sync.Mutex
+		var order []string
+		got := make(chan struct{})
+		stream := &mockPubSubStream{
+			ctx: ctx,
+			sendHeader: func(md metadata.MD) error {
+				// Give the delivery goroutine time to overtake the header.
+				time.Sleep(20 * time.Millisecond)
+				mu.Lock()
+				order = append(order, "header "+md.Get(SubscriptionIDHeader)[0])
+				mu.Unlock()
+				return nil
+			},
+			send: func(e *pb.Event) error {
+				mu.Lock()
+				order = append(order, "event")
+				mu.Unlock()
+				close(got)
+				return nil
+			},
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Subscribe(&pb.SubscribeRequest{Key: "status"}, stream)
+		}()
+		select {
+		case <-got:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the retained event")
+		}
+		cancel()
+		<-done
+		if len(order) != 2 || !strings.HasPrefix(order[0], "header ") || order[0] == "header " || order[1] != "event" {
+			t.Errorf("Expected the id header before the event, got %v", order)
+		}
+	})
+
+	t.Run("Failed Header Ends Subscription", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		server := NewServer(sp)
+		server.Publish(context.Background(), &pb.PublishRequest{Key: "status", Data: []byte("up"), Retain: true})
+		headerErr := errors.New("stream broken")
+		stream := &mockPubSubStream{
+			ctx:        context.Background(),
+			sendHeader: func(metadata.MD) error { return headerErr },
+			send: func(e *pb.Event) error {
+				t.Errorf("Unexpected event %v", e)
+				return nil
+			},
+		}
+		if err := server.Subscribe(&pb.SubscribeRequest{Key: "status"}, stream); !errors.Is(err, headerErr) {
+			t.Errorf("Expected the header error, got %v", err)
+		}
+		if n := len(sp.Subscriptions()); n != 0 {
+			t.Errorf("Expected the subscription to be removed, got %d", n)
+		}
+	})
+
+	t.Run("Invalid Key", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(100))
+		stream := &mockPubSubStream{
+			send: func(event *pb.Event) error { return nil },
+			ctx:  context.Background(),
+		}
+		err := server.Subscribe(&pb.SubscribeRequest{Key: "orders.>.eu"}, stream)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument, got %v", err)
+		}
+	})
+
+	t.Run("Replay From Start", func(t *testing.T) {
+		l, err := msglog.Open(t.TempDir())
+		if err != nil {
+			t.Fatalf("Open log failed: %v", err)
+		}
+		defer l.Close()
+		sp := subpub.NewSubPub(100, subpub.WithLog(l))
+		server := NewServer(sp)
+		for _, data := range []string{"a", "b", "c"} {
+			server.Publish(context.Background(), &pb.PublishRequest{Key: "orders", Data: []byte(data)})
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		events := make(chan *pb.Event, 10)
+		stream := &mockPubSubStream{
+			send: func(event *pb.Event) error {
+				events <- event
+				return nil
+			},
+			ctx: ctx,
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Subscribe(&pb.SubscribeRequest{
+				Key:       "orders",
+				StartFrom: &pb.StartFrom{Position: &pb.StartFrom_Sequence{Sequence: 2}},
+			}, stream)
+		}()
+
+		for _, want := range []struct {
+			data string
+			seq  uint64
+		}{{"b", 2}, {"c", 3}} {
+			select {
+			case event := <-events:
+				if string(event.Data) != want.data || event.Sequence != want.seq {
+					t.Errorf("Expected %s/%d, got %s/%d", want.data, want.seq, event.Data, event.Sequence)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Expected replayed event")
+			}
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+	})
+
+	t.Run("Replay Without Log", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(100))
+		stream := &mockPubSubStream{
+			send: func(event *pb.Event) error { return nil },
+			ctx:  context.Background(),
+		}
+		err := server.Subscribe(&pb.SubscribeRequest{
+			Key:       "orders",
+			StartFrom: &pb.StartFrom{Position: &pb.StartFrom_Earliest{Earliest: &emptypb.Empty{}}},
+		}, stream)
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("Expected FailedPrecondition, got %v", err)
+		}
+	})
+
 	t.Run("Concurrent Subscribe and Publish", func(t *testing.T) {
 		sp := subpub.NewSubPub(100)
 		server := NewServer(sp)
@@ -154,7 +719,7 @@ func TestServerSubscribe(t *testing.T) {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				_, err := server.Publish(context.Background(), &pb.PublishRequest{Key: "test", Data: "hello"})
+				_, err := server.Publish(context.Background(), &pb.PublishRequest{Key: "test", Data: []byte("hello")})
 				if err != nil {
 					t.Errorf("Publish failed: %v", err)
 				}
@@ -171,8 +736,9 @@ func TestServerSubscribe(t *testing.T) {
 }
 
 type mockPubSubStream struct {
-	send func(*pb.Event) error
-	ctx  context.Context
+	send       func(*pb.Event) error
+	sendHeader func(metadata.MD) error
+	ctx        context.Context
 }
 
 func (m *mockPubSubStream) Send(event *pb.Event) error {
@@ -187,7 +753,10 @@ func (m *mockPubSubStream) SetHeader(_ metadata.MD) error {
 	return nil
 }
 
-func (m *mockPubSubStream) SendHeader(_ metadata.MD) error {
+func (m *mockPubSubStream) SendHeader(md metadata.MD) error {
+	if m.sendHeader != nil {
+		return m.sendHeader(md)
+	}
 	return nil
 }
 
@@ -201,3 +770,778 @@ func (m *mockPubSubStream) SendMsg(_ interface{}) error {
 func (m *mockPubSubStream) RecvMsg(_ interface{}) error {
 	return nil
 }
+
+func TestServerSubscribeWithAck(t *testing.T) {
+	start := func(t *testing.T, server *Server, key string) (*mockAckStream, context.CancelFunc, chan error) {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		stream := newMockAckStream(ctx)
+		stream.recv <- &pb.AckRequest{Request: &pb.AckRequest_Subscribe{Subscribe: &pb.SubscribeRequest{Key: key}}}
+		done := make(chan error, 1)
+		go func() {
+			done <- server.SubscribeWithAck(stream)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		return stream, cancel, done
+	}
+	next := func(t *testing.T, stream *mockAckStream) *pb.Event {
+		t.Helper()
+		select {
+		case event := <-stream.sent:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("Expected event")
+			return nil
+		}
+	}
+
+	t.Run("Acked Event Is Not Redelivered", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(100), WithAckDeadline(40*time.Millisecond))
+		stream, cancel, done := start(t, server, "orders")
+		defer cancel()
+
+		server.Publish(context.Background(), &pb.PublishRequest{Key: "orders", Data: []byte("hello")})
+		event := next(t, stream)
+		if event.Id == "" || event.DeliveryAttempt != 1 {
+			t.Fatalf("Expected first delivery with id, got %+v", event)
+		}
+		stream.recv <- &pb.AckRequest{Request: &pb.AckRequest_Ack{Ack: &pb.Ack{Ids: []string{event.Id}}}}
+
+		select {
+		case event := <-stream.sent:
+			t.Errorf("Expected no redelivery after ack, got %+v", event)
+		case <-time.After(150 * time.Millisecond):
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("SubscribeWithAck failed: %v", err)
+		}
+	})
+
+	t.Run("Unacked Event Is Redelivered", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(100), WithAckDeadline(40*time.Millisecond))
+		stream, cancel, done := start(t, server, "orders")
+
+		server.Publish(context.Background(), &pb.PublishRequest{Key: "orders", Data: []byte("hello")})
+		first := next(t, stream)
+		second := next(t, stream)
+		if second.Id != first.Id || second.DeliveryAttempt != 2 || string(second.Data) != "hello" {
+			t.Errorf("Expected redelivery of %s with attempt 2, got %+v", first.Id, second)
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("SubscribeWithAck failed: %v", err)
+		}
+	})
+
+	t.Run("Poison Event Is Dead-Lettered", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		server := NewServer(sp, WithAckDeadline(20*time.Millisecond), WithMaxDeliveries(2), WithDeadLetterPrefix("dlq"))
+		dead := make(chan *subpub.Message, 1)
+		if _, err := sp.Subscribe("dlq.>", func(msg interface{}) { dead <- msg.(*subpub.Message) }); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		stream, cancel, done := start(t, server, "orders.*")
+
+		server.Publish(context.Background(), &pb.PublishRequest{Key: "orders.eu", Data: []byte("poison")})
+		first := next(t, stream)
+		next(t, stream)
+
+		select {
+		case m := <-dead:
+			if m.Subject != "dlq.orders.eu" || m.ID != first.Id || string(m.Data) != "poison" {
+				t.Errorf("Unexpected dead letter %+v", m)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected event to be dead-lettered")
+		}
+		select {
+		case event := <-stream.sent:
+			t.Errorf("Expected no delivery past the limit, got %+v", event)
+		case <-time.After(60 * time.Millisecond):
+		}
+		cancel()
+		<-done
+	})
+
+	t.Run("Max In Flight", func(t *testing.T) {
+		sp := subpub.NewSubPub(1)
+		server := NewServer(sp, WithAckDeadline(time.Minute), WithMaxInFlight(2))
+		stream, cancel, done := start(t, server, "orders")
+
+		var results []subpub.PublishStatus
+		for i := 0; i < 5; i++ {
+			res, _ := sp.PublishWithResult("orders", strconv.Itoa(i))
+			results = append(results, res.Status())
+			time.Sleep(10 * time.Millisecond)
+		}
+		first, second := next(t, stream), next(t, stream)
+		select {
+		case event := <-stream.sent:
+			t.Fatalf("Expected no event past the limit, got %+v", event)
+		case <-time.After(50 * time.Millisecond):
+		}
+		// One message waits in the handler and one in the buffer; the last is dropped.
+		if results[4] != subpub.Dropped {
+			t.Errorf("Expected the buffer to overflow, got %v", results)
+		}
+
+		stream.recv <- &pb.AckRequest{Request: &pb.AckRequest_Ack{Ack: &pb.Ack{Ids: []string{first.Id, second.Id}}}}
+		for _, want := range []string{"2", "3"} {
+			if event := next(t, stream); string(event.Data) != want {
+				t.Errorf("Expected %s after the ack, got %+v", want, event)
+			}
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("SubscribeWithAck failed: %v", err)
+		}
+	})
+
+	t.Run("Blocked Handler Does Not Stall Teardown", func(t *testing.T) {
+		sp := subpub.NewSubPub(10)
+		server := NewServer(sp, WithAckDeadline(time.Minute), WithMaxInFlight(1))
+		stream, cancel, done := start(t, server, "orders")
+		sp.Publish("orders", "a")
+		sp.Publish("orders", "b")
+		next(t, stream)
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("SubscribeWithAck did not return")
+		}
+	})
+
+	t.Run("Client Closes Send Side", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(100))
+		stream, cancel, done := start(t, server, "orders")
+		defer cancel()
+		close(stream.recv)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Expected clean end of stream, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("SubscribeWithAck did not return")
+		}
+	})
+
+	t.Run("First Message Must Subscribe", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(100))
+		stream := newMockAckStream(context.Background())
+		stream.recv <- &pb.AckRequest{Request: &pb.AckRequest_Ack{Ack: &pb.Ack{}}}
+		if err := server.SubscribeWithAck(stream); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument, got %v", err)
+		}
+	})
+}
+
+type mockAckStream struct {
+	mockPubSubStream
+	recv chan *pb.AckRequest
+	sent chan *pb.Event
+}
+
+func newMockAckStream(ctx context.Context) *mockAckStream {
+	m := &mockAckStream{
+		recv: make(chan *pb.AckRequest, 10),
+		sent: make(chan *pb.Event, 100),
+	}
+	m.ctx = ctx
+	m.send = func(event *pb.Event) error {
+		m.sent <- event
+		return nil
+	}
+	return m
+}
+
+func (m *mockAckStream) Recv() (*pb.AckRequest, error) {
+	select {
+	case req, ok := <-m.recv:
+		if !ok {
+			return nil, io.EOF
+		}
+		return req, nil
+	case <-m.ctx.Done():
+		return nil, status.FromContextError(m.ctx.Err()).Err()
+	}
+}
+
+func TestServerSession(t *testing.T) {
+	start := func(t *testing.T, server *Server) (*mockSessionStream, chan error) {
+		t.Helper()
+		stream := newMockSessionStream(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Session(stream)
+		}()
+		return stream, done
+	}
+	next := func(t *testing.T, stream *mockSessionStream) *pb.SessionResponse {
+		t.Helper()
+		select {
+		case resp := <-stream.sent:
+			return resp
+		case <-time.After(time.Second):
+			t.Fatal("Expected response")
+			return nil
+		}
+	}
+	subscribe := func(id, key string) *pb.SessionRequest {
+		return &pb.SessionRequest{Id: id, Command: &pb.SessionRequest_Subscribe{Subscribe: &pb.SubscribeRequest{Key: key}}}
+	}
+	publish := func(id, key, data string) *pb.SessionRequest {
+		return &pb.SessionRequest{Id: id, Command: &pb.SessionRequest_Publish{Publish: &pb.PublishRequest{Key: key, Data: []byte(data)}}}
+	}
+
+	t.Run("Multiplexed Subscriptions", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(100))
+		stream, done := start(t, server)
+
+		stream.recv <- subscribe("s1", "orders.*")
+		if resp := next(t, stream); resp.Id != "s1" || resp.GetSubscribed() == nil {
+			t.Fatalf("Expected s1 to be subscribed, got %v", resp)
+		}
+		stream.recv <- subscribe("s2", "alerts")
+		if resp := next(t, stream); resp.Id != "s2" || resp.GetSubscribed() == nil {
+			t.Fatalf("Expected s2 to be subscribed, got %v", resp)
+		}
+
+		stream.recv <- publish("p1", "alerts", "fire")
+		stream.recv <- publish("p2", "orders.new", "order")
+		events := map[string]string{}
+		for i := 0; i < 4; i++ {
+			resp := next(t, stream)
+			switch {
+			case resp.GetEvent() != nil:
+				events[resp.Id] = string(resp.GetEvent().Data)
+			case resp.GetPublished() != nil:
+				if resp.GetPublished().Matched != 1 {
+					t.Errorf("Expected %s to match one subscription, got %v", resp.Id, resp)
+				}
+			default:
+				t.Fatalf("Unexpected response %v", resp)
+			}
+		}
+		if events["s1"] != "order" || events["s2"] != "fire" {
+			t.Errorf("Expected events tagged with their subscription, got %v", events)
+		}
+
+		stream.recv <- &pb.SessionRequest{Id: "s1", Command: &pb.SessionRequest_Unsubscribe{Unsubscribe: &emptypb.Empty{}}}
+		if resp := next(t, stream); resp.Id != "s1" || resp.GetUnsubscribed() == nil {
+			t.Fatalf("Expected s1 to be unsubscribed, got %v", resp)
+		}
+		server.Publish(context.Background(), &pb.PublishRequest{Key: "orders.new"})
+		server.Publish(context.Background(), &pb.PublishRequest{Key: "alerts"})
+		if resp := next(t, stream); resp.Id != "s2" || resp.GetEvent() == nil {
+			t.Errorf("Expected only s2 to receive events, got %v", resp)
+		}
+
+		close(stream.recv)
+		if err := <-done; err != nil {
+			t.Fatalf("Session failed: %v", err)
+		}
+		if got := len(server.subpub.Subscriptions()); got != 0 {
+			t.Errorf("Expected subscriptions to end with the session, got %d", got)
+		}
+	})
+
+	t.Run("Command Errors", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(100))
+		stream, done := start(t, server)
+		stream.recv <- subscribe("s1", "orders")
+		next(t, stream)
+
+		cases := []struct {
+			name string
+			req  *pb.SessionRequest
+			id   string
+			code codes.Code
+		}{
+			{"Duplicate ID", subscribe("s1", "orders"), "s1", codes.AlreadyExists},
+			{"Missing ID", subscribe("", "orders"), "", codes.InvalidArgument},
+			{"Invalid Key", subscribe("s2", "a..b"), "s2", codes.InvalidArgument},
+			{"Unknown Subscription", &pb.SessionRequest{Id: "s9", Command: &pb.SessionRequest_Unsubscribe{Unsubscribe: &emptypb.Empty{}}}, "s9", codes.NotFound},
+			{"Missing Command", &pb.SessionRequest{Id: "x"}, "x", codes.InvalidArgument},
+			{"Invalid Publish", publish("p1", "orders.*", ""), "p1", codes.InvalidArgument},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				stream.recv <- c.req
+				resp := next(t, stream)
+				if resp.Id != c.id || resp.GetError() == nil || codes.Code(resp.GetError().Code) != c.code {
+					t.Errorf("Expected %v for %q, got %v", c.code, c.id, resp)
+				}
+			})
+		}
+
+		close(stream.recv)
+		if err := <-done; err != nil {
+			t.Fatalf("Session failed: %v", err)
+		}
+	})
+
+	t.Run("Client Cancels", func(t *testing.T) {
+		server := NewServer(subpub.NewSubPub(100))
+		ctx, cancel := context.WithCancel(context.Background())
+		stream := newMockSessionStream(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Session(stream)
+		}()
+		stream.recv <- subscribe("s1", "orders")
+		next(t, stream)
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("Expected clean end on cancel, got %v", err)
+		}
+	})
+}
+
+type mockSessionStream struct {
+	mockPubSubStream
+	recv chan *pb.SessionRequest
+	sent chan *pb.SessionResponse
+}
+
+func newMockSessionStream(ctx context.Context) *mockSessionStream {
+	m := &mockSessionStream{
+		recv: make(chan *pb.SessionRequest, 10),
+		sent: make(chan *pb.SessionResponse, 100),
+	}
+	m.ctx = ctx
+	return m
+}
+
+func (m *mockSessionStream) Send(resp *pb.SessionResponse) error {
+	m.sent <- resp
+	return nil
+}
+
+func (m *mockSessionStream) Recv() (*pb.SessionRequest, error) {
+	select {
+	case req, ok := <-m.recv:
+		if !ok {
+			return nil, io.EOF
+		}
+		return req, nil
+	case <-m.ctx.Done():
+		return nil, status.FromContextError(m.ctx.Err()).Err()
+	}
+}
+
+func TestServerRequest(t *testing.T) {
+	sp := subpub.NewSubPub(100)
+	defer sp.Close(context.Background())
+	if _, err := sp.Subscribe("greet", func(msg interface{}) {
+		req := msg.(*subpub.Message)
+		sp.Publish(req.ReplyTo(), &subpub.Message{Data: append([]byte("hello "), req.Data...), ContentType: "text/plain"})
+	}); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	sp.Subscribe("silent", func(msg interface{}) {})
+	server := NewServer(sp, WithRequestTimeout(50*time.Millisecond))
+
+	t.Run("Reply", func(t *testing.T) {
+		event, err := server.Request(context.Background(), &pb.PublishRequest{Key: "greet", Data: []byte("bob")})
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if string(event.Data) != "hello bob" || event.ContentType != "text/plain" {
+			t.Errorf("Unexpected reply %+v", event)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+		short, cancelShort := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancelShort()
+		cases := []struct {
+			name string
+			ctx  context.Context
+			key  string
+			code codes.Code
+		}{
+			{"No Responders", context.Background(), "nobody", codes.Unavailable},
+			{"Invalid Key", context.Background(), "a..b", codes.InvalidArgument},
+			{"Client Deadline", short, "silent", codes.DeadlineExceeded},
+			{"Server Timeout", context.Background(), "silent", codes.DeadlineExceeded},
+			{"Canceled", canceled, "silent", codes.Canceled},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				start := time.Now()
+				_, err := server.Request(c.ctx, &pb.PublishRequest{Key: c.key, Data: []byte("x")})
+				if status.Code(err) != c.code {
+					t.Errorf("Expected %v, got %v", c.code, err)
+				}
+				if time.Since(start) > time.Second {
+					t.Errorf("Request took %v", time.Since(start))
+				}
+			})
+		}
+	})
+}
+
+func TestPayloads(t *testing.T) {
+	t.Run("In-Process Message Types", func(t *testing.T) {
+		cases := []struct {
+			msg         interface{}
+			data        string
+			contentType string
+		}{
+			{"text", "text", contentTypeText},
+			{[]byte{0, 1}, "\x00\x01", contentTypeBinary},
+			{map[string]int{"n": 1}, `{"n":1}`, contentTypeJSON},
+			{&subpub.Message{Data: []byte("raw"), ContentType: "text/csv"}, "raw", "text/csv"},
+		}
+		for _, c := range cases {
+			event, ok := toEvent(c.msg)
+			if !ok {
+				t.Fatalf("Expected %T to be converted", c.msg)
+			}
+			if string(event.Data) != c.data || event.ContentType != c.contentType {
+				t.Errorf("%T: expected %q (%s), got %q (%s)", c.msg, c.data, c.contentType, event.Data, event.ContentType)
+			}
+		}
+
+		event, ok := toEvent(&pb.Ack{Ids: []string{"a"}})
+		if !ok || event.Payload == nil {
+			t.Fatalf("Expected protobuf message to become a typed payload, got %+v", event)
+		}
+		var ack pb.Ack
+		if err := event.Payload.UnmarshalTo(&ack); err != nil || len(ack.Ids) != 1 {
+			t.Errorf("Expected payload to unmarshal back, got %v (%v)", &ack, err)
+		}
+
+		if _, ok := toEvent(make(chan int)); ok {
+			t.Error("Expected unencodable message to be skipped")
+		}
+	})
+
+	t.Run("Unsupported Type Does Not Break Stream", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		server := NewServer(sp)
+		ctx, cancel := context.WithCancel(context.Background())
+		events := make(chan *pb.Event, 2)
+		stream := &mockPubSubStream{
+			send: func(event *pb.Event) error {
+				events <- event
+				return nil
+			},
+			ctx: ctx,
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Subscribe(&pb.SubscribeRequest{Key: "mixed"}, stream)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		sp.Publish("mixed", func() {})
+		sp.Publish("mixed", 42)
+
+		select {
+		case event := <-events:
+			if string(event.Data) != "42" || event.ContentType != contentTypeJSON {
+				t.Errorf("Expected JSON encoded 42, got %q (%s)", event.Data, event.ContentType)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected delivery to continue after an unsupported message")
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+	})
+
+	t.Run("Typed Payload Round Trip", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		server := NewServer(sp)
+		received := make(chan interface{}, 1)
+		sp.Subscribe("typed", func(msg interface{}) { received <- msg })
+
+		payload, _ := anypb.New(&pb.Ack{Ids: []string{"x"}})
+		if _, err := server.Publish(context.Background(), &pb.PublishRequest{Key: "typed", Payload: payload}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		event, _ := toEvent(<-received)
+		if event.Payload == nil || event.Payload.TypeUrl != payload.TypeUrl || len(event.Data) != 0 {
+			t.Errorf("Expected typed payload to be restored, got %+v", event)
+		}
+
+		_, err := server.Publish(context.Background(), &pb.PublishRequest{Key: "typed", Data: []byte("x"), Payload: payload})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument for data and payload, got %v", err)
+		}
+	})
+}
+
+func TestHeaders(t *testing.T) {
+	t.Run("Envelope Flows To Event", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		server := NewServer(sp, WithForwardedMetadata("X-Request-Id", "traceparent"))
+		ctx, cancel := context.WithCancel(context.Background())
+		events := make(chan *pb.Event, 1)
+		stream := &mockPubSubStream{
+			send: func(event *pb.Event) error {
+				events <- event
+				return nil
+			},
+			ctx: ctx,
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Subscribe(&pb.SubscribeRequest{Key: "orders.*"}, stream)
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		pubCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"x-request-id", "req-1",
+			"traceparent", "from-metadata",
+			"authorization", "secret",
+		))
+		req := &pb.PublishRequest{
+			Key:     "orders.eu",
+			Data:    []byte("hello"),
+			Headers: map[string]string{"traceparent": "explicit", "tenant": "acme"},
+		}
+		before := time.Now()
+		if _, err := server.Publish(pubCtx, req); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+
+		var event *pb.Event
+		select {
+		case event = <-events:
+		case <-time.After(time.Second):
+			t.Fatal("Expected event")
+		}
+		want := map[string]string{"x-request-id": "req-1", "traceparent": "explicit", "tenant": "acme"}
+		if len(event.Headers) != len(want) {
+			t.Errorf("Expected headers %v, got %v", want, event.Headers)
+		}
+		for k, v := range want {
+			if event.Headers[k] != v {
+				t.Errorf("Header %s: expected %q, got %q", k, v, event.Headers[k])
+			}
+		}
+		if len(req.Headers) != 2 {
+			t.Errorf("Expected request headers to be left untouched, got %v", req.Headers)
+		}
+		if event.Subject != "orders.eu" || event.Id == "" {
+			t.Errorf("Expected subject and id on event, got %+v", event)
+		}
+		if event.PublishedAt == nil || event.PublishedAt.AsTime().Before(before.Add(-time.Second)) {
+			t.Errorf("Expected publish timestamp, got %v", event.PublishedAt)
+		}
+		cancel()
+		<-done
+	})
+}
+
+func TestAuthorization(t *testing.T) {
+	acl, err := auth.NewACL([]auth.Rule{
+		{Principal: "ingest", Publish: []string{"telemetry.>"}},
+		{Principal: "dashboard", Subscribe: []string{"telemetry.*"}},
+	})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	server := NewServer(subpub.NewSubPub(10), WithACL(acl))
+	ingest := auth.NewContext(context.Background(), &auth.Principal{Name: "ingest"})
+	dashboard := auth.NewContext(context.Background(), &auth.Principal{Name: "dashboard"})
+
+	t.Run("Publish", func(t *testing.T) {
+		if _, err := server.Publish(ingest, &pb.PublishRequest{Key: "telemetry.cpu"}); err != nil {
+			t.Errorf("Expected publish to be allowed, got %v", err)
+		}
+		for _, ctx := range []context.Context{dashboard, context.Background()} {
+			_, err := server.Publish(ctx, &pb.PublishRequest{Key: "telemetry.cpu"})
+			if status.Code(err) != codes.PermissionDenied {
+				t.Errorf("Expected PermissionDenied, got %v", err)
+			}
+		}
+	})
+
+	t.Run("Publish Batch", func(t *testing.T) {
+		resp, _ := server.PublishBatch(ingest, &pb.PublishBatchRequest{Messages: []*pb.PublishRequest{
+			{Key: "telemetry.cpu"},
+			{Key: "alerts"},
+		}})
+		if resp.Results[0].Status == pb.PublishResult_REJECTED || resp.Results[1].Status != pb.PublishResult_REJECTED {
+			t.Errorf("Expected only the second message to be rejected, got %v", resp.Results)
+		}
+	})
+
+	t.Run("Subscribe", func(t *testing.T) {
+		subscribe := func(ctx context.Context, key string) error {
+			ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer cancel()
+			return server.Subscribe(&pb.SubscribeRequest{Key: key}, &mockPubSubStream{ctx: ctx})
+		}
+		if err := subscribe(dashboard, "telemetry.cpu"); err != nil {
+			t.Errorf("Expected subscribe to be allowed, got %v", err)
+		}
+		for _, key := range []string{"telemetry.>", "alerts"} {
+			if err := subscribe(dashboard, key); status.Code(err) != codes.PermissionDenied {
+				t.Errorf("Expected PermissionDenied for %s, got %v", key, err)
+			}
+		}
+		if err := subscribe(ingest, "telemetry.cpu"); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Expected PermissionDenied for publisher, got %v", err)
+		}
+
+		stream := newMockAckStream(ingest)
+		stream.recv <- &pb.AckRequest{Request: &pb.AckRequest_Subscribe{Subscribe: &pb.SubscribeRequest{Key: "telemetry.cpu"}}}
+		if err := server.SubscribeWithAck(stream); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Expected PermissionDenied for acknowledged subscribe, got %v", err)
+		}
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	sp := subpub.NewSubPub(10)
+	defer sp.Close(context.Background())
+	server := NewServer(sp)
+	admin := NewAdminServer(server)
+
+	// subscribe serves a Subscribe stream from addr until it ends.
+	subscribe := func(key, addr string) <-chan error {
+		ctx := context.Background()
+		if addr != "" {
+			ctx = peer.NewContext(ctx, &peer.Peer{Addr: net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr))})
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Subscribe(&pb.SubscribeRequest{Key: key}, &mockPubSubStream{
+				ctx:  ctx,
+				send: func(*pb.Event) error { return nil },
+			})
+		}()
+		return done
+	}
+	waitSubscriptions := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for len(sp.Subscriptions()) != n && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := len(sp.Subscriptions()); got != n {
+			t.Fatalf("Expected %d subscriptions, got %d", n, got)
+		}
+	}
+	ended := func(done <-chan error) error {
+		t.Helper()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(time.Second):
+			t.Fatal("Subscribe stream did not end")
+			return nil
+		}
+	}
+
+	orders := subscribe("orders.eu", "10.0.0.1:5000")
+	all := subscribe("orders.*", "")
+	alerts := subscribe("alerts", "")
+	waitSubscriptions(3)
+
+	t.Run("List Subjects", func(t *testing.T) {
+		resp, err := admin.ListSubjects(context.Background(), &pb.ListSubjectsRequest{})
+		if err != nil {
+			t.Fatalf("ListSubjects failed: %v", err)
+		}
+		var subjects []string
+		for _, s := range resp.Subjects {
+			subjects = append(subjects, s.Subject)
+			if s.Subscriptions != 1 {
+				t.Errorf("Expected one subscription to %s, got %d", s.Subject, s.Subscriptions)
+			}
+		}
+		if want := []string{"alerts", "orders.*", "orders.eu"}; !slices.Equal(subjects, want) {
+			t.Errorf("Expected subjects %v, got %v", want, subjects)
+		}
+	})
+
+	t.Run("List Subscriptions", func(t *testing.T) {
+		resp, err := admin.ListSubscriptions(context.Background(), &pb.ListSubscriptionsRequest{Subject: "orders.>"})
+		if err != nil {
+			t.Fatalf("ListSubscriptions failed: %v", err)
+		}
+		if len(resp.Subscriptions) != 2 {
+			t.Fatalf("Expected the two orders subscriptions, got %v", resp.Subscriptions)
+		}
+		eu := resp.Subscriptions[1]
+		if eu.Subject != "orders.eu" || eu.Id == "" || eu.Peer != "10.0.0.1:5000" || eu.Capacity != 10 {
+			t.Errorf("Unexpected subscription %+v", eu)
+		}
+		if _, err := admin.ListSubscriptions(context.Background(), &pb.ListSubscriptionsRequest{Subject: "a..b"}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument, got %v", err)
+		}
+	})
+
+	t.Run("Disconnect Subscription", func(t *testing.T) {
+		resp, _ := admin.ListSubscriptions(context.Background(), &pb.ListSubscriptionsRequest{Subject: "alerts"})
+		if _, err := admin.DisconnectSubscription(context.Background(), &pb.DisconnectSubscriptionRequest{Id: resp.Subscriptions[0].Id}); err != nil {
+			t.Fatalf("DisconnectSubscription failed: %v", err)
+		}
+		if err := ended(alerts); status.Code(err) != codes.Aborted {
+			t.Errorf("Expected the stream to end with Aborted, got %v", err)
+		}
+		_, err := admin.DisconnectSubscription(context.Background(), &pb.DisconnectSubscriptionRequest{Id: resp.Subscriptions[0].Id})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("Expected NotFound, got %v", err)
+		}
+	})
+
+	t.Run("Drain Subject", func(t *testing.T) {
+		resp, err := admin.DrainSubject(context.Background(), &pb.DrainSubjectRequest{Subject: "orders.>"})
+		if err != nil {
+			t.Fatalf("DrainSubject failed: %v", err)
+		}
+		if resp.Drained != 2 {
+			t.Errorf("Expected 2 drained subscriptions, got %d", resp.Drained)
+		}
+		for _, done := range []<-chan error{orders, all} {
+			if err := ended(done); status.Code(err) != codes.Aborted {
+				t.Errorf("Expected the stream to end with Aborted, got %v", err)
+			}
+		}
+		if _, err := admin.DrainSubject(context.Background(), &pb.DrainSubjectRequest{Subject: "a..b"}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument, got %v", err)
+		}
+	})
+
+	t.Run("Authorization", func(t *testing.T) {
+		acl, err := auth.NewACL([]auth.Rule{{Principal: "operator", Admin: []string{"orders.>"}}})
+		if err != nil {
+			t.Fatalf("NewACL failed: %v", err)
+		}
+		admin := NewAdminServer(NewServer(sp, WithACL(acl)))
+		operator := auth.NewContext(context.Background(), &auth.Principal{Name: "operator"})
+		alerts := subscribe("alerts", "")
+		defer func() {
+			sp.Drain(context.Background(), ">")
+			ended(alerts)
+		}()
+		waitSubscriptions(1)
+
+		if _, err := admin.ListSubjects(operator, &pb.ListSubjectsRequest{}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Expected PermissionDenied listing every subject, got %v", err)
+		}
+		if _, err := admin.ListSubscriptions(operator, &pb.ListSubscriptionsRequest{Subject: "orders.>"}); err != nil {
+			t.Errorf("Expected listing orders to be allowed, got %v", err)
+		}
+		if _, err := admin.DrainSubject(operator, &pb.DrainSubjectRequest{Subject: "alerts"}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Expected PermissionDenied draining alerts, got %v", err)
+		}
+		// Subscriptions the operator may not manage look like unknown ones.
+		_, err = admin.DisconnectSubscription(operator, &pb.DisconnectSubscriptionRequest{Id: sp.Subscriptions()[0].ID})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("Expected NotFound, got %v", err)
+		}
+	})
+}
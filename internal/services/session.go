@@ -0,0 +1,141 @@
+package services
+
+import (
+	"asyn-subpub-service/pb/proto/api"
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"io"
+	"log"
+	"sync"
+)
+
+// Session serves subscribe, unsubscribe and publish commands on one stream. Each
+// subscription is delivered like a Subscribe stream of its own: events are sent by
+// the delivery goroutine of the subscription, so a slow client fills the buffers
+// of its subscriptions and their overflow policy applies.
+func (s *Server) Session(stream pb.PubSub_SessionServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	sess := &session{
+		server: s,
+		stream: stream,
+		ctx:    ctx,
+		subs:   make(map[string]context.CancelFunc),
+	}
+	// Every subscription is torn down before the handler returns.
+	defer sess.wg.Wait()
+	defer cancel()
+
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			// Recv also fails when the client goes away, which is not an error of the handler.
+			if errors.Is(err, io.EOF) || stream.Context().Err() != nil {
+				return nil
+			}
+			return err
+		}
+		switch {
+		case req.GetSubscribe() != nil:
+			sess.subscribe(req.Id, req.GetSubscribe())
+		case req.GetUnsubscribe() != nil:
+			sess.unsubscribe(req.Id)
+		case req.GetPublish() != nil:
+			sess.publish(req.Id, req.GetPublish())
+		default:
+			sess.sendError(req.Id, status.Error(codes.InvalidArgument, "missing command"))
+		}
+	}
+}
+
+type session struct {
+	server *Server
+	stream pb.PubSub_SessionServer
+	ctx    context.Context
+	sendMu sync.Mutex
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	subs map[string]context.CancelFunc
+}
+
+func (s *session) subscribe(id string, req *pb.SubscribeRequest) {
+	if id == "" {
+		s.sendError(id, status.Error(codes.InvalidArgument, "subscribe command requires an id"))
+		return
+	}
+	s.mu.Lock()
+	if _, ok := s.subs[id]; ok {
+		s.mu.Unlock()
+		s.sendError(id, status.Errorf(codes.AlreadyExists, "subscription %q already exists", id))
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.subs[id] = cancel
+	s.mu.Unlock()
+
+	ready := func() {
+		s.send(&pb.SessionResponse{Id: id, Response: &pb.SessionResponse_Subscribed{Subscribed: &emptypb.Empty{}}})
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		err := s.server.StreamEvents(ctx, req, ready, func(event *pb.Event) error {
+			return s.send(&pb.SessionResponse{Id: id, Response: &pb.SessionResponse_Event{Event: event}})
+		})
+		s.mu.Lock()
+		delete(s.subs, id)
+		s.mu.Unlock()
+		cancel()
+		if s.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.sendError(id, err)
+			return
+		}
+		s.send(&pb.SessionResponse{Id: id, Response: &pb.SessionResponse_Unsubscribed{Unsubscribed: &emptypb.Empty{}}})
+	}()
+}
+
+// unsubscribe ends the subscription; the unsubscribed response follows once no
+// more events will be sent for it.
+func (s *session) unsubscribe(id string) {
+	s.mu.Lock()
+	cancel, ok := s.subs[id]
+	s.mu.Unlock()
+	if !ok {
+		s.sendError(id, status.Errorf(codes.NotFound, "subscription %q not found", id))
+		return
+	}
+	cancel()
+}
+
+func (s *session) publish(id string, req *pb.PublishRequest) {
+	resp, err := s.server.Publish(s.ctx, req)
+	if err != nil {
+		s.sendError(id, err)
+		return
+	}
+	s.send(&pb.SessionResponse{Id: id, Response: &pb.SessionResponse_Published{Published: resp}})
+}
+
+func (s *session) sendError(id string, err error) {
+	st := status.Convert(err)
+	s.send(&pb.SessionResponse{Id: id, Response: &pb.SessionResponse_Error{Error: &pb.SessionError{
+		Code:    uint32(st.Code()),
+		Message: st.Message(),
+	}}})
+}
+
+func (s *session) send(resp *pb.SessionResponse) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	err := s.stream.Send(resp)
+	if err != nil && s.ctx.Err() == nil {
+		log.Printf("Error sending session response: %v", err)
+	}
+	return err
+}
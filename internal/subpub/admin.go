@@ -0,0 +1,62 @@
+package subpub
+
+import (
+	ps "asyn-subpub-service/pkg/subpub"
+	"context"
+)
+
+// Disconnect tears down the subscription with the given id like Unsubscribe,
+// discarding its buffered messages. Its Err reports ErrDisconnected.
+func (sp *subPub) Disconnect(id string) error {
+	sub := sp.find(func(s *subscription) bool { return s.id == id })
+	if len(sub) == 0 {
+		return ErrSubscriptionNotFound
+	}
+	sub[0].unsubscribe(ErrDisconnected)
+	return nil
+}
+
+// Drain stops routing messages to every subscription whose subject is covered by
+// pattern and lets them deliver what they have buffered; their Err reports
+// ErrDrained. It returns how many subscriptions were drained once they are all
+// done, or early with the context error when ctx is done first.
+func (sp *subPub) Drain(ctx context.Context, pattern string) (int, error) {
+	if _, err := ps.Tokenize(pattern, true); err != nil {
+		return 0, err
+	}
+	subs := sp.find(func(s *subscription) bool { return Covers(pattern, s.subject) })
+	for _, s := range subs {
+		s.drain()
+	}
+	for _, s := range subs {
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			return len(subs), ctx.Err()
+		}
+	}
+	return len(subs), nil
+}
+
+func (sp *subPub) find(match func(*subscription) bool) []*subscription {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	var found []*subscription
+	for _, s := range sp.subs.all(nil) {
+		if match(s) {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+// drain ends the subscription without discarding its buffer: the delivery
+// goroutine hands the remaining messages to the handler before it is done.
+func (s *subscription) drain() {
+	if !s.end(ErrDrained, false) {
+		return
+	}
+	s.subpub.lockRegistry()
+	defer s.subpub.unlockRegistry()
+	s.subpub.remove(s)
+}
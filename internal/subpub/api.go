@@ -0,0 +1,72 @@
+package subpub
+
+import ps "asyn-subpub-service/pkg/subpub"
+
+// The API shared with the client of the PubSub service is defined in pkg/subpub
+// and re-exported here, so that the broker and its callers name it in one place.
+
+type (
+	SubPub            = ps.SubPub
+	Subscription      = ps.Subscription
+	MessageHandler    = ps.MessageHandler
+	Stats             = ps.Stats
+	PublishStatus     = ps.PublishStatus
+	PublishResult     = ps.PublishResult
+	BatchMessage      = ps.BatchMessage
+	BatchResult       = ps.BatchResult
+	SubscriptionInfo  = ps.SubscriptionInfo
+	Message           = ps.Message
+	Position          = ps.Position
+	OverflowPolicy    = ps.OverflowPolicy
+	SubscribeOption   = ps.SubscribeOption
+	SubscribeSettings = ps.SubscribeSettings
+)
+
+const (
+	Accepted      = ps.Accepted
+	Dropped       = ps.Dropped
+	NoSubscribers = ps.NoSubscribers
+
+	DropNewest = ps.DropNewest
+	DropOldest = ps.DropOldest
+	Block      = ps.Block
+	Disconnect = ps.Disconnect
+
+	DefaultBlockTimeout = ps.DefaultBlockTimeout
+	ReplyToHeader       = ps.ReplyToHeader
+	InboxPrefix         = ps.InboxPrefix
+)
+
+var (
+	ErrInvalidSubject       = ps.ErrInvalidSubject
+	ErrInvalidQueueGroup    = ps.ErrInvalidQueueGroup
+	ErrSlowConsumer         = ps.ErrSlowConsumer
+	ErrSubscriptionNotFound = ps.ErrSubscriptionNotFound
+	ErrDisconnected         = ps.ErrDisconnected
+	ErrDrained              = ps.ErrDrained
+	ErrNoLog                = ps.ErrNoLog
+	ErrReplayWildcard       = ps.ErrReplayWildcard
+	ErrNotPersistable       = ps.ErrNotPersistable
+	ErrNoResponders         = ps.ErrNoResponders
+	ErrInvalidRequest       = ps.ErrInvalidRequest
+
+	Latest   = ps.Latest
+	Earliest = ps.Earliest
+)
+
+var (
+	NewID                   = ps.NewID
+	IsInbox                 = ps.IsInbox
+	IsLiteral               = ps.IsLiteral
+	Match                   = ps.Match
+	Covers                  = ps.Covers
+	AtSequence              = ps.AtSequence
+	AtTime                  = ps.AtTime
+	ParseOverflowPolicy     = ps.ParseOverflowPolicy
+	ResolveSubscribeOptions = ps.ResolveSubscribeOptions
+	OnOverflow              = ps.OnOverflow
+	BlockTimeout            = ps.BlockTimeout
+	BufferSize              = ps.BufferSize
+	StartAt                 = ps.StartAt
+	Peer                    = ps.Peer
+)
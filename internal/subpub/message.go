@@ -0,0 +1,163 @@
+package subpub
+
+import (
+	"asyn-subpub-service/internal/msglog"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var errReplayStopped = errors.New("subpub: replay stopped")
+
+// WithLog persists every published message to l, enabling replay with StartAt.
+func WithLog(l *msglog.Log) Option {
+	return func(sp *subPub) {
+		sp.log = l
+	}
+}
+
+// stamp prepares msg for delivery, persisting it when a log is configured. Replies
+// to requests are not persisted, since every request has an inbox of its own.
+// Callers must hold the stripe of the subject.
+func (sp *subPub) stamp(subject string, msg interface{}) (interface{}, error) {
+	m, isMessage := msg.(*Message)
+	now := time.Now()
+	if isMessage {
+		c := *m
+		c.Subject = subject
+		if c.ID == "" {
+			c.ID = NewID()
+		}
+		if c.Time.IsZero() {
+			c.Time = now
+		}
+		if c.TTL == 0 {
+			c.TTL = sp.defaultTTL(subject)
+		}
+		m = &c
+		msg = m
+	}
+	if sp.log == nil || IsInbox(subject) {
+		return msg, nil
+	}
+
+	var record *Message
+	switch v := msg.(type) {
+	case *Message:
+		record = v
+		now = v.Time
+	case []byte:
+		record = &Message{ID: NewID(), Data: v}
+	case string:
+		record = &Message{ID: NewID(), Data: []byte(v)}
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrNotPersistable, msg)
+	}
+	seq, err := sp.log.Append(subject, now, encodeMessage(record))
+	if err != nil {
+		return nil, err
+	}
+	if isMessage {
+		m.Seq = seq
+	}
+	return msg, nil
+}
+
+// replay delivers the logged messages of the subscription up to head.
+func (sp *subPub) replay(sub *subscription, pos Position, head uint64) {
+	from := uint64(1)
+	if seq, ok := pos.Sequence(); ok {
+		from = seq
+	}
+	since, bySince := pos.Time()
+	err := sp.log.Read(sub.subject, from, head, func(r msglog.Record) error {
+		if sub.stopped.Load() {
+			return errReplayStopped
+		}
+		if bySince && r.Time.Before(since) {
+			return nil
+		}
+		m := decodeMessage(r.Data)
+		m.Subject = sub.subject
+		m.Seq = r.Seq
+		m.Time = r.Time
+		sub.handle(m)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errReplayStopped) {
+		log.Printf("subpub: replay of %q failed: %v", sub.subject, err)
+	}
+}
+
+// Persisted messages are encoded as a format byte followed by tagged fields,
+// each a tag byte, a uvarint length and the field bytes. Unknown tags are skipped
+// so fields can be added without breaking existing logs.
+const (
+	encodingTagged byte = 1
+
+	tagData        byte = 1
+	tagID          byte = 2
+	tagContentType byte = 3
+	tagHeader      byte = 4
+)
+
+func encodeMessage(m *Message) []byte {
+	buf := []byte{encodingTagged}
+	buf = appendField(buf, tagID, []byte(m.ID))
+	buf = appendField(buf, tagData, m.Data)
+	if m.ContentType != "" {
+		buf = appendField(buf, tagContentType, []byte(m.ContentType))
+	}
+	for k, v := range m.Headers {
+		header := binary.AppendUvarint(nil, uint64(len(k)))
+		header = append(header, k...)
+		header = append(header, v...)
+		buf = appendField(buf, tagHeader, header)
+	}
+	return buf
+}
+
+func appendField(buf []byte, tag byte, value []byte) []byte {
+	buf = append(buf, tag)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// decodeMessage restores the fields stored by encodeMessage. Records that are
+// not in the tagged format are returned as raw data.
+func decodeMessage(b []byte) *Message {
+	if len(b) == 0 || b[0] != encodingTagged {
+		return &Message{Data: b}
+	}
+	m := &Message{}
+	rest := b[1:]
+	for len(rest) > 0 {
+		tag := rest[0]
+		n, k := binary.Uvarint(rest[1:])
+		if k <= 0 || uint64(len(rest)-1-k) < n {
+			return &Message{Data: b}
+		}
+		value := rest[1+k : 1+k+int(n)]
+		rest = rest[1+k+int(n):]
+		switch tag {
+		case tagData:
+			m.Data = value
+		case tagID:
+			m.ID = string(value)
+		case tagContentType:
+			m.ContentType = string(value)
+		case tagHeader:
+			n, k := binary.Uvarint(value)
+			if k <= 0 || uint64(len(value)-k) < n {
+				continue
+			}
+			if m.Headers == nil {
+				m.Headers = make(map[string]string)
+			}
+			m.Headers[string(value[k:k+int(n)])] = string(value[k+int(n):])
+		}
+	}
+	return m
+}
@@ -0,0 +1,39 @@
+package subpub
+
+import "time"
+
+// Observer is notified of message flow through the sub-pub system, e.g. to export
+// metrics. Methods are called synchronously on the publish and delivery paths and
+// must not block.
+type Observer interface {
+	// Published is called once for every message published to a literal subject.
+	Published(subject string, r PublishResult)
+	// Delivered is called after the handler of a subscription on the subject
+	// pattern returned, with the time the handler took.
+	Delivered(subject string, d time.Duration)
+	// Dropped is called when a subscription on the subject pattern discards a
+	// message because its buffer is full.
+	Dropped(subject string)
+	// Evicted is called when the DropOldest policy discards a buffered message.
+	Evicted(subject string)
+	// Expired is called when a subscription on the subject pattern discards a
+	// message whose TTL passed before it reached the handler.
+	Expired(subject string)
+}
+
+type nopObserver struct{}
+
+func (nopObserver) Published(string, PublishResult) {}
+func (nopObserver) Delivered(string, time.Duration) {}
+func (nopObserver) Dropped(string)                  {}
+func (nopObserver) Evicted(string)                  {}
+func (nopObserver) Expired(string)                  {}
+
+// WithObserver reports message flow to o.
+func WithObserver(o Observer) Option {
+	return func(sp *subPub) {
+		if o != nil {
+			sp.observer = o
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package subpub
+
+import (
+	"fmt"
+	"time"
+)
+
+// QueueStrategy decides which member of a queue group receives a message.
+type QueueStrategy int
+
+const (
+	// RoundRobin hands messages to the group members in turn.
+	RoundRobin QueueStrategy = iota
+	// LeastLoaded hands each message to the member with the fewest buffered messages.
+	LeastLoaded
+)
+
+var queueStrategyNames = map[QueueStrategy]string{
+	RoundRobin:  "round_robin",
+	LeastLoaded: "least_loaded",
+}
+
+func (q QueueStrategy) String() string {
+	if name, ok := queueStrategyNames[q]; ok {
+		return name
+	}
+	return fmt.Sprintf("QueueStrategy(%d)", int(q))
+}
+
+// ParseQueueStrategy converts a config value such as "least_loaded" to a QueueStrategy.
+func ParseQueueStrategy(s string) (QueueStrategy, error) {
+	for q, name := range queueStrategyNames {
+		if name == s {
+			return q, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown queue strategy %q", s)
+}
+
+// Option configures a SubPub created by NewSubPub.
+type Option func(*subPub)
+
+// WithOverflowPolicy sets the policy used by subscriptions that do not choose their own.
+func WithOverflowPolicy(p OverflowPolicy) Option {
+	return func(sp *subPub) {
+		sp.defaults.Policy = p
+	}
+}
+
+// WithBlockTimeout sets the default time a publisher waits on a full buffer under the Block policy.
+func WithBlockTimeout(d time.Duration) Option {
+	return func(sp *subPub) {
+		sp.defaults.BlockTimeout = d
+	}
+}
+
+// WithQueueStrategy sets how queue group members are selected.
+func WithQueueStrategy(q QueueStrategy) Option {
+	return func(sp *subPub) {
+		sp.queueStrategy = q
+	}
+}
+
+// ttlRule is a default TTL for the subjects matched by a pattern.
+type ttlRule struct {
+	pattern string
+	ttl     time.Duration
+}
+
+// WithDefaultTTL sets the TTL of messages published to subjects matched by
+// pattern without a TTL of their own. Rules are tried in the order they were
+// added and the first matching one applies.
+func WithDefaultTTL(pattern string, ttl time.Duration) Option {
+	return func(sp *subPub) {
+		sp.ttls = append(sp.ttls, ttlRule{pattern: pattern, ttl: ttl})
+	}
+}
+
+// defaultTTL returns the TTL of the first rule matching subject, 0 if none does.
+func (sp *subPub) defaultTTL(subject string) time.Duration {
+	for _, r := range sp.ttls {
+		if Match(r.pattern, subject) {
+			return r.ttl
+		}
+	}
+	return 0
+}
+
+// OnClose registers fn to run once when Close begins, after new subscriptions are
+// refused and before existing ones are torn down.
+func OnClose(fn func()) Option {
+	return func(sp *subPub) {
+		if fn != nil {
+			sp.closeHooks = append(sp.closeHooks, fn)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package subpub
+
+import (
+	"context"
+	"fmt"
+)
+
+// Request publishes msg with a reply subject in its ReplyToHeader header and waits
+// for the first message published to that subject. Responders reply by publishing
+// to the ReplyTo of the request. It fails with ErrNoResponders when nothing is
+// subscribed to the subject and with the context error when ctx is done first.
+func (sp *subPub) Request(ctx context.Context, subject string, msg interface{}) (interface{}, error) {
+	var m Message
+	switch v := msg.(type) {
+	case *Message:
+		m = *v
+	case string:
+		m.Data = []byte(v)
+	case []byte:
+		m.Data = v
+	default:
+		return nil, fmt.Errorf("%w, got %T", ErrInvalidRequest, msg)
+	}
+
+	inbox := InboxPrefix + NewID()
+	replies := make(chan interface{}, 1)
+	sub, err := sp.Subscribe(inbox, func(reply interface{}) {
+		select {
+		case replies <- reply:
+		default:
+		}
+	}, BufferSize(1))
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	// Copy so the headers of the caller's message are left untouched.
+	headers := make(map[string]string, len(m.Headers)+1)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers[ReplyToHeader] = inbox
+	m.Headers = headers
+	res, err := sp.PublishWithResult(subject, &m)
+	if err != nil {
+		return nil, err
+	}
+	if res.Matched == 0 {
+		return nil, ErrNoResponders
+	}
+
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,147 @@
+package subpub
+
+import (
+	ps "asyn-subpub-service/pkg/subpub"
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+)
+
+// retainedStore keeps the last retained message of every subject, evicting the
+// least recently retained subjects once the total size exceeds the limit. It has
+// a lock of its own since publishers of every stripe retain messages.
+type retainedStore struct {
+	mu    sync.Mutex
+	limit int64
+	size  int64
+	// order holds the retained messages, most recently retained last.
+	order    *list.List
+	subjects map[string]*list.Element
+}
+
+func newRetainedStore(limit int64) *retainedStore {
+	return &retainedStore{
+		limit:    limit,
+		order:    list.New(),
+		subjects: make(map[string]*list.Element),
+	}
+}
+
+// WithRetainedLimit caps the memory, in bytes, used by retained messages. When a
+// new retained message exceeds the cap, the subjects retained longest ago are
+// forgotten first. A zero or negative limit means no cap.
+func WithRetainedLimit(bytes int64) Option {
+	return func(sp *subPub) {
+		sp.retained.limit = bytes
+	}
+}
+
+// put retains m as the last value of its subject.
+func (r *retainedStore) put(m *Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.delete(m.Subject)
+	size := retainedSize(m)
+	if r.limit > 0 && size > r.limit {
+		return
+	}
+	r.subjects[m.Subject] = r.order.PushBack(m)
+	r.size += size
+	for r.limit > 0 && r.size > r.limit {
+		r.delete(r.order.Front().Value.(*Message).Subject)
+	}
+}
+
+// delete forgets the message of subject. Callers must hold r.mu.
+func (r *retainedStore) delete(subject string) bool {
+	e, ok := r.subjects[subject]
+	if !ok {
+		return false
+	}
+	r.order.Remove(e)
+	delete(r.subjects, subject)
+	r.size -= retainedSize(e.Value.(*Message))
+	return true
+}
+
+// match returns the retained messages whose subject matches pattern, ordered by
+// subject. Expired messages are forgotten instead.
+func (r *retainedStore) match(pattern string) []*Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.matchLocked(pattern)
+}
+
+func (r *retainedStore) matchLocked(pattern string) []*Message {
+	now := time.Now()
+	var msgs []*Message
+	if IsLiteral(pattern) {
+		if e, ok := r.subjects[pattern]; ok {
+			msgs = append(msgs, e.Value.(*Message))
+		}
+	} else {
+		for subject, e := range r.subjects {
+			if Match(pattern, subject) {
+				msgs = append(msgs, e.Value.(*Message))
+			}
+		}
+	}
+	live := msgs[:0]
+	for _, m := range msgs {
+		if m.Expired(now) {
+			r.delete(m.Subject)
+			continue
+		}
+		live = append(live, m)
+	}
+	msgs = live
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Subject < msgs[j].Subject })
+	return msgs
+}
+
+// retainedSize approximates the memory held by a retained message.
+func retainedSize(m *Message) int64 {
+	n := len(m.ID) + len(m.Subject) + len(m.Data) + len(m.ContentType)
+	for k, v := range m.Headers {
+		n += len(k) + len(v)
+	}
+	return int64(n)
+}
+
+// ClearRetained forgets the retained messages of every subject matched by pattern
+// and returns how many were cleared.
+func (sp *subPub) ClearRetained(pattern string) (int, error) {
+	if _, err := ps.Tokenize(pattern, true); err != nil {
+		return 0, err
+	}
+	return sp.retained.clear(pattern), nil
+}
+
+// clear forgets the messages of every subject matched by pattern.
+func (r *retainedStore) clear(pattern string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, m := range r.matchLocked(pattern) {
+		if r.delete(m.Subject) {
+			n++
+		}
+	}
+	return n
+}
+
+// retain stores a copy of a stamped message that asked to be retained; the copy
+// is marked as retained for the subscribers it is delivered to later. Callers
+// must hold the stripe of the subject.
+func (sp *subPub) retain(msg interface{}) interface{} {
+	m, ok := msg.(*Message)
+	if !ok || !m.Retain {
+		return msg
+	}
+	stored := *m
+	sp.retained.put(&stored)
+	live := *m
+	live.Retain = false
+	return &live
+}
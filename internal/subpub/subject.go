@@ -0,0 +1,145 @@
+package subpub
+
+import ps "asyn-subpub-service/pkg/subpub"
+
+// sublist is a subject trie holding the subscriptions of every pattern.
+// Matching a published subject visits only the branches that can match it.
+type sublist struct {
+	root *node
+}
+
+type node struct {
+	children map[string]*node
+	subs     []*subscription
+	queues   map[string][]*subscription
+}
+
+// matchResult holds the plain subscriptions and the queue group members matching a subject.
+type matchResult struct {
+	subs   []*subscription
+	queues map[queueKey][]*subscription
+}
+
+func newSublist() *sublist {
+	return &sublist{root: &node{}}
+}
+
+func (n *node) empty() bool {
+	return len(n.children) == 0 && len(n.subs) == 0 && len(n.queues) == 0
+}
+
+func (l *sublist) empty() bool {
+	return l.root.empty()
+}
+
+func (l *sublist) insert(sub *subscription) {
+	n := l.root
+	for _, tok := range sub.tokens {
+		child, ok := n.children[tok]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[string]*node)
+			}
+			child = &node{}
+			n.children[tok] = child
+		}
+		n = child
+	}
+	if sub.queue == "" {
+		n.subs = append(n.subs, sub)
+		return
+	}
+	if n.queues == nil {
+		n.queues = make(map[string][]*subscription)
+	}
+	n.queues[sub.queue] = append(n.queues[sub.queue], sub)
+}
+
+// remove deletes the subscription and prunes the branches left empty.
+func (l *sublist) remove(sub *subscription) {
+	removeFrom(l.root, sub.tokens, sub)
+}
+
+func removeFrom(n *node, tokens []string, sub *subscription) {
+	if len(tokens) == 0 {
+		if sub.queue == "" {
+			n.subs = without(n.subs, sub)
+			return
+		}
+		members := without(n.queues[sub.queue], sub)
+		if len(members) == 0 {
+			delete(n.queues, sub.queue)
+			return
+		}
+		n.queues[sub.queue] = members
+		return
+	}
+	child, ok := n.children[tokens[0]]
+	if !ok {
+		return
+	}
+	removeFrom(child, tokens[1:], sub)
+	if child.empty() {
+		delete(n.children, tokens[0])
+	}
+}
+
+// without returns a copy of subs lacking sub, leaving the original slice untouched.
+func without(subs []*subscription, sub *subscription) []*subscription {
+	for i, s := range subs {
+		if s == sub {
+			return append(subs[:i:i], subs[i+1:]...)
+		}
+	}
+	return subs
+}
+
+// match collects into r every subscription whose pattern matches the literal tokens.
+// Queue group members are gathered by pattern and group name, so that groups of
+// the same name on different patterns stay apart.
+func (l *sublist) match(tokens []string, r *matchResult) {
+	matchFrom(l.root, tokens, r)
+}
+
+func matchFrom(n *node, tokens []string, r *matchResult) {
+	if len(tokens) == 0 {
+		r.add(n)
+		return
+	}
+	if tail, ok := n.children[ps.TailWildcard]; ok {
+		r.add(tail)
+	}
+	if child, ok := n.children[tokens[0]]; ok {
+		matchFrom(child, tokens[1:], r)
+	}
+	if child, ok := n.children[ps.SingleWildcard]; ok {
+		matchFrom(child, tokens[1:], r)
+	}
+}
+
+func (r *matchResult) add(n *node) {
+	r.subs = append(r.subs, n.subs...)
+	for name, members := range n.queues {
+		if r.queues == nil {
+			r.queues = make(map[queueKey][]*subscription)
+		}
+		// Every member of a node shares its pattern.
+		r.queues[queueKey{members[0].subject, name}] = members
+	}
+}
+
+// all appends every subscription in the trie to dst.
+func (l *sublist) all(dst []*subscription) []*subscription {
+	return allFrom(l.root, dst)
+}
+
+func allFrom(n *node, dst []*subscription) []*subscription {
+	dst = append(dst, n.subs...)
+	for _, members := range n.queues {
+		dst = append(dst, members...)
+	}
+	for _, child := range n.children {
+		dst = allFrom(child, dst)
+	}
+	return dst
+}
@@ -1,113 +1,571 @@
 package subpub
 
 import (
+	"asyn-subpub-service/internal/msglog"
+	ps "asyn-subpub-service/pkg/subpub"
+	"cmp"
 	"context"
 	"errors"
-	"log"
+	"hash/maphash"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// MessageHandler is a callback function that process massages delivered to subscribers.
-type MessageHandler func(msg interface{})
+// subscription buffers messages in ch, which is never closed so that a publisher
+// cannot send on a closed channel: the end of the subscription is signalled by
+// closing stop instead.
+//
+// Publishers enqueue in the order their messages were routed. Routing hands each
+// message a ticket from next while holding the stripe of its subject, and deliver
+// waits for serving to reach its ticket, so every subscriber sees the messages of
+// a subject in the order they were published (and logged), and the messages of a
+// single publisher in the order of its calls.
+type subscription struct {
+	id           string
+	serial       uint64 // orders deliveries of a message across subscriptions
+	peer         string
+	subject      string
+	tokens       []string
+	queue        string
+	ch           chan interface{}
+	stop         chan struct{}
+	cb           MessageHandler
+	subpub       *subPub
+	policy       OverflowPolicy
+	blockTimeout time.Duration
+	next         atomic.Uint64
+	mu           sync.Mutex
+	turn         *sync.Cond // signalled on mu when serving, closed or sending change
+	serving      uint64
+	sending      bool // a Block publisher waits for room without holding mu
+	closed       bool
+	err          error
+	stopped      atomic.Bool
+	done         chan struct{}
 
-type Subscription interface {
-	// Unsubscribe will remove interest in the current subject subscription is for.
-	Unsubscribe()
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	evicted   atomic.Uint64
+	expired   atomic.Uint64
 }
 
-type SubPub interface {
-	// Subscribe creates an asynchronous queue subscribers on the given subject.
-	Subscribe(subject string, cb MessageHandler) (Subscription, error)
+// registryStripes is the number of locks publishing is spread over by subject.
+const registryStripes = 64
 
-	// Publish publishes the msg argument to the give subject.
-	Publish(subject string, msg interface{}) error
+// subPub keeps the registry, subs and queues, behind lock stripes. Publishing a
+// subject holds only the stripes the subject hashes to, so publishers of different
+// subjects rarely contend, while the messages of one subject are stamped and
+// routed one at a time. The registry changes only while holding mu and every
+// stripe, so reading it requires either mu or any single stripe.
+//
+// Stamping, which appends to the log and may wait for the disk, is serialized by
+// a separate set of stamping stripes taken before the registry stripe, so that
+// changing the registry never waits for the log.
+type subPub struct {
+	mu            sync.Mutex
+	stripes       [registryStripes]stripe
+	stamping      [registryStripes]stripe
+	seed          maphash.Seed
+	serial        uint64
+	subs          *sublist
+	queues        map[queueKey]*queueState
+	queueStrategy QueueStrategy
+	log           *msglog.Log
+	retained      *retainedStore
+	ttls          []ttlRule
+	observer      Observer
+	closeHooks    []func()
+	closed        bool
+	wg            sync.WaitGroup
+	defaults      SubscribeSettings
+}
 
-	// Close will shutdown the sub-pub system.
-	// May be blocked by data deliver until the context is canceled.
-	Close(ctx context.Context) error
+// stripe is padded to a cache line so that publishers holding neighbouring
+// stripes do not slow each other down.
+type stripe struct {
+	sync.Mutex
+	_ [64 - 8]byte
 }
 
-type subscription struct {
-	ch     chan interface{}
-	cb     MessageHandler
-	subpub *subPub
-	closed bool
-	mu     sync.Mutex
+// queueKey identifies a queue group: groups of the same name subscribed to
+// different subject patterns are distinct.
+type queueKey struct {
+	subject string
+	group   string
 }
 
-type subPub struct {
-	mu         sync.Mutex
-	subs       map[string][]*subscription
-	closed     bool
-	subject    string
-	wg         sync.WaitGroup
-	bufferSize int
+// queueState tracks the members of a queue group and its round-robin cursor,
+// which publishers of different stripes advance concurrently.
+type queueState struct {
+	members int
+	next    atomic.Uint64
 }
 
-func NewSubPub(bufferSize int) SubPub {
-	return &subPub{
-		subs:       make(map[string][]*subscription),
-		bufferSize: bufferSize,
+func NewSubPub(bufferSize int, opts ...Option) SubPub {
+	sp := &subPub{
+		subs:     newSublist(),
+		queues:   make(map[queueKey]*queueState),
+		seed:     maphash.MakeSeed(),
+		retained: newRetainedStore(0),
+		observer: nopObserver{},
+		defaults: SubscribeSettings{
+			BufferSize:   bufferSize,
+			Policy:       DropNewest,
+			BlockTimeout: DefaultBlockTimeout,
+		},
 	}
+	for _, opt := range opts {
+		opt(sp)
+	}
+	return sp
 }
 
 func (s *subscription) Unsubscribe() {
+	s.unsubscribe(nil)
+}
+
+func (s *subscription) unsubscribe(reason error) {
+	if !s.end(reason, true) {
+		return
+	}
+	s.subpub.lockRegistry()
+	defer s.subpub.unlockRegistry()
+	s.subpub.remove(s)
+}
+
+// end stops publishers from enqueueing to the subscription and reports whether it
+// was still open. With discard the delivery goroutine drops the buffered messages,
+// otherwise it hands them to the handler first.
+func (s *subscription) end(reason error, discard bool) bool {
 	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.closed {
+		return false
+	}
+	s.closed = true
+	s.err = reason
+	if discard {
+		s.stopped.Store(true)
+	}
+	close(s.stop)
+	s.turn.Broadcast()
+	return true
+}
+
+func (s *subscription) ID() string {
+	return s.id
+}
+
+func (s *subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *subscription) Stats() Stats {
+	return Stats{
+		Delivered: s.delivered.Load(),
+		Dropped:   s.dropped.Load(),
+		Evicted:   s.evicted.Load(),
+		Expired:   s.expired.Load(),
+	}
+}
+
+// outcome is the result of handing a message to a single subscription.
+type outcome int
+
+const (
+	enqueued outcome = iota
+	dropped
+	// disconnected means the message was dropped and the subscription has to be
+	// torn down as a slow consumer.
+	disconnected
+)
+
+// deliver enqueues msg according to the overflow policy of the subscription once
+// every message routed to it before has been enqueued; ticket was taken when msg
+// was routed.
+func (s *subscription) deliver(msg interface{}, ticket uint64) outcome {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for !s.closed && s.serving != ticket {
+		s.turn.Wait()
+	}
+	if s.closed {
+		return dropped
+	}
+	out := s.enqueue(msg)
+	s.serving++
+	s.turn.Broadcast()
+	return out
+}
+
+// enqueue applies the overflow policy. Callers must hold s.mu and the turn.
+func (s *subscription) enqueue(msg interface{}) outcome {
+	select {
+	case s.ch <- msg:
+		return enqueued
+	default:
+	}
+
+	switch s.policy {
+	case DropOldest:
+		select {
+		case <-s.ch:
+			s.evicted.Add(1)
+			s.subpub.observer.Evicted(s.subject)
+		default:
+		}
+		select {
+		case s.ch <- msg:
+			return enqueued
+		default:
+		}
+	case Block:
+		// Waiting without mu lets the subscription end meanwhile; the turn keeps
+		// other publishers out.
+		s.sending = true
 		s.mu.Unlock()
+		timer := time.NewTimer(s.blockTimeout)
+		var sent bool
+		select {
+		case s.ch <- msg:
+			sent = true
+		case <-timer.C:
+		case <-s.stop:
+		}
+		timer.Stop()
+		s.mu.Lock()
+		s.sending = false
+		if sent {
+			return enqueued
+		}
+	case Disconnect:
+		s.drop()
+		return disconnected
+	}
+	s.drop()
+	return dropped
+}
+
+func (s *subscription) drop() {
+	s.dropped.Add(1)
+	s.subpub.observer.Dropped(s.subject)
+}
+
+// handle passes msg to the handler and records the delivery, unless msg expired.
+func (s *subscription) handle(msg interface{}) {
+	start := time.Now()
+	if m, ok := msg.(*Message); ok && m.Expired(start) {
+		s.expired.Add(1)
+		s.subpub.observer.Expired(s.subject)
 		return
 	}
-	s.closed = true
-	s.mu.Unlock()
+	s.cb(msg)
+	s.delivered.Add(1)
+	s.subpub.observer.Delivered(s.subject, time.Since(start))
+}
 
-	s.subpub.mu.Lock()
-	defer s.subpub.mu.Unlock()
-	for i, sub := range s.subpub.subs[s.subpub.subject] {
-		if sub == s {
-			s.subpub.subs[s.subpub.subject] = append(s.subpub.subs[s.subpub.subject][:i], s.subpub.subs[s.subpub.subject][i+1:]...)
-			break
+// run hands the buffered messages to the handler until the subscription ends.
+func (s *subscription) run() {
+	for {
+		select {
+		case msg := <-s.ch:
+			if s.stopped.Load() {
+				return
+			}
+			s.handle(msg)
+		case <-s.stop:
+			s.flush()
+			return
 		}
 	}
-	close(s.ch)
 }
 
-func (sp *subPub) Subscribe(subject string, cb MessageHandler) (Subscription, error) {
+// flush delivers what is left in the buffer of an ended subscription, unless it
+// is discarded, including a message a Block publisher is still sending.
+func (s *subscription) flush() {
+	s.mu.Lock()
+	for s.sending {
+		s.turn.Wait()
+	}
+	s.mu.Unlock()
+	for !s.stopped.Load() {
+		select {
+		case msg := <-s.ch:
+			s.handle(msg)
+		default:
+			return
+		}
+	}
+}
+
+// lockRegistry excludes publishers of every subject, for changing the registry.
+func (sp *subPub) lockRegistry() {
 	sp.mu.Lock()
-	defer sp.mu.Unlock()
+	for i := range sp.stripes {
+		sp.stripes[i].Lock()
+	}
+}
+
+func (sp *subPub) unlockRegistry() {
+	for i := range sp.stripes {
+		sp.stripes[i].Unlock()
+	}
+	sp.mu.Unlock()
+}
+
+// stripeOf returns the index of the stripes serializing the publishing of subject.
+func (sp *subPub) stripeOf(subject string) uint64 {
+	return maphash.String(sp.seed, subject) % registryStripes
+}
+
+// remove detaches the subscription from its subject. Callers must hold the registry lock.
+func (sp *subPub) remove(s *subscription) {
+	sp.subs.remove(s)
+	if s.queue == "" {
+		return
+	}
+	key := queueKey{s.subject, s.queue}
+	if q, ok := sp.queues[key]; ok {
+		q.members--
+		if q.members <= 0 {
+			delete(sp.queues, key)
+		}
+	}
+}
+
+// pick selects the queue group member that receives the next message. Callers
+// must hold a stripe.
+func (sp *subPub) pick(group queueKey, members []*subscription) *subscription {
+	start := int((sp.queues[group].next.Add(1) - 1) % uint64(len(members)))
+	if sp.queueStrategy != LeastLoaded {
+		return members[start]
+	}
+	best := members[start]
+	for i := 1; i < len(members); i++ {
+		m := members[(start+i)%len(members)]
+		if len(m.ch) < len(best.ch) {
+			best = m
+		}
+	}
+	return best
+}
+
+func (sp *subPub) Subscribe(subject string, cb MessageHandler, opts ...SubscribeOption) (Subscription, error) {
+	return sp.subscribe(subject, "", cb, opts)
+}
+
+func (sp *subPub) SubscribeQueue(subject, group string, cb MessageHandler, opts ...SubscribeOption) (Subscription, error) {
+	if group == "" {
+		return nil, ErrInvalidQueueGroup
+	}
+	return sp.subscribe(subject, group, cb, opts)
+}
+
+func (sp *subPub) subscribe(subject, group string, cb MessageHandler, opts []SubscribeOption) (Subscription, error) {
+	tokens, err := ps.Tokenize(subject, true)
+	if err != nil {
+		return nil, err
+	}
+	o := sp.defaults.Apply(opts...)
+	if o.BlockTimeout <= 0 {
+		o.BlockTimeout = DefaultBlockTimeout
+	}
+
+	replay := o.Start != Latest
+	if replay {
+		if sp.log == nil {
+			return nil, ErrNoLog
+		}
+		if !IsLiteral(subject) {
+			return nil, ErrReplayWildcard
+		}
+	}
+
+	if replay {
+		// A message logged but not yet routed would be both replayed and
+		// delivered live, so none may be in flight while the head is read.
+		st := &sp.stamping[sp.stripeOf(subject)]
+		st.Lock()
+		defer st.Unlock()
+	}
+	sp.lockRegistry()
+	defer sp.unlockRegistry()
 	if sp.closed {
 		return nil, errors.New("subpub is closed")
 	}
+	var head uint64
+	var retained []*Message
+	if replay {
+		head = sp.log.LastSeq(subject)
+	} else if group == "" {
+		// Queue groups share the stream of live messages and get no retained values.
+		retained = sp.retained.match(subject)
+	}
 	sub := &subscription{
-		ch:     make(chan interface{}, sp.bufferSize),
-		cb:     cb,
-		subpub: sp,
+		id:           NewID(),
+		serial:       sp.serial,
+		peer:         o.Peer,
+		subject:      subject,
+		tokens:       tokens,
+		queue:        group,
+		ch:           make(chan interface{}, o.BufferSize),
+		stop:         make(chan struct{}),
+		cb:           cb,
+		subpub:       sp,
+		policy:       o.Policy,
+		blockTimeout: o.BlockTimeout,
+		done:         make(chan struct{}),
+	}
+	sp.serial++
+	sub.turn = sync.NewCond(&sub.mu)
+	sp.subs.insert(sub)
+	if group != "" {
+		key := queueKey{subject, group}
+		q, ok := sp.queues[key]
+		if !ok {
+			q = &queueState{}
+			sp.queues[key] = q
+		}
+		q.members++
 	}
-	sp.subs[subject] = append(sp.subs[subject], sub)
 	sp.wg.Add(1)
 	go func() {
 		defer sp.wg.Done()
-		for msg := range sub.ch {
-			cb(msg)
+		defer close(sub.done)
+		if replay {
+			sp.replay(sub, o.Start, head)
+		}
+		for _, m := range retained {
+			if sub.stopped.Load() {
+				break
+			}
+			sub.handle(m)
 		}
+		sub.run()
 	}()
 	return sub, nil
 }
 
 func (sp *subPub) Publish(subject string, msg interface{}) error {
-	sp.mu.Lock()
-	subs, ok := sp.subs[subject]
-	sp.mu.Unlock()
-	if !ok {
-		return nil
+	_, err := sp.PublishWithResult(subject, msg)
+	return err
+}
+
+func (sp *subPub) PublishWithResult(subject string, msg interface{}) (PublishResult, error) {
+	r := sp.PublishBatch([]BatchMessage{{Subject: subject, Msg: msg}})[0]
+	return r.PublishResult, r.Err
+}
+
+// routed is a stamped message together with the subscriptions it goes to.
+type routed struct {
+	index   int
+	msg     interface{}
+	targets []target
+}
+
+// target is a subscription a message was routed to and the ticket of the message
+// in its delivery order.
+type target struct {
+	sub    *subscription
+	ticket uint64
+}
+
+func (sp *subPub) PublishBatch(msgs []BatchMessage) []BatchResult {
+	results := make([]BatchResult, len(msgs))
+	tokens := make([][]string, len(msgs))
+	for i, m := range msgs {
+		tokens[i], results[i].Err = ps.Tokenize(m.Subject, false)
 	}
-	for _, sub := range subs {
-		select {
-		case sub.ch <- msg:
+
+	batch := make([]routed, 0, len(msgs))
+	for i, m := range msgs {
+		if results[i].Err != nil {
+			continue
+		}
+		r, err := sp.publish(m.Subject, tokens[i], m.Msg)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		r.index = i
+		batch = append(batch, r)
+	}
+
+	for _, r := range batch {
+		results[r.index].PublishResult = dispatch(r.msg, r.targets)
+		sp.observer.Published(msgs[r.index].Subject, results[r.index].PublishResult)
+	}
+	return results
+}
+
+// publish stamps a message while holding the stamping stripe of its subject, then
+// retains and routes it while also holding the registry stripe, so that tickets
+// follow the order the messages of a subject are stamped in.
+func (sp *subPub) publish(subject string, tokens []string, msg interface{}) (routed, error) {
+	i := sp.stripeOf(subject)
+	sp.stamping[i].Lock()
+	defer sp.stamping[i].Unlock()
+	msg, err := sp.stamp(subject, msg)
+	if err != nil {
+		return routed{}, err
+	}
+	sp.stripes[i].Lock()
+	defer sp.stripes[i].Unlock()
+	msg = sp.retain(msg)
+	return routed{msg: msg, targets: sp.route(tokens)}, nil
+}
+
+// route returns the plain subscriptions and the chosen queue group members for a
+// subject, each with the ticket of the message. Callers must hold the stripe of
+// the subject.
+func (sp *subPub) route(tokens []string) []target {
+	var m matchResult
+	sp.subs.match(tokens, &m)
+	subs := m.subs
+	for group, members := range m.queues {
+		subs = append(subs, sp.pick(group, members))
+	}
+	// Publishers of different subjects take tickets in no common order, so they
+	// deliver in the order of the subscriptions: a publisher waiting for its turn
+	// then only waits for publishers that already delivered to every subscription
+	// before, which rules out deadlocks.
+	if len(subs) > 1 {
+		slices.SortFunc(subs, func(a, b *subscription) int {
+			return cmp.Compare(a.serial, b.serial)
+		})
+	}
+	targets := make([]target, len(subs))
+	for i, sub := range subs {
+		targets[i] = target{sub: sub, ticket: sub.next.Add(1) - 1}
+	}
+	return targets
+}
+
+// dispatch hands msg to every subscription and counts which of them took it.
+// Every ticket must be passed to deliver, or later messages would wait for it.
+func dispatch(msg interface{}, targets []target) PublishResult {
+	r := PublishResult{Matched: len(targets)}
+	for _, t := range targets {
+		sub := t.sub
+		switch sub.deliver(msg, t.ticket) {
+		case enqueued:
+			r.Enqueued++
+		case disconnected:
+			sub.unsubscribe(ErrSlowConsumer)
+			fallthrough
 		default:
-			log.Println("subpub buffer is full")
+			r.Dropped++
 		}
 	}
-	return nil
+	return r
 }
 
 func (sp *subPub) Close(ctx context.Context) error {
@@ -117,19 +575,19 @@ func (sp *subPub) Close(ctx context.Context) error {
 		return nil
 	}
 	sp.closed = true
+	sp.mu.Unlock()
 
-	for subject, subs := range sp.subs {
-		for _, sub := range subs {
-			sub.mu.Lock()
-			if !sub.closed {
-				sub.closed = true
-				close(sub.ch)
-			}
-			sub.mu.Unlock()
-		}
-		delete(sp.subs, subject)
+	for _, hook := range sp.closeHooks {
+		hook()
 	}
-	sp.mu.Unlock()
+
+	sp.lockRegistry()
+	for _, sub := range sp.subs.all(nil) {
+		sub.end(nil, false)
+	}
+	sp.subs = newSublist()
+	sp.queues = make(map[queueKey]*queueState)
+	sp.unlockRegistry()
 
 	done := make(chan struct{})
 	go func() {
@@ -1,9 +1,18 @@
 package subpub
 
 import (
+	"asyn-subpub-service/internal/msglog"
 	"context"
 	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -11,8 +20,11 @@ import (
 func TestSubPub(t *testing.T) {
 	t.Run("Basic Subscribe and Publish", func(t *testing.T) {
 		sp := NewSubPub(100)
+		var mu sync.Mutex
 		var received []interface{}
 		handler := func(msg interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
 			received = append(received, msg)
 		}
 
@@ -30,9 +42,11 @@ func TestSubPub(t *testing.T) {
 
 		time.Sleep(100 * time.Millisecond)
 
+		mu.Lock()
 		if len(received) != 2 || received[0] != "msg1" || received[1] != "msg2" {
 			t.Errorf("Expected [msg1, msg2], got %v", received)
 		}
+		mu.Unlock()
 
 		sub.Unsubscribe()
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
@@ -56,13 +70,46 @@ func TestSubPub(t *testing.T) {
 		}
 	})
 
+	t.Run("Close Hook", func(t *testing.T) {
+		var sp SubPub
+		var calls int
+		var subscribeErr error
+		sp = NewSubPub(100, OnClose(func() {
+			calls++
+			_, subscribeErr = sp.Subscribe("test", func(msg interface{}) {})
+		}))
+		sub, err := sp.Subscribe("test", func(msg interface{}) {})
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		closeSubPub(t, sp)
+		closeSubPub(t, sp)
+
+		if calls != 1 {
+			t.Errorf("Expected hook to run once, ran %d times", calls)
+		}
+		if subscribeErr == nil {
+			t.Error("Expected subscriptions to be refused once Close began")
+		}
+		select {
+		case <-sub.Done():
+		default:
+			t.Error("Expected subscription to be torn down after the hook")
+		}
+	})
+
 	t.Run("Multiple Subscribers", func(t *testing.T) {
 		sp := NewSubPub(100)
+		var mu sync.Mutex
 		var received1, received2 []interface{}
 		handler1 := func(msg interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
 			received1 = append(received1, msg)
 		}
 		handler2 := func(msg interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
 			received2 = append(received2, msg)
 		}
 
@@ -81,6 +128,8 @@ func TestSubPub(t *testing.T) {
 
 		time.Sleep(100 * time.Millisecond)
 
+		mu.Lock()
+		defer mu.Unlock()
 		if len(received1) != 1 || received1[0] != "msg" {
 			t.Errorf("Expected [msg] for subscriber 1, got %v", received1)
 		}
@@ -101,9 +150,9 @@ func TestSubPub(t *testing.T) {
 
 	t.Run("Full Buffer", func(t *testing.T) {
 		sp := NewSubPub(100)
-		var received []interface{}
+		var received atomic.Int32
 		handler := func(msg interface{}) {
-			received = append(received, msg)
+			received.Add(1)
 			time.Sleep(100 * time.Millisecond)
 		}
 
@@ -117,8 +166,168 @@ func TestSubPub(t *testing.T) {
 		}
 
 		time.Sleep(200 * time.Millisecond)
-		if len(received) > 100 {
-			t.Errorf("Expected at most 100 messages, got %d", len(received))
+		if n := received.Load(); n > 100 {
+			t.Errorf("Expected at most 100 messages, got %d", n)
+		}
+	})
+
+	t.Run("Overflow Drop Newest", func(t *testing.T) {
+		sp := NewSubPub(2)
+		release := make(chan struct{})
+		var mu sync.Mutex
+		var received []interface{}
+		sub, err := sp.Subscribe("test", func(msg interface{}) {
+			<-release
+			mu.Lock()
+			received = append(received, msg)
+			mu.Unlock()
+		}, OnOverflow(DropNewest))
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		sp.Publish("test", 0)
+		time.Sleep(10 * time.Millisecond)
+		for i := 1; i <= 4; i++ {
+			sp.Publish("test", i)
+		}
+		close(release)
+		closeSubPub(t, sp)
+
+		if stats := sub.Stats(); stats.Dropped != 2 || stats.Evicted != 0 {
+			t.Errorf("Expected 2 dropped and 0 evicted, got %+v", stats)
+		}
+		if want := []interface{}{0, 1, 2}; !equalMessages(received, want) {
+			t.Errorf("Expected %v, got %v", want, received)
+		}
+	})
+
+	t.Run("Overflow Drop Oldest", func(t *testing.T) {
+		sp := NewSubPub(2)
+		release := make(chan struct{})
+		var mu sync.Mutex
+		var received []interface{}
+		sub, err := sp.Subscribe("test", func(msg interface{}) {
+			<-release
+			mu.Lock()
+			received = append(received, msg)
+			mu.Unlock()
+		}, OnOverflow(DropOldest))
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		sp.Publish("test", 0)
+		time.Sleep(10 * time.Millisecond)
+		for i := 1; i <= 4; i++ {
+			sp.Publish("test", i)
+		}
+		close(release)
+		closeSubPub(t, sp)
+
+		if stats := sub.Stats(); stats.Evicted != 2 || stats.Dropped != 0 {
+			t.Errorf("Expected 2 evicted and 0 dropped, got %+v", stats)
+		}
+		if want := []interface{}{0, 3, 4}; !equalMessages(received, want) {
+			t.Errorf("Expected %v, got %v", want, received)
+		}
+	})
+
+	t.Run("Overflow Block With Timeout", func(t *testing.T) {
+		sp := NewSubPub(1)
+		release := make(chan struct{})
+		sub, err := sp.Subscribe("test", func(msg interface{}) {
+			<-release
+		}, OnOverflow(Block), BlockTimeout(50*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		sp.Publish("test", 0)
+		time.Sleep(10 * time.Millisecond)
+		sp.Publish("test", 1)
+
+		start := time.Now()
+		sp.Publish("test", 2)
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("Expected publisher to block for the timeout, returned after %v", elapsed)
+		}
+		if stats := sub.Stats(); stats.Dropped != 1 {
+			t.Errorf("Expected 1 dropped after timeout, got %+v", stats)
+		}
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			release <- struct{}{}
+		}()
+		sp.Publish("test", 3)
+		if stats := sub.Stats(); stats.Dropped != 1 {
+			t.Errorf("Expected blocked publish to succeed once space freed, got %+v", stats)
+		}
+		close(release)
+		closeSubPub(t, sp)
+	})
+
+	t.Run("Overflow Disconnect", func(t *testing.T) {
+		sp := NewSubPub(1).(*subPub)
+		release := make(chan struct{})
+		sub, err := sp.Subscribe("test", func(msg interface{}) {
+			<-release
+		}, OnOverflow(Disconnect))
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		sp.Publish("test", 0)
+		time.Sleep(10 * time.Millisecond)
+		sp.Publish("test", 1)
+		sp.Publish("test", 2)
+		close(release)
+
+		select {
+		case <-sub.Done():
+		case <-time.After(time.Second):
+			t.Fatal("Expected slow subscriber to be disconnected")
+		}
+		if !errors.Is(sub.Err(), ErrSlowConsumer) {
+			t.Errorf("Expected ErrSlowConsumer, got %v", sub.Err())
+		}
+		if !sp.subs.empty() {
+			t.Error("Expected disconnected subscriber to be removed")
+		}
+	})
+
+	t.Run("Default Overflow Policy", func(t *testing.T) {
+		sp := NewSubPub(1, WithOverflowPolicy(DropOldest))
+		release := make(chan struct{})
+		sub, err := sp.Subscribe("test", func(msg interface{}) {
+			<-release
+		})
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		sp.Publish("test", 0)
+		time.Sleep(10 * time.Millisecond)
+		sp.Publish("test", 1)
+		sp.Publish("test", 2)
+		close(release)
+		closeSubPub(t, sp)
+
+		if stats := sub.Stats(); stats.Evicted != 1 {
+			t.Errorf("Expected 1 evicted under default policy, got %+v", stats)
+		}
+	})
+
+	t.Run("Parse Overflow Policy", func(t *testing.T) {
+		for _, p := range []OverflowPolicy{DropNewest, DropOldest, Block, Disconnect} {
+			got, err := ParseOverflowPolicy(p.String())
+			if err != nil || got != p {
+				t.Errorf("Expected %v, got %v (err %v)", p, got, err)
+			}
+		}
+		if _, err := ParseOverflowPolicy("bogus"); err == nil {
+			t.Error("Expected error for unknown policy")
 		}
 	})
 
@@ -133,6 +342,416 @@ func TestSubPub(t *testing.T) {
 		sub.Unsubscribe()
 	})
 
+	t.Run("Unsubscribe Removes Subject", func(t *testing.T) {
+		sp := NewSubPub(100).(*subPub)
+		sub1, err := sp.Subscribe("a", func(msg interface{}) {})
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		sub2, err := sp.Subscribe("b", func(msg interface{}) {})
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		sub1.Unsubscribe()
+		<-sub1.Done()
+		if _, ok := sp.subs.root.children["a"]; ok {
+			t.Error("Expected subject a to be removed")
+		}
+		var m matchResult
+		sp.subs.match([]string{"b"}, &m)
+		if len(m.subs) != 1 {
+			t.Errorf("Expected subject b to keep its subscriber, got %d", len(m.subs))
+		}
+
+		sub2.Unsubscribe()
+		<-sub2.Done()
+		if !sp.subs.empty() {
+			t.Error("Expected no subjects")
+		}
+	})
+
+	t.Run("No Delivery After Unsubscribe", func(t *testing.T) {
+		sp := NewSubPub(100)
+		release := make(chan struct{})
+		var mu sync.Mutex
+		var received []interface{}
+		sub, err := sp.Subscribe("test", func(msg interface{}) {
+			<-release
+			mu.Lock()
+			received = append(received, msg)
+			mu.Unlock()
+		})
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		for i := 0; i < 5; i++ {
+			sp.Publish("test", i)
+		}
+		time.Sleep(10 * time.Millisecond)
+		sub.Unsubscribe()
+		close(release)
+		<-sub.Done()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(received) > 1 {
+			t.Errorf("Expected at most the in-flight message after Unsubscribe, got %v", received)
+		}
+	})
+
+	t.Run("Subscribe Unsubscribe Cycles Do Not Leak", func(t *testing.T) {
+		sp := NewSubPub(10).(*subPub)
+		before := runtime.NumGoroutine()
+
+		for i := 0; i < 5000; i++ {
+			sub, err := sp.Subscribe("cycle", func(msg interface{}) {})
+			if err != nil {
+				t.Fatalf("Subscribe failed: %v", err)
+			}
+			sp.Publish("cycle", i)
+			sub.Unsubscribe()
+			<-sub.Done()
+		}
+
+		sp.mu.Lock()
+		empty := sp.subs.empty()
+		sp.mu.Unlock()
+		if !empty {
+			t.Error("Expected no subjects after cycles")
+		}
+		waitForGoroutines(t, before)
+	})
+
+	t.Run("Wildcard Subscriptions", func(t *testing.T) {
+		sp := NewSubPub(100)
+		var mu sync.Mutex
+		received := make(map[string][]interface{})
+		subscribe := func(pattern string) {
+			_, err := sp.Subscribe(pattern, func(msg interface{}) {
+				mu.Lock()
+				received[pattern] = append(received[pattern], msg)
+				mu.Unlock()
+			})
+			if err != nil {
+				t.Fatalf("Subscribe %q failed: %v", pattern, err)
+			}
+		}
+		for _, pattern := range []string{"orders.*.created", "orders.>", "orders.eu.created", "*", ">"} {
+			subscribe(pattern)
+		}
+
+		for _, subject := range []string{"orders.eu.created", "orders.us.deleted", "orders", "users.eu.created"} {
+			if err := sp.Publish(subject, subject); err != nil {
+				t.Fatalf("Publish %q failed: %v", subject, err)
+			}
+		}
+		closeSubPub(t, sp)
+
+		want := map[string][]interface{}{
+			"orders.*.created":  {"orders.eu.created"},
+			"orders.>":          {"orders.eu.created", "orders.us.deleted"},
+			"orders.eu.created": {"orders.eu.created"},
+			"*":                 {"orders"},
+			">":                 {"orders.eu.created", "orders.us.deleted", "orders", "users.eu.created"},
+		}
+		for pattern, msgs := range want {
+			if !equalMessages(received[pattern], msgs) {
+				t.Errorf("Pattern %q: expected %v, got %v", pattern, msgs, received[pattern])
+			}
+		}
+	})
+
+	t.Run("Invalid Subjects", func(t *testing.T) {
+		sp := NewSubPub(100)
+		for _, subject := range []string{"", ".", "a..b", "a.", "a.>.b", ">.a"} {
+			if _, err := sp.Subscribe(subject, func(msg interface{}) {}); !errors.Is(err, ErrInvalidSubject) {
+				t.Errorf("Subscribe %q: expected ErrInvalidSubject, got %v", subject, err)
+			}
+		}
+		for _, subject := range []string{"", "a.*", "a.>", "*"} {
+			if err := sp.Publish(subject, "msg"); !errors.Is(err, ErrInvalidSubject) {
+				t.Errorf("Publish %q: expected ErrInvalidSubject, got %v", subject, err)
+			}
+		}
+	})
+
+	t.Run("Match", func(t *testing.T) {
+		cases := []struct {
+			pattern, subject string
+			want             bool
+		}{
+			{"a.b", "a.b", true},
+			{"a.*", "a.b", true},
+			{"a.*", "a.b.c", false},
+			{"a.>", "a.b.c", true},
+			{"a.>", "a", false},
+			{"*.b.*", "a.b.c", true},
+			{"a.b", "a.c", false},
+		}
+		for _, c := range cases {
+			if got := Match(c.pattern, c.subject); got != c.want {
+				t.Errorf("Match(%q, %q) = %v, want %v", c.pattern, c.subject, got, c.want)
+			}
+		}
+	})
+
+	t.Run("Covers", func(t *testing.T) {
+		cases := []struct {
+			pattern, sub string
+			want         bool
+		}{
+			{"a.b", "a.b", true},
+			{"a.b", "a.*", false},
+			{"a.*", "a.*", true},
+			{"a.*", "a.>", false},
+			{"a.>", "a.*.c", true},
+			{"a.>", "a.>", true},
+			{"a.>", "a", false},
+			{">", "*", true},
+			{"*.b", "a.*", false},
+			{"a.*", "a.b.c", false},
+		}
+		for _, c := range cases {
+			if got := Covers(c.pattern, c.sub); got != c.want {
+				t.Errorf("Covers(%q, %q) = %v, want %v", c.pattern, c.sub, got, c.want)
+			}
+		}
+	})
+
+	t.Run("Wildcard Unsubscribe Prunes Trie", func(t *testing.T) {
+		sp := NewSubPub(100).(*subPub)
+		sub1, _ := sp.Subscribe("a.*.c", func(msg interface{}) {})
+		sub2, _ := sp.Subscribe("a.>", func(msg interface{}) {})
+		sub1.Unsubscribe()
+		sub2.Unsubscribe()
+		if !sp.subs.empty() {
+			t.Error("Expected trie to be empty after unsubscribing all patterns")
+		}
+	})
+
+	t.Run("Queue Group Round Robin", func(t *testing.T) {
+		sp := NewSubPub(100)
+		var mu sync.Mutex
+		counts := make(map[string]int)
+		for _, name := range []string{"w1", "w2", "w3"} {
+			name := name
+			if _, err := sp.SubscribeQueue("jobs.>", "workers", func(msg interface{}) {
+				mu.Lock()
+				counts[name]++
+				mu.Unlock()
+			}); err != nil {
+				t.Fatalf("SubscribeQueue failed: %v", err)
+			}
+		}
+		var plain int
+		if _, err := sp.Subscribe("jobs.*", func(msg interface{}) {
+			mu.Lock()
+			plain++
+			mu.Unlock()
+		}); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		for i := 0; i < 30; i++ {
+			if err := sp.Publish("jobs.resize", i); err != nil {
+				t.Fatalf("Publish failed: %v", err)
+			}
+		}
+		closeSubPub(t, sp)
+
+		for _, name := range []string{"w1", "w2", "w3"} {
+			if counts[name] != 10 {
+				t.Errorf("Expected 10 messages for %s, got %v", name, counts)
+			}
+		}
+		if plain != 30 {
+			t.Errorf("Expected plain subscriber to receive all 30 messages, got %d", plain)
+		}
+	})
+
+	t.Run("Queue Groups Per Pattern", func(t *testing.T) {
+		sp := NewSubPub(100)
+		var total, other atomic.Int32
+		for _, pattern := range []string{"jobs.resize", "jobs.*", "jobs.>"} {
+			if _, err := sp.SubscribeQueue(pattern, "workers", func(msg interface{}) {
+				total.Add(1)
+			}); err != nil {
+				t.Fatalf("SubscribeQueue failed: %v", err)
+			}
+		}
+		if _, err := sp.SubscribeQueue("jobs.resize", "auditors", func(msg interface{}) {
+			other.Add(1)
+		}); err != nil {
+			t.Fatalf("SubscribeQueue failed: %v", err)
+		}
+
+		for i := 0; i < 9; i++ {
+			sp.Publish("jobs.resize", i)
+		}
+		closeSubPub(t, sp)
+
+		// Each pattern has a "workers" group of its own.
+		if total.Load() != 27 {
+			t.Errorf("Expected each message once per pattern, got %d", total.Load())
+		}
+		if other.Load() != 9 {
+			t.Errorf("Expected second group to receive every message, got %d", other.Load())
+		}
+	})
+
+	t.Run("Queue Group Name On Two Subjects", func(t *testing.T) {
+		sp := NewSubPub(100)
+		counts := make(map[string]*atomic.Int32)
+		for _, subject := range []string{"a", "b"} {
+			for _, member := range []string{"1", "2"} {
+				n := new(atomic.Int32)
+				counts[subject+member] = n
+				if _, err := sp.SubscribeQueue(subject, "workers", func(msg interface{}) { n.Add(1) }); err != nil {
+					t.Fatalf("SubscribeQueue failed: %v", err)
+				}
+			}
+		}
+		for i := 0; i < 50; i++ {
+			sp.Publish("a", i)
+			sp.Publish("b", i)
+		}
+		closeSubPub(t, sp)
+		for name, n := range counts {
+			if n.Load() != 25 {
+				t.Errorf("Expected 25 messages for member %s, got %d", name, n.Load())
+			}
+		}
+	})
+
+	t.Run("Queue Group Least Loaded", func(t *testing.T) {
+		sp := NewSubPub(100, WithQueueStrategy(LeastLoaded))
+		release := make(chan struct{})
+		var busy, idle atomic.Int32
+		if _, err := sp.SubscribeQueue("jobs", "workers", func(msg interface{}) {
+			busy.Add(1)
+			<-release
+		}); err != nil {
+			t.Fatalf("SubscribeQueue failed: %v", err)
+		}
+		sp.Publish("jobs", 0)
+		time.Sleep(10 * time.Millisecond)
+		sp.Publish("jobs", 1)
+
+		if _, err := sp.SubscribeQueue("jobs", "workers", func(msg interface{}) {
+			idle.Add(1)
+		}); err != nil {
+			t.Fatalf("SubscribeQueue failed: %v", err)
+		}
+		for i := 0; i < 5; i++ {
+			sp.Publish("jobs", i)
+			time.Sleep(5 * time.Millisecond)
+		}
+		close(release)
+		closeSubPub(t, sp)
+
+		if idle.Load() != 5 {
+			t.Errorf("Expected idle member to receive all 5 messages, got %d (busy %d)", idle.Load(), busy.Load())
+		}
+	})
+
+	t.Run("Queue Group Unsubscribe", func(t *testing.T) {
+		sp := NewSubPub(100).(*subPub)
+		if _, err := sp.SubscribeQueue("jobs", "", func(msg interface{}) {}); !errors.Is(err, ErrInvalidQueueGroup) {
+			t.Errorf("Expected ErrInvalidQueueGroup, got %v", err)
+		}
+		sub1, _ := sp.SubscribeQueue("jobs", "workers", func(msg interface{}) {})
+		sub2, _ := sp.SubscribeQueue("jobs", "workers", func(msg interface{}) {})
+		sub1.Unsubscribe()
+		sub2.Unsubscribe()
+		if !sp.subs.empty() || len(sp.queues) != 0 {
+			t.Error("Expected queue group state to be removed with its last member")
+		}
+	})
+
+	t.Run("Publish Result", func(t *testing.T) {
+		sp := NewSubPub(1)
+		release := make(chan struct{})
+		hold := func(msg interface{}) { <-release }
+		for _, subject := range []string{"orders.eu", "orders.*"} {
+			if _, err := sp.Subscribe(subject, hold); err != nil {
+				t.Fatalf("Subscribe failed: %v", err)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := sp.SubscribeQueue("orders.>", "workers", hold); err != nil {
+				t.Fatalf("SubscribeQueue failed: %v", err)
+			}
+		}
+		defer closeSubPub(t, sp)
+		defer close(release)
+
+		res, err := sp.PublishWithResult("orders.eu", "first")
+		if err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		if want := (PublishResult{Matched: 3, Enqueued: 3}); res != want || res.Status() != Accepted {
+			t.Errorf("Expected %+v, got %+v", want, res)
+		}
+
+		// Let the plain subscribers take the first message so their buffers have room for one more.
+		time.Sleep(10 * time.Millisecond)
+		sp.Publish("orders.eu", "second")
+		res, _ = sp.PublishWithResult("orders.eu", "third")
+		if res.Matched != 3 || res.Enqueued+res.Dropped != 3 || res.Dropped < 2 {
+			t.Errorf("Expected full buffers to drop, got %+v", res)
+		}
+		if res, _ := sp.PublishWithResult("users", "nobody"); res.Status() != NoSubscribers {
+			t.Errorf("Expected no subscribers, got %+v", res)
+		}
+		if _, err := sp.PublishWithResult("orders.*", "wildcard"); !errors.Is(err, ErrInvalidSubject) {
+			t.Errorf("Expected ErrInvalidSubject, got %v", err)
+		}
+	})
+
+	t.Run("Publish Batch", func(t *testing.T) {
+		sp := NewSubPub(1)
+		release := make(chan struct{})
+		var mu sync.Mutex
+		var received []interface{}
+		if _, err := sp.Subscribe("orders.*", func(msg interface{}) {
+			<-release
+			mu.Lock()
+			received = append(received, msg)
+			mu.Unlock()
+		}); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		sp.Publish("orders.eu", 0)
+		time.Sleep(10 * time.Millisecond)
+
+		results := sp.PublishBatch([]BatchMessage{
+			{Subject: "orders.eu", Msg: 1},
+			{Subject: "orders.*", Msg: 2},
+			{Subject: "users", Msg: 3},
+			{Subject: "orders.us", Msg: 4},
+		})
+		if len(results) != 4 {
+			t.Fatalf("Expected 4 results, got %d", len(results))
+		}
+		if !errors.Is(results[1].Err, ErrInvalidSubject) {
+			t.Errorf("Expected ErrInvalidSubject for wildcard subject, got %v", results[1].Err)
+		}
+		// The handler holds the first message, so the buffer takes only one more.
+		want := map[int]PublishStatus{0: Accepted, 2: NoSubscribers, 3: Dropped}
+		for i, status := range want {
+			if results[i].Err != nil || results[i].Status() != status {
+				t.Errorf("Message %d: expected %v, got %v (err %v)", i, status, results[i].Status(), results[i].Err)
+			}
+		}
+		close(release)
+		closeSubPub(t, sp)
+		if !equalMessages(received, []interface{}{0, 1}) {
+			t.Errorf("Expected [0 1], got %v", received)
+		}
+	})
+
 	t.Run("Close with Timeout", func(t *testing.T) {
 		sp := NewSubPub(100)
 		_, err := sp.Subscribe("test", func(msg interface{}) {
@@ -191,3 +810,1010 @@ func TestSubPub(t *testing.T) {
 		}
 	})
 }
+
+func waitForGoroutines(t *testing.T, limit int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > limit {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected at most %d goroutines, got %d", limit, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func closeSubPub(t *testing.T, sp SubPub) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sp.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func equalMessages(got, want []interface{}) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReplay(t *testing.T) {
+	newLoggedSubPub := func(t *testing.T) SubPub {
+		t.Helper()
+		l, err := msglog.Open(t.TempDir())
+		if err != nil {
+			t.Fatalf("Open log failed: %v", err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return NewSubPub(100, WithLog(l))
+	}
+	collect := func(t *testing.T, sp SubPub, subject string, pos Position, opts ...SubscribeOption) (*[]*Message, *sync.Mutex) {
+		t.Helper()
+		var mu sync.Mutex
+		var received []*Message
+		_, err := sp.Subscribe(subject, func(msg interface{}) {
+			mu.Lock()
+			received = append(received, msg.(*Message))
+			mu.Unlock()
+		}, append(opts, StartAt(pos))...)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		return &received, &mu
+	}
+	seqs := func(msgs []*Message) []uint64 {
+		var out []uint64
+		for _, m := range msgs {
+			out = append(out, m.Seq)
+		}
+		return out
+	}
+
+	t.Run("Earliest", func(t *testing.T) {
+		sp := newLoggedSubPub(t)
+		for i := 0; i < 3; i++ {
+			sp.Publish("orders", &Message{
+				Data:        []byte(fmt.Sprintf("m%d", i)),
+				ContentType: "text/plain",
+				Headers:     map[string]string{"trace": fmt.Sprint(i), "empty": ""},
+			})
+		}
+		received, _ := collect(t, sp, "orders", Earliest)
+		sp.Publish("orders", &Message{Data: []byte("m3")})
+		closeSubPub(t, sp)
+
+		if got := seqs(*received); fmt.Sprint(got) != "[1 2 3 4]" {
+			t.Errorf("Expected seqs [1 2 3 4], got %v", got)
+		}
+		if first := (*received)[0]; string(first.Data) != "m0" || first.ContentType != "text/plain" || first.ID == "" ||
+			first.Headers["trace"] != "0" || len(first.Headers) != 2 {
+			t.Errorf("Expected replayed message to keep its envelope, got %+v", first)
+		}
+		if string((*received)[3].Data) != "m3" || (*received)[3].Subject != "orders" {
+			t.Errorf("Unexpected live message %+v", (*received)[3])
+		}
+	})
+
+	t.Run("Sequence", func(t *testing.T) {
+		sp := newLoggedSubPub(t)
+		for i := 0; i < 5; i++ {
+			sp.Publish("orders", "raw")
+		}
+		received, _ := collect(t, sp, "orders", AtSequence(4))
+		closeSubPub(t, sp)
+
+		if got := seqs(*received); fmt.Sprint(got) != "[4 5]" {
+			t.Errorf("Expected seqs [4 5], got %v", got)
+		}
+		if string((*received)[0].Data) != "raw" {
+			t.Errorf("Expected raw string payload to be persisted, got %q", (*received)[0].Data)
+		}
+	})
+
+	t.Run("Timestamp", func(t *testing.T) {
+		sp := newLoggedSubPub(t)
+		base := time.Now()
+		for i := 0; i < 4; i++ {
+			sp.Publish("orders", &Message{Data: []byte("m"), Time: base.Add(time.Duration(i) * time.Minute)})
+		}
+		received, _ := collect(t, sp, "orders", AtTime(base.Add(2*time.Minute)))
+		closeSubPub(t, sp)
+
+		if got := seqs(*received); fmt.Sprint(got) != "[3 4]" {
+			t.Errorf("Expected seqs [3 4], got %v", got)
+		}
+	})
+
+	t.Run("Catch Up Without Gaps", func(t *testing.T) {
+		sp := newLoggedSubPub(t)
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					sp.Publish("orders", &Message{Data: []byte("m")})
+				}
+			}
+		}()
+		time.Sleep(5 * time.Millisecond)
+		// Blocking keeps live messages from being dropped while the backlog is replayed.
+		received, mu := collect(t, sp, "orders", Earliest, OnOverflow(Block), BlockTimeout(10*time.Second))
+		time.Sleep(5 * time.Millisecond)
+		close(stop)
+		wg.Wait()
+		closeSubPub(t, sp)
+
+		mu.Lock()
+		defer mu.Unlock()
+		for i, m := range *received {
+			if m.Seq != uint64(i+1) {
+				t.Fatalf("Expected contiguous seqs, got %d at index %d", m.Seq, i)
+			}
+		}
+	})
+
+	t.Run("Live Only Without Log", func(t *testing.T) {
+		sp := NewSubPub(100)
+		if _, err := sp.Subscribe("orders", func(msg interface{}) {}, StartAt(Earliest)); !errors.Is(err, ErrNoLog) {
+			t.Errorf("Expected ErrNoLog, got %v", err)
+		}
+		var got *Message
+		sub, _ := sp.Subscribe("orders.*", func(msg interface{}) { got = msg.(*Message) })
+		sp.Publish("orders.eu", &Message{Data: []byte("m")})
+		sub.Unsubscribe()
+		closeSubPub(t, sp)
+		if got != nil && (got.Subject != "orders.eu" || got.Seq != 0 || got.Time.IsZero()) {
+			t.Errorf("Expected stamped message without seq, got %+v", got)
+		}
+	})
+
+	t.Run("Replay Errors", func(t *testing.T) {
+		sp := newLoggedSubPub(t)
+		if _, err := sp.Subscribe("orders.*", func(msg interface{}) {}, StartAt(Earliest)); !errors.Is(err, ErrReplayWildcard) {
+			t.Errorf("Expected ErrReplayWildcard, got %v", err)
+		}
+		if err := sp.Publish("orders", 42); !errors.Is(err, ErrNotPersistable) {
+			t.Errorf("Expected ErrNotPersistable, got %v", err)
+		}
+	})
+}
+
+func TestRetained(t *testing.T) {
+	collect := func(t *testing.T, sp SubPub, subject string) (func() []*Message, Subscription) {
+		t.Helper()
+		var mu sync.Mutex
+		var received []*Message
+		sub, err := sp.Subscribe(subject, func(msg interface{}) {
+			mu.Lock()
+			received = append(received, msg.(*Message))
+			mu.Unlock()
+		})
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		return func() []*Message {
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]*Message(nil), received...)
+		}, sub
+	}
+	data := func(msgs []*Message) []string {
+		var out []string
+		for _, m := range msgs {
+			out = append(out, string(m.Data))
+		}
+		return out
+	}
+
+	t.Run("Last Value", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		sp.Publish("status.eu", &Message{Data: []byte("a"), Retain: true})
+		sp.Publish("status.eu", &Message{Data: []byte("b"), Retain: true})
+		sp.Publish("status.us", &Message{Data: []byte("x"), Retain: true})
+		sp.Publish("status.asia", &Message{Data: []byte("live only")})
+
+		received, _ := collect(t, sp, "status.*")
+		msgs := received()
+		if got := data(msgs); !reflect.DeepEqual(got, []string{"b", "x"}) {
+			t.Fatalf("Expected retained [b x], got %v", got)
+		}
+		if !msgs[0].Retain || msgs[0].Subject != "status.eu" {
+			t.Errorf("Expected retained message for status.eu, got %+v", msgs[0])
+		}
+
+		sp.Publish("status.eu", &Message{Data: []byte("c"), Retain: true})
+		msgs = received()
+		if len(msgs) != 3 || string(msgs[2].Data) != "c" || msgs[2].Retain {
+			t.Errorf("Expected live delivery of c without the retained mark, got %v", data(msgs))
+		}
+
+		literal, _ := collect(t, sp, "status.eu")
+		if got := data(literal()); !reflect.DeepEqual(got, []string{"c"}) {
+			t.Errorf("Expected the latest retained value, got %v", got)
+		}
+	})
+
+	t.Run("Queue Groups Get No Retained Values", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		sp.Publish("status", &Message{Data: []byte("a"), Retain: true})
+		var got atomic.Int32
+		if _, err := sp.SubscribeQueue("status", "workers", func(msg interface{}) { got.Add(1) }); err != nil {
+			t.Fatalf("SubscribeQueue failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if got.Load() != 0 {
+			t.Errorf("Expected no retained delivery to a queue group, got %d", got.Load())
+		}
+	})
+
+	t.Run("Clear", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		for _, subject := range []string{"status.eu", "status.us", "other"} {
+			sp.Publish(subject, &Message{Data: []byte(subject), Retain: true})
+		}
+		n, err := sp.ClearRetained("status.>")
+		if err != nil || n != 2 {
+			t.Fatalf("Expected 2 cleared, got %d (err %v)", n, err)
+		}
+		if _, err := sp.ClearRetained("a.>.b"); !errors.Is(err, ErrInvalidSubject) {
+			t.Errorf("Expected ErrInvalidSubject, got %v", err)
+		}
+		received, _ := collect(t, sp, ">")
+		if got := data(received()); !reflect.DeepEqual(got, []string{"other"}) {
+			t.Errorf("Expected only other to stay retained, got %v", got)
+		}
+	})
+
+	t.Run("Memory Limit", func(t *testing.T) {
+		sp := NewSubPub(100, WithRetainedLimit(30))
+		defer closeSubPub(t, sp)
+		// Each message takes 12 bytes: its ID, subject and data.
+		sp.Publish("a", &Message{ID: "1", Data: []byte("0123456789"), Retain: true})
+		sp.Publish("b", &Message{ID: "2", Data: []byte("0123456789"), Retain: true})
+		sp.Publish("a", &Message{ID: "3", Data: []byte("0123456789"), Retain: true})
+		sp.Publish("c", &Message{ID: "4", Data: []byte("0123456789"), Retain: true})
+		sp.Publish("d", &Message{ID: "5", Data: make([]byte, 100), Retain: true})
+
+		received, _ := collect(t, sp, "*")
+		var ids []string
+		for _, m := range received() {
+			ids = append(ids, m.ID)
+		}
+		// b was retained longest ago and makes room for c; d alone exceeds the limit.
+		if !reflect.DeepEqual(ids, []string{"3", "4"}) {
+			t.Errorf("Expected retained ids [3 4], got %v", ids)
+		}
+	})
+
+	t.Run("Negative Limit Means No Cap", func(t *testing.T) {
+		sp := NewSubPub(100, WithRetainedLimit(-1))
+		defer closeSubPub(t, sp)
+		// More than the 64 MiB the server caps retained messages at by default.
+		sp.Publish("a", &Message{ID: "1", Data: make([]byte, 65<<20), Retain: true})
+		sp.Publish("b", &Message{ID: "2", Data: []byte("0123456789"), Retain: true})
+
+		received, _ := collect(t, sp, "*")
+		var ids []string
+		for _, m := range received() {
+			ids = append(ids, m.ID)
+		}
+		if !reflect.DeepEqual(ids, []string{"1", "2"}) {
+			t.Errorf("Expected retained ids [1 2], got %v", ids)
+		}
+	})
+}
+
+func TestTTL(t *testing.T) {
+	// hold subscribes a handler that blocks on the first message until release is closed.
+	hold := func(t *testing.T, sp SubPub, subject string) (Subscription, chan struct{}, *[]string, *sync.Mutex) {
+		t.Helper()
+		release := make(chan struct{})
+		var mu sync.Mutex
+		var received []string
+		sub, err := sp.Subscribe(subject, func(msg interface{}) {
+			<-release
+			mu.Lock()
+			received = append(received, string(msg.(*Message).Data))
+			mu.Unlock()
+		})
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		return sub, release, &received, &mu
+	}
+
+	t.Run("Expired While Buffered", func(t *testing.T) {
+		sp := NewSubPub(100)
+		sub, release, received, _ := hold(t, sp, "orders")
+		sp.Publish("orders", &Message{Data: []byte("first")})
+		sp.Publish("orders", &Message{Data: []byte("short"), TTL: 20 * time.Millisecond})
+		sp.Publish("orders", &Message{Data: []byte("long"), TTL: time.Minute})
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		closeSubPub(t, sp)
+
+		if !reflect.DeepEqual(*received, []string{"first", "long"}) {
+			t.Errorf("Expected [first long], got %v", *received)
+		}
+		if stats := sub.Stats(); stats.Delivered != 2 || stats.Expired != 1 {
+			t.Errorf("Expected 2 delivered and 1 expired, got %+v", stats)
+		}
+	})
+
+	t.Run("Default TTL", func(t *testing.T) {
+		sp := NewSubPub(100,
+			WithDefaultTTL("status.eu", time.Minute),
+			WithDefaultTTL("status.>", 20*time.Millisecond))
+		sub, release, received, _ := hold(t, sp, ">")
+		sp.Publish("first", &Message{Data: []byte("first")})
+		sp.Publish("status.us", &Message{Data: []byte("default")})
+		sp.Publish("status.us", &Message{Data: []byte("own"), TTL: time.Minute})
+		sp.Publish("status.eu", &Message{Data: []byte("first rule")})
+		sp.Publish("other", &Message{Data: []byte("no rule")})
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		closeSubPub(t, sp)
+
+		if !reflect.DeepEqual(*received, []string{"first", "own", "first rule", "no rule"}) {
+			t.Errorf("Expected the message with the default TTL to expire, got %v", *received)
+		}
+		if sub.Stats().Expired != 1 {
+			t.Errorf("Expected 1 expired, got %+v", sub.Stats())
+		}
+	})
+
+	t.Run("Expired Retained Value", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		sp.Publish("status", &Message{Data: []byte("up"), Retain: true, TTL: 10 * time.Millisecond})
+		time.Sleep(20 * time.Millisecond)
+		var got atomic.Int32
+		if _, err := sp.Subscribe("status", func(msg interface{}) { got.Add(1) }); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if got.Load() != 0 {
+			t.Errorf("Expected the expired retained value to be forgotten, got %d messages", got.Load())
+		}
+		if n, _ := sp.ClearRetained("status"); n != 0 {
+			t.Errorf("Expected nothing left to clear, got %d", n)
+		}
+	})
+}
+
+func TestRequest(t *testing.T) {
+	// respond answers every request on subject with reply.
+	respond := func(t *testing.T, sp SubPub, subject, reply string) {
+		t.Helper()
+		if _, err := sp.Subscribe(subject, func(msg interface{}) {
+			req := msg.(*Message)
+			sp.Publish(req.ReplyTo(), &Message{Data: append([]byte(reply+" "), req.Data...)})
+		}); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+	}
+
+	t.Run("First Reply", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		respond(t, sp, "greet", "hello")
+		req := &Message{Data: []byte("bob"), Headers: map[string]string{"tenant": "acme"}}
+		reply, err := sp.Request(context.Background(), "greet", req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		m := reply.(*Message)
+		if string(m.Data) != "hello bob" || !strings.HasPrefix(m.Subject, InboxPrefix) {
+			t.Errorf("Unexpected reply %+v", m)
+		}
+		if len(req.Headers) != 1 {
+			t.Errorf("Expected request headers to be left untouched, got %v", req.Headers)
+		}
+		if subs := sp.Subscriptions(); len(subs) != 1 {
+			t.Errorf("Expected the inbox to be unsubscribed, got %d subscriptions", len(subs))
+		}
+	})
+
+	t.Run("Queue Group Responders", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		var handled atomic.Int32
+		for i := 0; i < 3; i++ {
+			sp.SubscribeQueue("work", "workers", func(msg interface{}) {
+				handled.Add(1)
+				sp.Publish(msg.(*Message).ReplyTo(), "done")
+			})
+		}
+		for i := 0; i < 3; i++ {
+			if reply, err := sp.Request(context.Background(), "work", "job"); err != nil || reply != "done" {
+				t.Fatalf("Request failed: %v %v", reply, err)
+			}
+		}
+		if handled.Load() != 3 {
+			t.Errorf("Expected one responder per request, got %d", handled.Load())
+		}
+	})
+
+	t.Run("Inboxes Are Not Logged", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := msglog.Open(dir)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer l.Close()
+		sp := NewSubPub(100, WithLog(l))
+		defer closeSubPub(t, sp)
+		respond(t, sp, "greet", "hello")
+		for i := 0; i < 100; i++ {
+			reply, err := sp.Request(context.Background(), "greet", &Message{Data: []byte("bob")})
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if m := reply.(*Message); m.Seq != 0 {
+				t.Fatalf("Expected an unlogged reply, got seq %d", m.Seq)
+			}
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir failed: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "greet" {
+			t.Errorf("Expected only greet to be logged, got %d subjects", len(entries))
+		}
+	})
+
+	t.Run("No Responders", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		if _, err := sp.Request(context.Background(), "greet", "bob"); !errors.Is(err, ErrNoResponders) {
+			t.Errorf("Expected ErrNoResponders, got %v", err)
+		}
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		sp.Subscribe("greet", func(msg interface{}) {})
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := sp.Request(ctx, "greet", "bob"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("Invalid Request", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		if _, err := sp.Request(context.Background(), "greet", 42); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("Expected ErrInvalidRequest, got %v", err)
+		}
+		if _, err := sp.Request(context.Background(), "a..b", "bob"); !errors.Is(err, ErrInvalidSubject) {
+			t.Errorf("Expected ErrInvalidSubject, got %v", err)
+		}
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	t.Run("Subscription Info", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		sp.Subscribe("orders", func(msg interface{}) {}, Peer("10.0.0.1:5000"))
+		sp.Subscribe("orders", func(msg interface{}) {})
+		infos := sp.Subscriptions()
+		if len(infos) != 2 || infos[0].ID == "" || infos[0].ID == infos[1].ID {
+			t.Fatalf("Expected two subscriptions with distinct ids, got %+v", infos)
+		}
+		var peers []string
+		for _, info := range infos {
+			peers = append(peers, info.Peer)
+		}
+		if !slices.Contains(peers, "10.0.0.1:5000") || !slices.Contains(peers, "") {
+			t.Errorf("Unexpected peers %q", peers)
+		}
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		release := make(chan struct{})
+		var delivered atomic.Int32
+		sub, _ := sp.Subscribe("orders", func(msg interface{}) {
+			<-release
+			delivered.Add(1)
+		})
+		for i := 0; i < 5; i++ {
+			sp.Publish("orders", i)
+		}
+		id := sp.Subscriptions()[0].ID
+		if err := sp.Disconnect(id); err != nil {
+			t.Fatalf("Disconnect failed: %v", err)
+		}
+		close(release)
+		select {
+		case <-sub.Done():
+		case <-time.After(time.Second):
+			t.Fatal("Subscription not done after Disconnect")
+		}
+		if !errors.Is(sub.Err(), ErrDisconnected) {
+			t.Errorf("Expected ErrDisconnected, got %v", sub.Err())
+		}
+		// The message being handled completes, the buffered ones are discarded.
+		if n := delivered.Load(); n > 1 {
+			t.Errorf("Expected the buffer to be discarded, %d messages delivered", n)
+		}
+		if len(sp.Subscriptions()) != 0 {
+			t.Errorf("Expected no subscriptions left")
+		}
+		if err := sp.Disconnect(id); !errors.Is(err, ErrSubscriptionNotFound) {
+			t.Errorf("Expected ErrSubscriptionNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Drain", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		release := make(chan struct{})
+		var delivered atomic.Int32
+		handler := func(msg interface{}) {
+			<-release
+			delivered.Add(1)
+		}
+		orders, _ := sp.Subscribe("orders.eu", handler)
+		all, _ := sp.Subscribe("orders.*", handler)
+		alerts, _ := sp.Subscribe("alerts", handler)
+		for i := 0; i < 5; i++ {
+			sp.Publish("orders.eu", i)
+		}
+
+		result := make(chan int, 1)
+		go func() {
+			n, err := sp.Drain(context.Background(), "orders.>")
+			if err != nil {
+				t.Errorf("Drain failed: %v", err)
+			}
+			result <- n
+		}()
+		// Drain waits for the buffered messages to be delivered.
+		select {
+		case <-result:
+			t.Fatal("Drain returned before the buffers were delivered")
+		case <-time.After(20 * time.Millisecond):
+		}
+		if res, _ := sp.PublishWithResult("orders.eu", "late"); res.Matched != 0 {
+			t.Errorf("Expected drained subscriptions to stop matching, got %+v", res)
+		}
+		close(release)
+		if n := <-result; n != 2 {
+			t.Errorf("Expected 2 drained subscriptions, got %d", n)
+		}
+		if delivered.Load() != 10 {
+			t.Errorf("Expected 10 buffered messages delivered, got %d", delivered.Load())
+		}
+		for _, sub := range []Subscription{orders, all} {
+			if !errors.Is(sub.Err(), ErrDrained) {
+				t.Errorf("Expected ErrDrained, got %v", sub.Err())
+			}
+		}
+		if alerts.Err() != nil {
+			t.Errorf("Expected alerts to be left alone, got %v", alerts.Err())
+		}
+	})
+
+	t.Run("Drain Deadline", func(t *testing.T) {
+		sp := NewSubPub(100)
+		release := make(chan struct{})
+		defer closeSubPub(t, sp)
+		defer close(release)
+		sp.Subscribe("orders", func(msg interface{}) { <-release })
+		sp.Publish("orders", "x")
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if n, err := sp.Drain(ctx, "orders"); n != 1 || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected DeadlineExceeded after draining 1, got %d %v", n, err)
+		}
+	})
+
+	t.Run("Drain Invalid Subject", func(t *testing.T) {
+		sp := NewSubPub(100)
+		defer closeSubPub(t, sp)
+		if _, err := sp.Drain(context.Background(), "a..b"); !errors.Is(err, ErrInvalidSubject) {
+			t.Errorf("Expected ErrInvalidSubject, got %v", err)
+		}
+	})
+}
+
+// TestConcurrency is meant to be run with -race: it hammers the broker from many
+// goroutines and checks the ordering and delivery guarantees.
+func TestConcurrency(t *testing.T) {
+	const publishers, perPublisher = 8, 500
+
+	// stamped identifies a message by its publisher and its index in that publisher's calls.
+	type stamped struct{ publisher, index int }
+	publishAll := func(sp SubPub, subject func(p int) string) {
+		var wg sync.WaitGroup
+		for p := 0; p < publishers; p++ {
+			wg.Add(1)
+			go func(p int) {
+				defer wg.Done()
+				for i := 0; i < perPublisher; i++ {
+					sp.Publish(subject(p), stamped{p, i})
+				}
+			}(p)
+		}
+		wg.Wait()
+	}
+
+	t.Run("Per Publisher FIFO", func(t *testing.T) {
+		sp := NewSubPub(publishers*perPublisher, WithOverflowPolicy(Block))
+		defer closeSubPub(t, sp)
+		patterns := []string{"orders.eu", "orders.*", "orders.>", "orders.eu"}
+		last := make([][]int, len(patterns))
+		counts := make([]int, len(patterns))
+		for i, pattern := range patterns {
+			last[i] = make([]int, publishers)
+			for p := range last[i] {
+				last[i][p] = -1
+			}
+			// Each handler runs on the delivery goroutine of its own subscription.
+			sp.Subscribe(pattern, func(msg interface{}) {
+				m := msg.(stamped)
+				if m.index != last[i][m.publisher]+1 {
+					t.Errorf("%s: publisher %d: got message %d after %d", patterns[i], m.publisher, m.index, last[i][m.publisher])
+				}
+				last[i][m.publisher] = m.index
+				counts[i]++
+			})
+		}
+		publishAll(sp, func(int) string { return "orders.eu" })
+		// Close delivers the buffered messages before it returns.
+		closeSubPub(t, sp)
+		for i, n := range counts {
+			if n != publishers*perPublisher {
+				t.Errorf("%s: expected %d messages, got %d", patterns[i], publishers*perPublisher, n)
+			}
+		}
+	})
+
+	t.Run("Per Publisher FIFO Across Subjects", func(t *testing.T) {
+		sp := NewSubPub(publishers*perPublisher, WithOverflowPolicy(Block))
+		defer closeSubPub(t, sp)
+		last := make([]int, publishers)
+		for p := range last {
+			last[p] = -1
+		}
+		sp.Subscribe("orders.>", func(msg interface{}) {
+			m := msg.(stamped)
+			if m.index != last[m.publisher]+1 {
+				t.Errorf("Publisher %d: got message %d after %d", m.publisher, m.index, last[m.publisher])
+			}
+			last[m.publisher] = m.index
+		})
+		// Every publisher spreads its messages over subjects of its own.
+		var n atomic.Int64
+		publishAll(sp, func(p int) string { return fmt.Sprintf("orders.p%d.s%d", p, n.Add(1)%4) })
+		closeSubPub(t, sp)
+		for p, i := range last {
+			if i != perPublisher-1 {
+				t.Errorf("Publisher %d: last message %d", p, i)
+			}
+		}
+	})
+
+	t.Run("Delivery Follows Log Order", func(t *testing.T) {
+		l, err := msglog.Open(t.TempDir())
+		if err != nil {
+			t.Fatalf("Open log failed: %v", err)
+		}
+		defer l.Close()
+		sp := NewSubPub(publishers*perPublisher, WithLog(l), WithOverflowPolicy(Block))
+		defer closeSubPub(t, sp)
+		var lastSeq uint64
+		var received int
+		sp.Subscribe("orders", func(msg interface{}) {
+			m := msg.(*Message)
+			if m.Seq != lastSeq+1 {
+				t.Errorf("Got sequence %d after %d", m.Seq, lastSeq)
+			}
+			lastSeq = m.Seq
+			received++
+		})
+		var wg sync.WaitGroup
+		for p := 0; p < 4*publishers; p++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perPublisher/4; i++ {
+					sp.Publish("orders", &Message{Data: []byte("x")})
+				}
+			}()
+		}
+		wg.Wait()
+		closeSubPub(t, sp)
+		if received != publishers*perPublisher {
+			t.Errorf("Expected %d messages, got %d", publishers*perPublisher, received)
+		}
+	})
+
+	t.Run("Blocked Publisher", func(t *testing.T) {
+		sp := NewSubPub(1, WithOverflowPolicy(Block), WithBlockTimeout(10*time.Second))
+		defer closeSubPub(t, sp)
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		sub, _ := sp.Subscribe("orders", func(msg interface{}) {
+			started <- struct{}{}
+			<-release
+		})
+		defer close(release)
+		sp.Publish("orders", 1)
+		<-started
+		sp.Publish("orders", 2)
+		// The buffer is full: the third message waits for room.
+		result := make(chan PublishResult, 1)
+		go func() {
+			r, _ := sp.PublishWithResult("orders", 3)
+			result <- r
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		start := time.Now()
+		sub.Unsubscribe()
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("Unsubscribe waited %v for the blocked publisher", d)
+		}
+		select {
+		case r := <-result:
+			if r.Dropped != 1 {
+				t.Errorf("Expected the blocked message to be dropped, got %+v", r)
+			}
+		case <-time.After(time.Second):
+			t.Error("Publisher still blocked after Unsubscribe")
+		}
+	})
+
+	t.Run("Enqueued Messages Are Delivered", func(t *testing.T) {
+		for _, policy := range []OverflowPolicy{DropNewest, DropOldest, Block} {
+			t.Run(policy.String(), func(t *testing.T) {
+				sp := NewSubPub(16, WithOverflowPolicy(policy), WithBlockTimeout(time.Millisecond))
+				subs := make([]Subscription, 4)
+				for i := range subs {
+					subs[i], _ = sp.Subscribe("orders", func(msg interface{}) {})
+				}
+				var enqueued atomic.Int64
+				var wg sync.WaitGroup
+				for p := 0; p < publishers; p++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for i := 0; i < perPublisher; i++ {
+							r, _ := sp.PublishWithResult("orders", i)
+							enqueued.Add(int64(r.Enqueued))
+						}
+					}()
+				}
+				wg.Wait()
+				closeSubPub(t, sp)
+				var delivered int64
+				for _, sub := range subs {
+					st := sub.Stats()
+					delivered += int64(st.Delivered + st.Evicted)
+				}
+				if delivered != enqueued.Load() {
+					t.Errorf("Enqueued %d messages, delivered or evicted %d", enqueued.Load(), delivered)
+				}
+			})
+		}
+	})
+
+	t.Run("Unsubscribe And Close While Publishing", func(t *testing.T) {
+		for _, policy := range []OverflowPolicy{DropNewest, DropOldest, Block, Disconnect} {
+			t.Run(policy.String(), func(t *testing.T) {
+				sp := NewSubPub(4, WithOverflowPolicy(policy), WithBlockTimeout(time.Second))
+				stop := make(chan struct{})
+				var wg sync.WaitGroup
+				for p := 0; p < publishers; p++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for {
+							select {
+							case <-stop:
+								return
+							default:
+								sp.Publish("orders.eu", "x")
+							}
+						}
+					}()
+				}
+				// Subscriptions come and go while the publishers run; slow handlers keep
+				// the buffers full so Block publishers wait on them.
+				var subs []Subscription
+				for i := 0; i < 50; i++ {
+					sub, err := sp.Subscribe("orders.*", func(msg interface{}) { time.Sleep(10 * time.Microsecond) })
+					if err != nil {
+						t.Fatalf("Subscribe failed: %v", err)
+					}
+					subs = append(subs, sub)
+					if i%2 == 0 {
+						start := time.Now()
+						sub.Unsubscribe()
+						select {
+						case <-sub.Done():
+						case <-time.After(time.Second):
+							t.Fatal("Subscription not done after Unsubscribe")
+						}
+						// A blocked publisher must not hold up Unsubscribe for the block timeout.
+						if d := time.Since(start); d > 500*time.Millisecond {
+							t.Errorf("Unsubscribe took %v", d)
+						}
+					}
+				}
+				start := time.Now()
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := sp.Close(ctx); err != nil {
+					t.Fatalf("Close failed: %v", err)
+				}
+				if d := time.Since(start); d > 500*time.Millisecond {
+					t.Errorf("Close took %v", d)
+				}
+				close(stop)
+				wg.Wait()
+				for _, sub := range subs {
+					select {
+					case <-sub.Done():
+					default:
+						t.Error("Expected every subscription to be done after Close")
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("Subscribe While Publishing Retained", func(t *testing.T) {
+		sp := NewSubPub(publishers*perPublisher, WithOverflowPolicy(Block))
+		defer closeSubPub(t, sp)
+		stop := make(chan struct{})
+		published := make(chan struct{})
+		go func() {
+			defer close(published)
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+					sp.Publish("status", &Message{Data: []byte(strconv.Itoa(i)), Retain: true})
+				}
+			}
+		}()
+		// A new subscriber gets the retained value or the live message, never both,
+		// and then every later message in order.
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			last := -1
+			sub, err := sp.Subscribe("status", func(msg interface{}) {
+				n, _ := strconv.Atoi(string(msg.(*Message).Data))
+				if last >= 0 && n != last+1 {
+					t.Errorf("Got %d after %d", n, last)
+				}
+				last = n
+			})
+			if err != nil {
+				t.Fatalf("Subscribe failed: %v", err)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				time.Sleep(time.Millisecond)
+				sub.Unsubscribe()
+			}()
+		}
+		wg.Wait()
+		close(stop)
+		<-published
+	})
+
+	t.Run("Drain While Publishing", func(t *testing.T) {
+		sp := NewSubPub(8, WithOverflowPolicy(Block), WithBlockTimeout(time.Second))
+		defer closeSubPub(t, sp)
+		var delivered atomic.Int64
+		sub, _ := sp.Subscribe("orders", func(msg interface{}) {
+			delivered.Add(1)
+			time.Sleep(10 * time.Microsecond)
+		})
+		var enqueued atomic.Int64
+		var wg sync.WaitGroup
+		for p := 0; p < publishers; p++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perPublisher; i++ {
+					r, _ := sp.PublishWithResult("orders", i)
+					enqueued.Add(int64(r.Enqueued))
+				}
+			}()
+		}
+		time.Sleep(5 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := sp.Drain(ctx, "orders"); err != nil {
+			t.Fatalf("Drain failed: %v", err)
+		}
+		wg.Wait()
+		if !errors.Is(sub.Err(), ErrDrained) {
+			t.Errorf("Expected ErrDrained, got %v", sub.Err())
+		}
+		if delivered.Load() != enqueued.Load() {
+			t.Errorf("Enqueued %d messages, delivered %d", enqueued.Load(), delivered.Load())
+		}
+	})
+
+	t.Run("Registry Changes Do Not Wait For The Log", func(t *testing.T) {
+		sp := NewSubPub(8)
+		defer closeSubPub(t, sp)
+		// A publisher whose log append waits for the disk holds the stamping
+		// stripe of its subject, and only that.
+		st := &sp.(*subPub).stamping[sp.(*subPub).stripeOf("orders")]
+		st.Lock()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			sub, err := sp.Subscribe("orders", func(msg interface{}) {})
+			if err != nil {
+				t.Errorf("Subscribe failed: %v", err)
+				return
+			}
+			sub.Unsubscribe()
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("Subscribe waited for the stamping stripe")
+		}
+		st.Unlock()
+		<-done
+	})
+}
+
+// BenchmarkPublish measures publish throughput over 10k subjects, each with a
+// subscriber. Run it with several GOMAXPROCS values to see how publishing scales
+// with concurrent publishers, e.g.
+//
+//	go test -run '^$' -bench Publish -cpu 1,2,4,8 ./internal/subpub/
+func BenchmarkPublish(b *testing.B) {
+	const subjects = 10000
+	sp := NewSubPub(1024)
+	defer sp.Close(context.Background())
+	names := make([]string, subjects)
+	for i := range names {
+		names[i] = fmt.Sprintf("bench.%d.events", i)
+		if _, err := sp.Subscribe(names[i], func(msg interface{}) {}); err != nil {
+			b.Fatalf("Subscribe failed: %v", err)
+		}
+	}
+	var offset atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// Every goroutine walks the subjects from its own offset.
+		i := int(offset.Add(subjects / 16))
+		for pb.Next() {
+			sp.Publish(names[i%subjects], "x")
+			i++
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+}
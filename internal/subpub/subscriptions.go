@@ -0,0 +1,21 @@
+package subpub
+
+// Subscriptions returns a snapshot of every live subscription.
+func (sp *subPub) Subscriptions() []SubscriptionInfo {
+	sp.mu.Lock()
+	subs := sp.subs.all(nil)
+	sp.mu.Unlock()
+	infos := make([]SubscriptionInfo, 0, len(subs))
+	for _, sub := range subs {
+		infos = append(infos, SubscriptionInfo{
+			ID:       sub.id,
+			Subject:  sub.subject,
+			Queue:    sub.queue,
+			Pending:  len(sub.ch),
+			Capacity: cap(sub.ch),
+			Peer:     sub.peer,
+			Stats:    sub.Stats(),
+		})
+	}
+	return infos
+}
@@ -0,0 +1,159 @@
+// Package tlsconfig builds server TLS configurations from certificate files that
+// are reloaded when they change on disk. A reload only affects new handshakes, so
+// established connections and their streams are kept.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reloader serves the current certificate, and for mutual TLS the current client
+// CA pool, to every new TLS handshake.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu       sync.Mutex
+	versions map[string]fileVersion
+	current  atomic.Pointer[tls.Config]
+}
+
+// fileVersion identifies a revision of a file by its modification time and size.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// New loads the server key pair and, when clientCAFile is not empty, the CA bundle
+// used to require and verify client certificates.
+func New(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tlsconfig: certificate and key files are required")
+	}
+	r := &Reloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a configuration that picks up reloaded certificates on every handshake.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}
+}
+
+// Reload reads the files again if any of them changed and reports whether it did.
+// On error the previous configuration stays in use.
+func (r *Reloader) Reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	versions, err := r.stat()
+	if err != nil {
+		return false, err
+	}
+	if r.current.Load() != nil && equalVersions(versions, r.versions) {
+		return false, nil
+	}
+	cfg, err := r.load()
+	if err != nil {
+		return false, err
+	}
+	r.current.Store(cfg)
+	r.versions = versions
+	return true, nil
+}
+
+// Watch checks the files for changes every interval until ctx is done.
+// A non-positive interval disables reloading.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				log.Printf("Error reloading TLS certificates: %v", err)
+			} else if reloaded {
+				log.Printf("Reloaded TLS certificates from %s", r.certFile)
+			}
+		}
+	}
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+func (r *Reloader) stat() (map[string]fileVersion, error) {
+	versions := make(map[string]fileVersion)
+	for _, name := range r.files() {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, fmt.Errorf("tlsconfig: %w", err)
+		}
+		versions[name] = fileVersion{modTime: info.ModTime(), size: info.Size()}
+	}
+	return versions, nil
+}
+
+func equalVersions(a, b map[string]fileVersion) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, v := range a {
+		if w, ok := b[name]; !ok || !v.modTime.Equal(w.modTime) || v.size != w.size {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Reloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tlsconfig: load key pair: %w", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if r.clientCAFile == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(r.clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("tlsconfig: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tlsconfig: no certificates found in %s", r.clientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
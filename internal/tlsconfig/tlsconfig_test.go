@@ -0,0 +1,238 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloader(t *testing.T) {
+	t.Run("Reload Keeps Established Connections", func(t *testing.T) {
+		dir := t.TempDir()
+		ca := newCA(t, "ca")
+		certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+		ca.issue(t, 1, certFile, keyFile)
+
+		r, err := New(certFile, keyFile, "")
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		addr := serve(t, r.TLSConfig())
+		client := &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"}
+
+		old := dial(t, addr, client)
+		if serial := old.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); serial != 1 {
+			t.Fatalf("Expected serial 1, got %d", serial)
+		}
+		if reloaded, err := r.Reload(); err != nil || reloaded {
+			t.Errorf("Expected no reload without changes, got %v (err %v)", reloaded, err)
+		}
+
+		// Make sure the modification time differs on coarse-grained file systems.
+		time.Sleep(10 * time.Millisecond)
+		ca.issue(t, 2, certFile, keyFile)
+		if reloaded, err := r.Reload(); err != nil || !reloaded {
+			t.Fatalf("Expected reload, got %v (err %v)", reloaded, err)
+		}
+
+		fresh := dial(t, addr, client)
+		if serial := fresh.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); serial != 2 {
+			t.Errorf("Expected new handshakes to use serial 2, got %d", serial)
+		}
+		echo(t, old)
+		echo(t, fresh)
+	})
+
+	t.Run("Broken Files Keep Previous Certificate", func(t *testing.T) {
+		dir := t.TempDir()
+		ca := newCA(t, "ca")
+		certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+		ca.issue(t, 1, certFile, keyFile)
+		r, err := New(certFile, keyFile, "")
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		if err := os.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		if _, err := r.Reload(); err == nil {
+			t.Error("Expected reload of a broken key to fail")
+		}
+		addr := serve(t, r.TLSConfig())
+		conn := dial(t, addr, &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"})
+		if serial := conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); serial != 1 {
+			t.Errorf("Expected previous certificate to stay in use, got serial %d", serial)
+		}
+	})
+
+	t.Run("Mutual TLS", func(t *testing.T) {
+		dir := t.TempDir()
+		serverCA, clientCA := newCA(t, "server-ca"), newCA(t, "client-ca")
+		certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+		serverCA.issue(t, 1, certFile, keyFile)
+		caFile := filepath.Join(dir, "clients.pem")
+		writePEM(t, caFile, "CERTIFICATE", clientCA.cert.Raw)
+
+		r, err := New(certFile, keyFile, caFile)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		addr := serve(t, r.TLSConfig())
+
+		anonymous, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: serverCA.pool(), ServerName: "localhost"})
+		if err == nil {
+			// TLS 1.3 reports a missing client certificate on the first read.
+			_, err = anonymous.Read(make([]byte, 1))
+			anonymous.Close()
+		}
+		if err == nil {
+			t.Error("Expected handshake without a client certificate to fail")
+		}
+
+		clientCert, clientKey := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+		clientCA.issue(t, 7, clientCert, clientKey)
+		pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			t.Fatalf("LoadX509KeyPair failed: %v", err)
+		}
+		conn := dial(t, addr, &tls.Config{RootCAs: serverCA.pool(), ServerName: "localhost", Certificates: []tls.Certificate{pair}})
+		echo(t, conn)
+	})
+
+	t.Run("Missing Files", func(t *testing.T) {
+		if _, err := New("", "", ""); err == nil {
+			t.Error("Expected error without certificate files")
+		}
+		dir := t.TempDir()
+		if _, err := New(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key"), ""); err == nil {
+			t.Error("Expected error for missing files")
+		}
+	})
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1000),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue writes a certificate for localhost, usable by servers and clients, with the given serial.
+func (ca *testCA) issue(t *testing.T, serial int64, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %v", err)
+	}
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(t *testing.T, path, kind string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+// serve runs a TLS echo server and returns its address.
+func serve(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}(conn)
+		}
+	}()
+	return lis.Addr().String()
+}
+
+func dial(t *testing.T, addr string, cfg *tls.Config) *tls.Conn {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func echo(t *testing.T, conn *tls.Conn) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Expected echo, got %q (err %v)", buf, err)
+	}
+}
@@ -20,8 +20,14 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	PubSub_Subscribe_FullMethodName = "/PubSub/Subscribe"
-	PubSub_Publish_FullMethodName   = "/PubSub/Publish"
+	PubSub_Subscribe_FullMethodName        = "/PubSub/Subscribe"
+	PubSub_SubscribeWithAck_FullMethodName = "/PubSub/SubscribeWithAck"
+	PubSub_Publish_FullMethodName          = "/PubSub/Publish"
+	PubSub_PublishBatch_FullMethodName     = "/PubSub/PublishBatch"
+	PubSub_PublishStream_FullMethodName    = "/PubSub/PublishStream"
+	PubSub_Session_FullMethodName          = "/PubSub/Session"
+	PubSub_ClearRetained_FullMethodName    = "/PubSub/ClearRetained"
+	PubSub_Request_FullMethodName          = "/PubSub/Request"
 )
 
 // PubSubClient is the client API for PubSub service.
@@ -29,7 +35,32 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type PubSubClient interface {
 	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
-	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// SubscribeWithAck delivers events at least once. The first client message must
+	// be a subscribe request; afterwards the client acknowledges event ids. Events
+	// not acknowledged within the ack deadline are redelivered, and events that
+	// reach the delivery limit are published to the dead-letter key instead.
+	SubscribeWithAck(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[AckRequest, Event], error)
+	// Publish reports how the message was delivered. When the server requires
+	// delivery, a message no subscriber buffered fails with RESOURCE_EXHAUSTED.
+	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error)
+	// PublishBatch publishes the messages in order and reports the outcome of each one.
+	// A rejected message does not stop the rest of the batch.
+	PublishBatch(ctx context.Context, in *PublishBatchRequest, opts ...grpc.CallOption) (*PublishBatchResponse, error)
+	// PublishStream publishes messages as they arrive and reports the outcome of
+	// every message, in order, once the client closes the stream.
+	PublishStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PublishRequest, PublishBatchResponse], error)
+	// Session multiplexes any number of subscriptions and publishes over one stream.
+	// The server answers every command and streams events tagged with the id of
+	// their subscription. The session ends when the client closes its side.
+	Session(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[SessionRequest, SessionResponse], error)
+	// ClearRetained forgets the retained messages of every key matched by the
+	// given key, which may contain wildcards.
+	ClearRetained(ctx context.Context, in *ClearRetainedRequest, opts ...grpc.CallOption) (*ClearRetainedResponse, error)
+	// Request publishes the message with a unique reply key in its "reply-to" header
+	// and returns the first event published to that key. It waits until the deadline
+	// of the call, bounded by the server's request timeout, and fails with UNAVAILABLE
+	// when nothing is subscribed to the key.
+	Request(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*Event, error)
 }
 
 type pubSubClient struct {
@@ -59,9 +90,22 @@ func (c *pubSubClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type PubSub_SubscribeClient = grpc.ServerStreamingClient[Event]
 
-func (c *pubSubClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+func (c *pubSubClient) SubscribeWithAck(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[AckRequest, Event], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	out := new(emptypb.Empty)
+	stream, err := c.cc.NewStream(ctx, &PubSub_ServiceDesc.Streams[1], PubSub_SubscribeWithAck_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AckRequest, Event]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PubSub_SubscribeWithAckClient = grpc.BidiStreamingClient[AckRequest, Event]
+
+func (c *pubSubClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PublishResponse)
 	err := c.cc.Invoke(ctx, PubSub_Publish_FullMethodName, in, out, cOpts...)
 	if err != nil {
 		return nil, err
@@ -69,12 +113,93 @@ func (c *pubSubClient) Publish(ctx context.Context, in *PublishRequest, opts ...
 	return out, nil
 }
 
+func (c *pubSubClient) PublishBatch(ctx context.Context, in *PublishBatchRequest, opts ...grpc.CallOption) (*PublishBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PublishBatchResponse)
+	err := c.cc.Invoke(ctx, PubSub_PublishBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pubSubClient) PublishStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PublishRequest, PublishBatchResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PubSub_ServiceDesc.Streams[2], PubSub_PublishStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PublishRequest, PublishBatchResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PubSub_PublishStreamClient = grpc.ClientStreamingClient[PublishRequest, PublishBatchResponse]
+
+func (c *pubSubClient) Session(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[SessionRequest, SessionResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PubSub_ServiceDesc.Streams[3], PubSub_Session_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SessionRequest, SessionResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PubSub_SessionClient = grpc.BidiStreamingClient[SessionRequest, SessionResponse]
+
+func (c *pubSubClient) ClearRetained(ctx context.Context, in *ClearRetainedRequest, opts ...grpc.CallOption) (*ClearRetainedResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClearRetainedResponse)
+	err := c.cc.Invoke(ctx, PubSub_ClearRetained_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pubSubClient) Request(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*Event, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Event)
+	err := c.cc.Invoke(ctx, PubSub_Request_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PubSubServer is the server API for PubSub service.
 // All implementations must embed UnimplementedPubSubServer
 // for forward compatibility.
 type PubSubServer interface {
 	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Event]) error
-	Publish(context.Context, *PublishRequest) (*emptypb.Empty, error)
+	// SubscribeWithAck delivers events at least once. The first client message must
+	// be a subscribe request; afterwards the client acknowledges event ids. Events
+	// not acknowledged within the ack deadline are redelivered, and events that
+	// reach the delivery limit are published to the dead-letter key instead.
+	SubscribeWithAck(grpc.BidiStreamingServer[AckRequest, Event]) error
+	// Publish reports how the message was delivered. When the server requires
+	// delivery, a message no subscriber buffered fails with RESOURCE_EXHAUSTED.
+	Publish(context.Context, *PublishRequest) (*PublishResponse, error)
+	// PublishBatch publishes the messages in order and reports the outcome of each one.
+	// A rejected message does not stop the rest of the batch.
+	PublishBatch(context.Context, *PublishBatchRequest) (*PublishBatchResponse, error)
+	// PublishStream publishes messages as they arrive and reports the outcome of
+	// every message, in order, once the client closes the stream.
+	PublishStream(grpc.ClientStreamingServer[PublishRequest, PublishBatchResponse]) error
+	// Session multiplexes any number of subscriptions and publishes over one stream.
+	// The server answers every command and streams events tagged with the id of
+	// their subscription. The session ends when the client closes its side.
+	Session(grpc.BidiStreamingServer[SessionRequest, SessionResponse]) error
+	// ClearRetained forgets the retained messages of every key matched by the
+	// given key, which may contain wildcards.
+	ClearRetained(context.Context, *ClearRetainedRequest) (*ClearRetainedResponse, error)
+	// Request publishes the message with a unique reply key in its "reply-to" header
+	// and returns the first event published to that key. It waits until the deadline
+	// of the call, bounded by the server's request timeout, and fails with UNAVAILABLE
+	// when nothing is subscribed to the key.
+	Request(context.Context, *PublishRequest) (*Event, error)
 	mustEmbedUnimplementedPubSubServer()
 }
 
@@ -88,9 +213,27 @@ type UnimplementedPubSubServer struct{}
 func (UnimplementedPubSubServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Event]) error {
 	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
 }
-func (UnimplementedPubSubServer) Publish(context.Context, *PublishRequest) (*emptypb.Empty, error) {
+func (UnimplementedPubSubServer) SubscribeWithAck(grpc.BidiStreamingServer[AckRequest, Event]) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeWithAck not implemented")
+}
+func (UnimplementedPubSubServer) Publish(context.Context, *PublishRequest) (*PublishResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
 }
+func (UnimplementedPubSubServer) PublishBatch(context.Context, *PublishBatchRequest) (*PublishBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PublishBatch not implemented")
+}
+func (UnimplementedPubSubServer) PublishStream(grpc.ClientStreamingServer[PublishRequest, PublishBatchResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method PublishStream not implemented")
+}
+func (UnimplementedPubSubServer) Session(grpc.BidiStreamingServer[SessionRequest, SessionResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Session not implemented")
+}
+func (UnimplementedPubSubServer) ClearRetained(context.Context, *ClearRetainedRequest) (*ClearRetainedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClearRetained not implemented")
+}
+func (UnimplementedPubSubServer) Request(context.Context, *PublishRequest) (*Event, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Request not implemented")
+}
 func (UnimplementedPubSubServer) mustEmbedUnimplementedPubSubServer() {}
 func (UnimplementedPubSubServer) testEmbeddedByValue()                {}
 
@@ -123,6 +266,13 @@ func _PubSub_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type PubSub_SubscribeServer = grpc.ServerStreamingServer[Event]
 
+func _PubSub_SubscribeWithAck_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PubSubServer).SubscribeWithAck(&grpc.GenericServerStream[AckRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PubSub_SubscribeWithAckServer = grpc.BidiStreamingServer[AckRequest, Event]
+
 func _PubSub_Publish_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(PublishRequest)
 	if err := dec(in); err != nil {
@@ -141,6 +291,74 @@ func _PubSub_Publish_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PubSub_PublishBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PubSubServer).PublishBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PubSub_PublishBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PubSubServer).PublishBatch(ctx, req.(*PublishBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PubSub_PublishStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PubSubServer).PublishStream(&grpc.GenericServerStream[PublishRequest, PublishBatchResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PubSub_PublishStreamServer = grpc.ClientStreamingServer[PublishRequest, PublishBatchResponse]
+
+func _PubSub_Session_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PubSubServer).Session(&grpc.GenericServerStream[SessionRequest, SessionResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PubSub_SessionServer = grpc.BidiStreamingServer[SessionRequest, SessionResponse]
+
+func _PubSub_ClearRetained_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearRetainedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PubSubServer).ClearRetained(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PubSub_ClearRetained_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PubSubServer).ClearRetained(ctx, req.(*ClearRetainedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PubSub_Request_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PubSubServer).Request(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PubSub_Request_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PubSubServer).Request(ctx, req.(*PublishRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // PubSub_ServiceDesc is the grpc.ServiceDesc for PubSub service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -152,6 +370,18 @@ var PubSub_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Publish",
 			Handler:    _PubSub_Publish_Handler,
 		},
+		{
+			MethodName: "PublishBatch",
+			Handler:    _PubSub_PublishBatch_Handler,
+		},
+		{
+			MethodName: "ClearRetained",
+			Handler:    _PubSub_ClearRetained_Handler,
+		},
+		{
+			MethodName: "Request",
+			Handler:    _PubSub_Request_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -159,6 +389,263 @@ var PubSub_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _PubSub_Subscribe_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "SubscribeWithAck",
+			Handler:       _PubSub_SubscribeWithAck_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "PublishStream",
+			Handler:       _PubSub_PublishStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Session",
+			Handler:       _PubSub_Session_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/api/subpub.proto",
+}
+
+const (
+	Admin_ListSubjects_FullMethodName           = "/Admin/ListSubjects"
+	Admin_ListSubscriptions_FullMethodName      = "/Admin/ListSubscriptions"
+	Admin_DisconnectSubscription_FullMethodName = "/Admin/DisconnectSubscription"
+	Admin_DrainSubject_FullMethodName           = "/Admin/DrainSubject"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Admin lets operators inspect and manage the subscriptions of the broker. Every
+// call requires admin access to the subject it is about.
+type AdminClient interface {
+	// ListSubjects returns every subscribed subject with its number of subscriptions.
+	ListSubjects(ctx context.Context, in *ListSubjectsRequest, opts ...grpc.CallOption) (*ListSubjectsResponse, error)
+	// ListSubscriptions returns the subscriptions whose subject is covered by the
+	// given subject, with their buffer fill and the address of their client.
+	ListSubscriptions(ctx context.Context, in *ListSubscriptionsRequest, opts ...grpc.CallOption) (*ListSubscriptionsResponse, error)
+	// DisconnectSubscription ends a subscription at once, discarding the messages
+	// buffered for it. Its stream fails with ABORTED.
+	DisconnectSubscription(ctx context.Context, in *DisconnectSubscriptionRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// DrainSubject stops routing messages to the subscriptions whose subject is
+	// covered by the given subject and returns once they have delivered what they
+	// buffered, or when the deadline of the call passes. Their streams then fail
+	// with ABORTED.
+	DrainSubject(ctx context.Context, in *DrainSubjectRequest, opts ...grpc.CallOption) (*DrainSubjectResponse, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) ListSubjects(ctx context.Context, in *ListSubjectsRequest, opts ...grpc.CallOption) (*ListSubjectsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSubjectsResponse)
+	err := c.cc.Invoke(ctx, Admin_ListSubjects_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListSubscriptions(ctx context.Context, in *ListSubscriptionsRequest, opts ...grpc.CallOption) (*ListSubscriptionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSubscriptionsResponse)
+	err := c.cc.Invoke(ctx, Admin_ListSubscriptions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DisconnectSubscription(ctx context.Context, in *DisconnectSubscriptionRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Admin_DisconnectSubscription_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DrainSubject(ctx context.Context, in *DrainSubjectRequest, opts ...grpc.CallOption) (*DrainSubjectResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DrainSubjectResponse)
+	err := c.cc.Invoke(ctx, Admin_DrainSubject_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility.
+//
+// Admin lets operators inspect and manage the subscriptions of the broker. Every
+// call requires admin access to the subject it is about.
+type AdminServer interface {
+	// ListSubjects returns every subscribed subject with its number of subscriptions.
+	ListSubjects(context.Context, *ListSubjectsRequest) (*ListSubjectsResponse, error)
+	// ListSubscriptions returns the subscriptions whose subject is covered by the
+	// given subject, with their buffer fill and the address of their client.
+	ListSubscriptions(context.Context, *ListSubscriptionsRequest) (*ListSubscriptionsResponse, error)
+	// DisconnectSubscription ends a subscription at once, discarding the messages
+	// buffered for it. Its stream fails with ABORTED.
+	DisconnectSubscription(context.Context, *DisconnectSubscriptionRequest) (*emptypb.Empty, error)
+	// DrainSubject stops routing messages to the subscriptions whose subject is
+	// covered by the given subject and returns once they have delivered what they
+	// buffered, or when the deadline of the call passes. Their streams then fail
+	// with ABORTED.
+	DrainSubject(context.Context, *DrainSubjectRequest) (*DrainSubjectResponse, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAdminServer struct{}
+
+func (UnimplementedAdminServer) ListSubjects(context.Context, *ListSubjectsRequest) (*ListSubjectsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSubjects not implemented")
+}
+func (UnimplementedAdminServer) ListSubscriptions(context.Context, *ListSubscriptionsRequest) (*ListSubscriptionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSubscriptions not implemented")
+}
+func (UnimplementedAdminServer) DisconnectSubscription(context.Context, *DisconnectSubscriptionRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisconnectSubscription not implemented")
+}
+func (UnimplementedAdminServer) DrainSubject(context.Context, *DrainSubjectRequest) (*DrainSubjectResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DrainSubject not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+func (UnimplementedAdminServer) testEmbeddedByValue()               {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	// If the following call pancis, it indicates UnimplementedAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_ListSubjects_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSubjectsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListSubjects(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListSubjects_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListSubjects(ctx, req.(*ListSubjectsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListSubscriptions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSubscriptionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListSubscriptions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListSubscriptions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListSubscriptions(ctx, req.(*ListSubscriptionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DisconnectSubscription_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisconnectSubscriptionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DisconnectSubscription(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_DisconnectSubscription_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DisconnectSubscription(ctx, req.(*DisconnectSubscriptionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DrainSubject_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainSubjectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DrainSubject(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_DrainSubject_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DrainSubject(ctx, req.(*DrainSubjectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListSubjects",
+			Handler:    _Admin_ListSubjects_Handler,
+		},
+		{
+			MethodName: "ListSubscriptions",
+			Handler:    _Admin_ListSubscriptions_Handler,
+		},
+		{
+			MethodName: "DisconnectSubscription",
+			Handler:    _Admin_DisconnectSubscription_Handler,
+		},
+		{
+			MethodName: "DrainSubject",
+			Handler:    _Admin_DrainSubject_Handler,
+		},
 	},
+	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/api/subpub.proto",
 }
@@ -0,0 +1,385 @@
+// Package client implements subpub.SubPub on top of the PubSub gRPC service, so
+// code written against the in-process broker can use a remote one instead.
+package client
+
+import (
+	pb "asyn-subpub-service/pb/proto/api"
+	"asyn-subpub-service/pkg/subpub"
+	"context"
+	"errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by calls made after Close.
+var ErrClosed = errors.New("client: closed")
+
+// subscriptionIDHeader is the header the server reports the id of a Subscribe stream in.
+const subscriptionIDHeader = "subscription-id"
+
+// errorDomain is the domain of the ErrorInfo details the server attaches to
+// statuses that stand for subpub errors; reasonErrors maps their reasons.
+const errorDomain = "subpub"
+
+var reasonErrors = map[string]error{
+	"SLOW_CONSUMER": subpub.ErrSlowConsumer,
+	"DISCONNECTED":  subpub.ErrDisconnected,
+	"DRAINED":       subpub.ErrDrained,
+	"NO_RESPONDERS": subpub.ErrNoResponders,
+}
+
+// Client is a subpub.SubPub backed by a PubSub server. Subscriptions whose stream
+// fails are reopened with exponential backoff; once the server keeps a message
+// log, a literal subscription resumes after the last sequence it received, so
+// no message is lost while it reconnects.
+//
+// Delivery differs from the in-process broker in two ways: handlers always
+// receive a *subpub.Message, whatever type was published, and buffering and
+// overflow are handled by the server, so BufferSize, OnOverflow and BlockTimeout
+// are ignored.
+type Client struct {
+	conn     *grpc.ClientConn
+	api      pb.PubSubClient
+	admin    pb.AdminClient
+	dialOpts []grpc.DialOption
+	backoff  backoff
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	subs   map[*subscription]struct{}
+	closed bool
+}
+
+var _ subpub.SubPub = (*Client)(nil)
+
+// Option configures a Client created by New or NewFromConn.
+type Option func(*Client)
+
+// WithDialOptions passes opts to grpc.NewClient, e.g. the transport credentials.
+// It has no effect on NewFromConn.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(c *Client) {
+		c.dialOpts = append(c.dialOpts, opts...)
+	}
+}
+
+// WithBackoff sets the delay before the first attempt to reopen a failed
+// subscription and the cap the delay doubles up to on every further attempt.
+func WithBackoff(initial, max time.Duration) Option {
+	return func(c *Client) {
+		if initial > 0 {
+			c.backoff.initial = initial
+		}
+		if max > 0 {
+			c.backoff.max = max
+		}
+	}
+}
+
+// New connects to the server at target. The connection is closed by Close.
+func New(target string, opts ...Option) (*Client, error) {
+	c := newClient(opts)
+	conn, err := grpc.NewClient(target, c.dialOpts...)
+	if err != nil {
+		c.cancel()
+		return nil, err
+	}
+	c.conn = conn
+	c.api = pb.NewPubSubClient(conn)
+	c.admin = pb.NewAdminClient(conn)
+	return c, nil
+}
+
+// NewFromConn uses an existing connection, which Close leaves open.
+func NewFromConn(conn grpc.ClientConnInterface, opts ...Option) *Client {
+	c := newClient(opts)
+	c.api = pb.NewPubSubClient(conn)
+	c.admin = pb.NewAdminClient(conn)
+	return c
+}
+
+func newClient(opts []Option) *Client {
+	c := &Client{
+		backoff: backoff{initial: 100 * time.Millisecond, max: 10 * time.Second},
+		subs:    make(map[*subscription]struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.backoff.max < c.backoff.initial {
+		c.backoff.max = c.backoff.initial
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+func (c *Client) Subscribe(subject string, cb subpub.MessageHandler, opts ...subpub.SubscribeOption) (subpub.Subscription, error) {
+	return c.subscribe(subject, "", cb, opts)
+}
+
+func (c *Client) SubscribeQueue(subject, group string, cb subpub.MessageHandler, opts ...subpub.SubscribeOption) (subpub.Subscription, error) {
+	if group == "" {
+		return nil, subpub.ErrInvalidQueueGroup
+	}
+	return c.subscribe(subject, group, cb, opts)
+}
+
+// subscribe returns once the server has registered the subscription, so every
+// message published afterwards is delivered to it.
+func (c *Client) subscribe(subject, group string, cb subpub.MessageHandler, opts []subpub.SubscribeOption) (subpub.Subscription, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	sub := &subscription{
+		client: c,
+		key:    subject,
+		group:  group,
+		start:  subpub.ResolveSubscribeOptions(opts...).Start,
+		cb:     cb,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	c.subs[sub] = struct{}{}
+	c.wg.Add(1)
+	c.mu.Unlock()
+
+	stream, err := sub.open()
+	if err != nil {
+		cancel()
+		c.remove(sub)
+		c.wg.Done()
+		return nil, err
+	}
+	go sub.run(stream)
+	return sub, nil
+}
+
+func (c *Client) remove(sub *subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subs, sub)
+}
+
+func (c *Client) Publish(subject string, msg interface{}) error {
+	_, err := c.PublishWithResult(subject, msg)
+	return err
+}
+
+func (c *Client) PublishWithResult(subject string, msg interface{}) (subpub.PublishResult, error) {
+	req, err := toRequest(subject, msg)
+	if err != nil {
+		return subpub.PublishResult{}, err
+	}
+	if err := c.checkOpen(); err != nil {
+		return subpub.PublishResult{}, err
+	}
+	resp, err := c.api.Publish(c.ctx, req)
+	if err != nil {
+		return subpub.PublishResult{}, c.callError(err)
+	}
+	return subpub.PublishResult{
+		Matched:  int(resp.Matched),
+		Enqueued: int(resp.Enqueued),
+		Dropped:  int(resp.Dropped),
+	}, nil
+}
+
+// PublishBatch publishes the batch in a single call. The server reports only the
+// status of each message, so the counts of every result are the smallest ones
+// with that status.
+func (c *Client) PublishBatch(msgs []subpub.BatchMessage) []subpub.BatchResult {
+	results := make([]subpub.BatchResult, len(msgs))
+	req := &pb.PublishBatchRequest{}
+	// sent maps the messages of the request to their index in msgs.
+	var sent []int
+	for i, m := range msgs {
+		r, err := toRequest(m.Subject, m.Msg)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		req.Messages = append(req.Messages, r)
+		sent = append(sent, i)
+	}
+	if len(sent) == 0 {
+		return results
+	}
+	err := c.checkOpen()
+	var resp *pb.PublishBatchResponse
+	if err == nil {
+		resp, err = c.api.PublishBatch(c.ctx, req)
+	}
+	if err != nil {
+		err = c.callError(err)
+		for _, i := range sent {
+			results[i].Err = err
+		}
+		return results
+	}
+	for j, r := range resp.Results {
+		if j >= len(sent) {
+			break
+		}
+		results[sent[j]] = batchResult(r)
+	}
+	return results
+}
+
+func batchResult(r *pb.PublishResult) subpub.BatchResult {
+	switch r.Status {
+	case pb.PublishResult_ACCEPTED:
+		return subpub.BatchResult{PublishResult: subpub.PublishResult{Matched: 1, Enqueued: 1}}
+	case pb.PublishResult_DROPPED:
+		return subpub.BatchResult{PublishResult: subpub.PublishResult{Matched: 1, Dropped: 1}}
+	case pb.PublishResult_NO_SUBSCRIBERS:
+		return subpub.BatchResult{}
+	default:
+		return subpub.BatchResult{Err: errors.New(r.Error)}
+	}
+}
+
+// Request sends the request through the server, which waits for the reply
+// until the deadline of ctx, bounded by its own request timeout.
+func (c *Client) Request(ctx context.Context, subject string, msg interface{}) (interface{}, error) {
+	req, err := toRequest(subject, msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkOpen(); err != nil {
+		return nil, err
+	}
+	event, err := c.api.Request(ctx, req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(reasonError(err), subpub.ErrNoResponders) {
+			return nil, subpub.ErrNoResponders
+		}
+		return nil, c.callError(err)
+	}
+	return fromEvent(event), nil
+}
+
+// Subscriptions returns the subscriptions of this client with the ids the server
+// gave them. Only the delivered count of their stats is known on the client side.
+func (c *Client) Subscriptions() []subpub.SubscriptionInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	infos := make([]subpub.SubscriptionInfo, 0, len(c.subs))
+	for sub := range c.subs {
+		infos = append(infos, subpub.SubscriptionInfo{ID: sub.ID(), Subject: sub.key, Queue: sub.group, Stats: sub.Stats()})
+	}
+	return infos
+}
+
+func (c *Client) ClearRetained(pattern string) (int, error) {
+	if err := c.checkOpen(); err != nil {
+		return 0, err
+	}
+	resp, err := c.api.ClearRetained(c.ctx, &pb.ClearRetainedRequest{Key: pattern})
+	if err != nil {
+		return 0, c.callError(err)
+	}
+	return int(resp.Cleared), nil
+}
+
+// Disconnect ends a subscription of the server, with an id listed by the Admin
+// service; it needs the server to register that service.
+func (c *Client) Disconnect(id string) error {
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+	_, err := c.admin.DisconnectSubscription(c.ctx, &pb.DisconnectSubscriptionRequest{Id: id})
+	if status.Code(err) == codes.NotFound {
+		return subpub.ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return c.callError(err)
+	}
+	return nil
+}
+
+// Drain drains subscriptions of the server through the Admin service.
+func (c *Client) Drain(ctx context.Context, pattern string) (int, error) {
+	if err := c.checkOpen(); err != nil {
+		return 0, err
+	}
+	resp, err := c.admin.DrainSubject(ctx, &pb.DrainSubjectRequest{Subject: pattern})
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, c.callError(err)
+	}
+	return int(resp.Drained), nil
+}
+
+// Close ends every subscription and waits for their handlers to return until
+// ctx is done. A connection opened by New is closed as well.
+func (c *Client) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+	c.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if c.conn != nil {
+		if closeErr := c.conn.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (c *Client) checkOpen() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// callError reports calls interrupted by Close as ErrClosed.
+func (c *Client) callError(err error) error {
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+	return err
+}
+
+// reasonError returns the subpub error the server marked err with, or nil.
+func reasonError(err error) error {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == errorDomain {
+			return reasonErrors[info.Reason]
+		}
+	}
+	return nil
+}
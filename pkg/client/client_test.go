@@ -0,0 +1,390 @@
+package client
+
+import (
+	"asyn-subpub-service/internal/msglog"
+	"asyn-subpub-service/internal/services"
+	"asyn-subpub-service/internal/subpub"
+	pb "asyn-subpub-service/pb/proto/api"
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer serves a SubPub over an in-memory listener that can be restarted.
+type testServer struct {
+	sp subpub.SubPub
+
+	mu  sync.Mutex
+	lis *bufconn.Listener
+	srv *grpc.Server
+}
+
+func newTestServer(t *testing.T, sp subpub.SubPub) *testServer {
+	t.Helper()
+	ts := &testServer{sp: sp}
+	ts.start()
+	t.Cleanup(ts.stop)
+	return ts
+}
+
+func (ts *testServer) start() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.lis = bufconn.Listen(1 << 20)
+	ts.srv = grpc.NewServer()
+	server := services.NewServer(ts.sp)
+	pb.RegisterPubSubServer(ts.srv, server)
+	pb.RegisterAdminServer(ts.srv, services.NewAdminServer(server))
+	go ts.srv.Serve(ts.lis)
+}
+
+func (ts *testServer) stop() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.srv.Stop()
+}
+
+func (ts *testServer) dial(ctx context.Context, _ string) (net.Conn, error) {
+	ts.mu.Lock()
+	lis := ts.lis
+	ts.mu.Unlock()
+	return lis.DialContext(ctx)
+}
+
+func newTestClient(t *testing.T, ts *testServer) *Client {
+	t.Helper()
+	c, err := New("passthrough:///bufnet",
+		WithDialOptions(
+			grpc.WithContextDialer(ts.dial),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		),
+		WithBackoff(10*time.Millisecond, 50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close(context.Background()) })
+	return c
+}
+
+func receive(t *testing.T, ch <-chan *subpub.Message) *subpub.Message {
+	t.Helper()
+	select {
+	case m := <-ch:
+		return m
+	case <-time.After(2 * time.Second):
+		t.Fatal("No message received")
+	}
+	return nil
+}
+
+// waitSubscriptions waits for the server side of the subscriptions to come and go.
+func waitSubscriptions(t *testing.T, sp subpub.SubPub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sp.Subscriptions()) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d subscriptions, got %d", n, len(sp.Subscriptions()))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClient(t *testing.T) {
+	t.Run("Publish And Subscribe", func(t *testing.T) {
+		c := newTestClient(t, newTestServer(t, subpub.NewSubPub(100)))
+		received := make(chan *subpub.Message, 10)
+		sub, err := c.Subscribe("orders.*", func(msg interface{}) { received <- msg.(*subpub.Message) })
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		res, err := c.PublishWithResult("orders.eu", &subpub.Message{
+			Data:        []byte(`{"id":1}`),
+			ContentType: "application/json",
+			Headers:     map[string]string{"tenant": "acme"},
+		})
+		if err != nil || res.Status() != subpub.Accepted {
+			t.Fatalf("Publish failed: %+v %v", res, err)
+		}
+		m := receive(t, received)
+		if string(m.Data) != `{"id":1}` || m.Subject != "orders.eu" || m.Headers["tenant"] != "acme" || m.ID == "" {
+			t.Errorf("Unexpected message %+v", m)
+		}
+		c.Publish("orders.us", "hello")
+		if m := receive(t, received); string(m.Data) != "hello" || m.ContentType != contentTypeText {
+			t.Errorf("Unexpected message %+v", m)
+		}
+		if infos := c.Subscriptions(); len(infos) != 1 || infos[0].Subject != "orders.*" || infos[0].Delivered != 2 {
+			t.Errorf("Unexpected subscriptions %+v", infos)
+		}
+
+		sub.Unsubscribe()
+		select {
+		case <-sub.Done():
+		case <-time.After(time.Second):
+			t.Fatal("Subscription not torn down")
+		}
+		if sub.Err() != nil || len(c.Subscriptions()) != 0 {
+			t.Errorf("Expected a clean unsubscribe, got %v", sub.Err())
+		}
+	})
+
+	t.Run("Rejected Subscription", func(t *testing.T) {
+		c := newTestClient(t, newTestServer(t, subpub.NewSubPub(100)))
+		_, err := c.Subscribe("a..b", func(msg interface{}) {})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument, got %v", err)
+		}
+		if _, err := c.SubscribeQueue("orders", "", func(msg interface{}) {}); !errors.Is(err, subpub.ErrInvalidQueueGroup) {
+			t.Errorf("Expected ErrInvalidQueueGroup, got %v", err)
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		sp.Subscribe("orders", func(msg interface{}) {})
+		c := newTestClient(t, newTestServer(t, sp))
+		results := c.PublishBatch([]subpub.BatchMessage{
+			{Subject: "orders", Msg: "a"},
+			{Subject: "alerts", Msg: "b"},
+			{Subject: "a..b", Msg: "c"},
+			{Subject: "orders", Msg: func() {}},
+		})
+		if results[0].Status() != subpub.Accepted || results[1].Status() != subpub.NoSubscribers {
+			t.Errorf("Unexpected results %+v", results)
+		}
+		if results[2].Err == nil || results[3].Err == nil {
+			t.Errorf("Expected the invalid messages to be rejected, got %+v", results)
+		}
+	})
+
+	t.Run("Request", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		c := newTestClient(t, newTestServer(t, sp))
+		if _, err := c.Request(context.Background(), "greet", "bob"); !errors.Is(err, subpub.ErrNoResponders) {
+			t.Errorf("Expected ErrNoResponders, got %v", err)
+		}
+		c.Subscribe("greet", func(msg interface{}) {
+			req := msg.(*subpub.Message)
+			c.Publish(req.ReplyTo(), "hello "+string(req.Data))
+		})
+		reply, err := c.Request(context.Background(), "greet", "bob")
+		if err != nil || string(reply.(*subpub.Message).Data) != "hello bob" {
+			t.Errorf("Unexpected reply %v: %v", reply, err)
+		}
+	})
+
+	t.Run("Retained", func(t *testing.T) {
+		c := newTestClient(t, newTestServer(t, subpub.NewSubPub(100)))
+		c.Publish("status", &subpub.Message{Data: []byte("up"), Retain: true})
+		received := make(chan *subpub.Message, 1)
+		c.Subscribe("status", func(msg interface{}) { received <- msg.(*subpub.Message) })
+		if m := receive(t, received); string(m.Data) != "up" || !m.Retain {
+			t.Errorf("Expected the retained value, got %+v", m)
+		}
+		if n, err := c.ClearRetained("status"); n != 1 || err != nil {
+			t.Errorf("Expected one cleared key, got %d %v", n, err)
+		}
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		c := newTestClient(t, newTestServer(t, sp))
+		orders, _ := c.Subscribe("orders", func(msg interface{}) {})
+		alerts, _ := c.Subscribe("alerts", func(msg interface{}) {})
+		// The client lists its subscriptions with the ids the server gave them.
+		for _, info := range c.Subscriptions() {
+			if info.ID == "" {
+				t.Fatalf("Expected the server id of %s", info.Subject)
+			}
+			if info.Subject == "orders" {
+				if err := c.Disconnect(info.ID); err != nil {
+					t.Fatalf("Disconnect failed: %v", err)
+				}
+			}
+		}
+		if err := c.Disconnect("unknown"); !errors.Is(err, subpub.ErrSubscriptionNotFound) {
+			t.Errorf("Expected ErrSubscriptionNotFound, got %v", err)
+		}
+		if n, err := c.Drain(context.Background(), "alerts"); n != 1 || err != nil {
+			t.Errorf("Expected one drained subscription, got %d %v", n, err)
+		}
+		// The client does not reopen subscriptions ended by an operator.
+		for _, want := range []struct {
+			sub subpub.Subscription
+			err error
+		}{{orders, subpub.ErrDisconnected}, {alerts, subpub.ErrDrained}} {
+			select {
+			case <-want.sub.Done():
+			case <-time.After(time.Second):
+				t.Fatal("Expected the subscription to end")
+			}
+			if !errors.Is(want.sub.Err(), want.err) {
+				t.Errorf("Expected %v, got %v", want.err, want.sub.Err())
+			}
+		}
+	})
+
+	t.Run("Error Reasons", func(t *testing.T) {
+		if errorDomain != services.ErrorDomain {
+			t.Errorf("Expected domain %q, got %q", services.ErrorDomain, errorDomain)
+		}
+		for reason, want := range map[string]error{
+			services.ReasonSlowConsumer: subpub.ErrSlowConsumer,
+			services.ReasonDisconnected: subpub.ErrDisconnected,
+			services.ReasonDrained:      subpub.ErrDrained,
+			services.ReasonNoResponders: subpub.ErrNoResponders,
+		} {
+			if got := reasonErrors[reason]; got != want {
+				t.Errorf("Expected %v for reason %s, got %v", want, reason, got)
+			}
+		}
+		// Only the details count, not the wording of the message.
+		if err := reasonError(status.Error(codes.Aborted, "subscription drained by an operator")); err != nil {
+			t.Errorf("Expected no error without details, got %v", err)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		c := newTestClient(t, newTestServer(t, subpub.NewSubPub(100)))
+		sub, err := c.Subscribe("orders", func(msg interface{}) {})
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		if err := c.Close(context.Background()); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		select {
+		case <-sub.Done():
+		default:
+			t.Error("Expected Close to end the subscription")
+		}
+		if err := c.Publish("orders", "x"); !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+		if _, err := c.Subscribe("orders", func(msg interface{}) {}); !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+	})
+
+	t.Run("Close Waits For Handlers", func(t *testing.T) {
+		c := newTestClient(t, newTestServer(t, subpub.NewSubPub(100)))
+		release := make(chan struct{})
+		started := make(chan struct{})
+		c.Subscribe("orders", func(msg interface{}) {
+			close(started)
+			<-release
+		})
+		c.Publish("orders", "x")
+		<-started
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := c.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected DeadlineExceeded, got %v", err)
+		}
+		close(release)
+	})
+}
+
+func TestReconnect(t *testing.T) {
+	t.Run("Resubscribe", func(t *testing.T) {
+		sp := subpub.NewSubPub(100)
+		ts := newTestServer(t, sp)
+		c := newTestClient(t, ts)
+		received := make(chan *subpub.Message, 10)
+		sub, err := c.Subscribe("orders.>", func(msg interface{}) { received <- msg.(*subpub.Message) })
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		first := sub.ID()
+		ts.stop()
+		waitSubscriptions(t, sp, 0)
+		ts.start()
+		waitSubscriptions(t, sp, 1)
+		// The id is known once the client has read the headers of the new stream.
+		id := sp.Subscriptions()[0].ID
+		for i := 0; i < 100 && sub.ID() != id; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if sub.ID() != id || id == first {
+			t.Errorf("Expected the id of the reopened subscription %s, got %s", id, sub.ID())
+		}
+		sp.Publish("orders.eu", "after")
+		if m := receive(t, received); string(m.Data) != "after" {
+			t.Errorf("Unexpected message %+v", m)
+		}
+		if sub.Err() != nil {
+			t.Errorf("Unexpected error %v", sub.Err())
+		}
+	})
+
+	t.Run("Resume From Last Sequence", func(t *testing.T) {
+		l, err := msglog.Open(t.TempDir())
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer l.Close()
+		sp := subpub.NewSubPub(100, subpub.WithLog(l))
+		ts := newTestServer(t, sp)
+		c := newTestClient(t, ts)
+		received := make(chan *subpub.Message, 10)
+		if _, err := c.Subscribe("orders", func(msg interface{}) { received <- msg.(*subpub.Message) }); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		sp.Publish("orders", &subpub.Message{Data: []byte("a")})
+		receive(t, received)
+		ts.stop()
+		sp.Publish("orders", &subpub.Message{Data: []byte("b")})
+		sp.Publish("orders", &subpub.Message{Data: []byte("c")})
+		ts.start()
+		for i, want := range []string{"b", "c"} {
+			if m := receive(t, received); string(m.Data) != want || m.Seq != uint64(i+2) {
+				t.Errorf("Expected %s at %d, got %+v", want, i+2, m)
+			}
+		}
+	})
+
+	t.Run("Slow Consumer", func(t *testing.T) {
+		sp := subpub.NewSubPub(1, subpub.WithOverflowPolicy(subpub.Disconnect))
+		c := newTestClient(t, newTestServer(t, sp))
+		release := make(chan struct{})
+		sub, err := c.Subscribe("orders", func(msg interface{}) { <-release })
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		// Fill the flow control windows until the buffer of the server overflows.
+		data := make([]byte, 16<<10)
+		for i := 0; i < 200 && len(sp.Subscriptions()) > 0; i++ {
+			sp.Publish("orders", data)
+		}
+		close(release)
+		select {
+		case <-sub.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the subscription to end")
+		}
+		if !errors.Is(sub.Err(), subpub.ErrSlowConsumer) {
+			t.Errorf("Expected ErrSlowConsumer, got %v", sub.Err())
+		}
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		b := backoff{initial: 100 * time.Millisecond, max: time.Second}
+		for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+			want *= time.Millisecond
+			if d := b.delay(attempt); d > want || d < want*4/5 {
+				t.Errorf("Attempt %d: expected about %v, got %v", attempt, want, d)
+			}
+		}
+		if d := (backoff{initial: time.Hour, max: time.Hour}).delay(100); d <= 0 {
+			t.Errorf("Expected a positive delay, got %v", d)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	pb "asyn-subpub-service/pb/proto/api"
+	"asyn-subpub-service/pkg/subpub"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"strings"
+)
+
+// The content types match those the server gives messages published in process.
+const (
+	contentTypeText   = "text/plain; charset=utf-8"
+	contentTypeBinary = "application/octet-stream"
+	contentTypeJSON   = "application/json"
+	// contentTypeAny prefixes the type URL of typed payloads stored as plain bytes.
+	contentTypeAny = "application/x-protobuf; type="
+)
+
+// toRequest encodes msg like the server encodes messages published in process:
+// strings, bytes and protobuf messages map to their natural representation and
+// anything else is encoded as JSON.
+func toRequest(subject string, msg interface{}) (*pb.PublishRequest, error) {
+	req := &pb.PublishRequest{Key: subject}
+	switch m := msg.(type) {
+	case *subpub.Message:
+		req.Data = m.Data
+		req.ContentType = m.ContentType
+		req.Headers = m.Headers
+		req.Retain = m.Retain
+		if m.TTL > 0 {
+			req.Ttl = durationpb.New(m.TTL)
+		}
+		if typeURL, ok := strings.CutPrefix(m.ContentType, contentTypeAny); ok {
+			req.Payload = &anypb.Any{TypeUrl: typeURL, Value: m.Data}
+			req.Data = nil
+			req.ContentType = ""
+		}
+	case string:
+		req.Data = []byte(m)
+		req.ContentType = contentTypeText
+	case []byte:
+		req.Data = m
+		req.ContentType = contentTypeBinary
+	case proto.Message:
+		payload, err := anypb.New(m)
+		if err != nil {
+			return nil, fmt.Errorf("client: encoding %T: %w", msg, err)
+		}
+		req.Payload = payload
+	default:
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("client: encoding %T: %w", msg, err)
+		}
+		req.Data = data
+		req.ContentType = contentTypeJSON
+	}
+	return req, nil
+}
+
+// fromEvent rebuilds the envelope of a delivered event. Typed payloads keep their
+// serialized bytes, as they do in process.
+func fromEvent(event *pb.Event) *subpub.Message {
+	m := &subpub.Message{
+		ID:          event.Id,
+		Subject:     event.Subject,
+		Seq:         event.Sequence,
+		Data:        event.Data,
+		ContentType: event.ContentType,
+		Headers:     event.Headers,
+		Retain:      event.Retained,
+	}
+	if event.PublishedAt != nil {
+		m.Time = event.PublishedAt.AsTime()
+	}
+	if event.Payload != nil {
+		m.Data = event.Payload.Value
+		m.ContentType = contentTypeAny + event.Payload.TypeUrl
+	}
+	return m
+}
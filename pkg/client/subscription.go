@@ -0,0 +1,198 @@
+package client
+
+import (
+	pb "asyn-subpub-service/pb/proto/api"
+	"asyn-subpub-service/pkg/subpub"
+	"context"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type subscription struct {
+	client *Client
+	key    string
+	group  string
+	start  subpub.Position
+	cb     subpub.MessageHandler
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// lastSeq is the sequence of the last event received, owned by run.
+	lastSeq   uint64
+	delivered atomic.Uint64
+
+	mu  sync.Mutex
+	id  string
+	err error
+}
+
+func (s *subscription) Unsubscribe() {
+	s.cancel()
+}
+
+// ID returns the id the server gave the subscription, which changes whenever the
+// stream is reopened.
+func (s *subscription) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+func (s *subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *subscription) Stats() subpub.Stats {
+	return subpub.Stats{Delivered: s.delivered.Load()}
+}
+
+// open starts a stream and waits for the server to register the subscription.
+func (s *subscription) open() (pb.PubSub_SubscribeClient, error) {
+	stream, err := s.client.api.Subscribe(s.ctx, s.request())
+	if err != nil {
+		return nil, err
+	}
+	// The server sends the headers once the subscription is registered and fails
+	// the stream without them when it is rejected; Recv then reports why.
+	md, err := stream.Header()
+	if err != nil {
+		return nil, err
+	}
+	if md == nil {
+		_, err := stream.Recv()
+		return nil, err
+	}
+	if ids := md.Get(subscriptionIDHeader); len(ids) > 0 {
+		s.mu.Lock()
+		s.id = ids[0]
+		s.mu.Unlock()
+	}
+	return stream, nil
+}
+
+// request asks for the subscription as requested, or from the message after the
+// last one received when it can be resumed. Sequences are counted per subject,
+// so only literal subscriptions outside queue groups resume.
+func (s *subscription) request() *pb.SubscribeRequest {
+	req := &pb.SubscribeRequest{Key: s.key, QueueGroup: s.group}
+	if s.lastSeq > 0 && s.group == "" && subpub.IsLiteral(s.key) {
+		req.StartFrom = &pb.StartFrom{Position: &pb.StartFrom_Sequence{Sequence: s.lastSeq + 1}}
+		return req
+	}
+	if s.start == subpub.Earliest {
+		req.StartFrom = &pb.StartFrom{Position: &pb.StartFrom_Earliest{Earliest: &emptypb.Empty{}}}
+	} else if seq, ok := s.start.Sequence(); ok {
+		req.StartFrom = &pb.StartFrom{Position: &pb.StartFrom_Sequence{Sequence: seq}}
+	} else if t, ok := s.start.Time(); ok {
+		req.StartFrom = &pb.StartFrom{Position: &pb.StartFrom_Time{Time: timestamppb.New(t)}}
+	}
+	return req
+}
+
+// run delivers events until the subscription is cancelled or fails for good,
+// reopening the stream whenever it breaks.
+func (s *subscription) run(stream pb.PubSub_SubscribeClient) {
+	defer s.client.wg.Done()
+	defer close(s.done)
+	defer s.client.remove(s)
+	defer s.cancel()
+	for {
+		err := s.receive(stream)
+		for attempt := 0; ; attempt++ {
+			if s.ctx.Err() != nil {
+				return
+			}
+			if permanent(err) {
+				s.fail(err)
+				return
+			}
+			if !sleep(s.ctx, s.client.backoff.delay(attempt)) {
+				return
+			}
+			stream, err = s.open()
+			if err == nil {
+				break
+			}
+		}
+	}
+}
+
+func (s *subscription) receive(stream pb.PubSub_SubscribeClient) error {
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if s.ctx.Err() != nil {
+			return s.ctx.Err()
+		}
+		if event.Sequence > s.lastSeq {
+			s.lastSeq = event.Sequence
+		}
+		s.cb(fromEvent(event))
+		s.delivered.Add(1)
+	}
+}
+
+func (s *subscription) fail(err error) {
+	if reason := reasonError(err); reason != nil {
+		err = fmt.Errorf("%w: %v", reason, err)
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// permanent reports whether reopening the stream would fail the same way. The
+// server disconnecting a slow consumer, or an operator ending the subscription,
+// ends it like in process.
+func permanent(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.PermissionDenied,
+		codes.Unauthenticated, codes.Unimplemented, codes.OutOfRange, codes.ResourceExhausted, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+// delay doubles the initial delay on every attempt up to max, with up to 20%
+// jitter so that clients do not reconnect in lockstep after an outage.
+func (b backoff) delay(attempt int) time.Duration {
+	d := b.initial
+	for i := 0; i < attempt && d < b.max; i++ {
+		d *= 2
+	}
+	d = min(d, b.max)
+	return d - time.Duration(rand.Int64N(int64(d)/5+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,101 @@
+package subpub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Message is the envelope of a published message. Publishing a *Message lets the
+// sub-pub system stamp it with an ID, the subject, publish time and, when a message
+// log is configured, its sequence number. Messages replayed from the log are always
+// delivered as *Message. Subscribers share the envelope and must not modify it.
+type Message struct {
+	// ID uniquely identifies the message; it is generated on publish when left empty.
+	ID      string
+	Subject string
+	// Seq is the position of the message in the log of its subject, 0 if it was not persisted.
+	Seq  uint64
+	Time time.Time
+	Data []byte
+	// ContentType describes the encoding of Data, e.g. "application/json".
+	ContentType string
+	// Headers carry application metadata such as trace ids.
+	Headers map[string]string
+	// Retain keeps a published message as the last value of its subject, delivered
+	// to every later subscription of the subject. On delivery it reports whether
+	// the message comes from the retained values rather than a live publish.
+	Retain bool
+	// TTL, when positive, discards the message instead of handing it to a
+	// subscriber once TTL has passed since Time. Zero selects the default TTL of
+	// the subject, if any. TTLs are not persisted: replayed messages never expire.
+	TTL time.Duration
+}
+
+// Expired reports whether the TTL of the message has passed at now.
+func (m *Message) Expired(now time.Time) bool {
+	return m.TTL > 0 && now.Sub(m.Time) >= m.TTL
+}
+
+var (
+	idPrefix  = newIDPrefix()
+	idCounter atomic.Uint64
+)
+
+func newIDPrefix() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewID returns a process-unique message identifier.
+func NewID() string {
+	return idPrefix + strconv.FormatUint(idCounter.Add(1), 36)
+}
+
+type positionKind int
+
+const (
+	positionLatest positionKind = iota
+	positionEarliest
+	positionSequence
+	positionTime
+)
+
+// Position selects where a subscription starts reading its subject.
+type Position struct {
+	kind positionKind
+	seq  uint64
+	time time.Time
+}
+
+var (
+	// Latest delivers only messages published after Subscribe returns.
+	Latest = Position{kind: positionLatest}
+	// Earliest replays every message kept in the log before delivering live messages.
+	Earliest = Position{kind: positionEarliest}
+)
+
+// AtSequence replays the log starting with the message with the given sequence number.
+func AtSequence(seq uint64) Position {
+	return Position{kind: positionSequence, seq: seq}
+}
+
+// AtTime replays the log starting with the first message published at or after t.
+func AtTime(t time.Time) Position {
+	return Position{kind: positionTime, time: t}
+}
+
+// Sequence returns the sequence number of a position created by AtSequence.
+func (p Position) Sequence() (uint64, bool) {
+	return p.seq, p.kind == positionSequence
+}
+
+// Time returns the time of a position created by AtTime.
+func (p Position) Time() (time.Time, bool) {
+	return p.time, p.kind == positionTime
+}
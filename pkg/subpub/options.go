@@ -0,0 +1,112 @@
+package subpub
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultBlockTimeout is used by the Block policy when no positive timeout is configured.
+const DefaultBlockTimeout = time.Second
+
+// OverflowPolicy decides what Publish does when a subscriber's buffer is full.
+type OverflowPolicy int
+
+const (
+	// DropNewest discards the message being published.
+	DropNewest OverflowPolicy = iota
+	// DropOldest evicts the oldest buffered message to make room, turning the buffer into a ring.
+	DropOldest
+	// Block makes the publisher wait for free space up to the block timeout, then drops the message.
+	Block
+	// Disconnect unsubscribes the slow subscriber; its Err reports ErrSlowConsumer.
+	Disconnect
+)
+
+var overflowPolicyNames = map[OverflowPolicy]string{
+	DropNewest: "drop_newest",
+	DropOldest: "drop_oldest",
+	Block:      "block",
+	Disconnect: "disconnect",
+}
+
+func (p OverflowPolicy) String() string {
+	if name, ok := overflowPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// ParseOverflowPolicy converts a config value such as "drop_oldest" to an OverflowPolicy.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	for p, name := range overflowPolicyNames {
+		if name == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q", s)
+}
+
+// SubscribeOption configures a single subscription.
+type SubscribeOption func(*SubscribeSettings)
+
+// SubscribeSettings is the outcome of a list of SubscribeOptions, for SubPub
+// implementations to read.
+type SubscribeSettings struct {
+	BufferSize   int
+	Policy       OverflowPolicy
+	BlockTimeout time.Duration
+	Start        Position
+	Peer         string
+}
+
+// Apply applies opts in order on top of s and returns the resulting settings.
+func (s SubscribeSettings) Apply(opts ...SubscribeOption) SubscribeSettings {
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
+}
+
+// ResolveSubscribeOptions applies opts in order and returns the resulting
+// settings. Settings no option changed are zero.
+func ResolveSubscribeOptions(opts ...SubscribeOption) SubscribeSettings {
+	return SubscribeSettings{}.Apply(opts...)
+}
+
+// OnOverflow selects the overflow policy of the subscription.
+func OnOverflow(p OverflowPolicy) SubscribeOption {
+	return func(o *SubscribeSettings) {
+		o.Policy = p
+	}
+}
+
+// BlockTimeout sets how long a publisher waits on the subscription's full buffer under the Block policy.
+func BlockTimeout(d time.Duration) SubscribeOption {
+	return func(o *SubscribeSettings) {
+		o.BlockTimeout = d
+	}
+}
+
+// BufferSize overrides the buffer size of the subscription.
+func BufferSize(n int) SubscribeOption {
+	return func(o *SubscribeSettings) {
+		o.BufferSize = n
+	}
+}
+
+// StartAt makes the subscription catch up from the given log position before
+// receiving live messages. Replay is gap-free: every message up to the moment of
+// subscribing comes from the log and every later one is delivered live.
+func StartAt(p Position) SubscribeOption {
+	return func(o *SubscribeSettings) {
+		o.Start = p
+	}
+}
+
+// Peer records who holds the subscription, e.g. the remote address of a client,
+// so that operators can tell subscriptions apart in Subscriptions.
+func Peer(addr string) SubscribeOption {
+	return func(o *SubscribeSettings) {
+		o.Peer = addr
+	}
+}
@@ -0,0 +1,30 @@
+package subpub
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// ReplyToHeader names the subject a request expects its reply on.
+	ReplyToHeader = "reply-to"
+	// InboxPrefix starts the unique subjects Request receives replies on.
+	InboxPrefix = "_INBOX."
+)
+
+var (
+	// ErrNoResponders is returned by Request when no subscription matched the subject.
+	ErrNoResponders = errors.New("subpub: no responders")
+	// ErrInvalidRequest is returned by Request for messages that cannot carry a reply subject.
+	ErrInvalidRequest = errors.New("subpub: request must be a *Message, string or []byte")
+)
+
+// IsInbox reports whether subject is a reply subject made by Request.
+func IsInbox(subject string) bool {
+	return strings.HasPrefix(subject, InboxPrefix)
+}
+
+// ReplyTo returns the subject the publisher of m waits for a reply on, if any.
+func (m *Message) ReplyTo() string {
+	return m.Headers[ReplyToHeader]
+}
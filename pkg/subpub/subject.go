@@ -0,0 +1,93 @@
+package subpub
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	tokenSeparator = "."
+	// SingleWildcard matches exactly one token.
+	SingleWildcard = "*"
+	// TailWildcard matches one or more trailing tokens and must be the last token.
+	TailWildcard = ">"
+)
+
+// ErrInvalidSubject is returned for malformed subjects and for wildcards in published subjects.
+var ErrInvalidSubject = errors.New("subpub: invalid subject")
+
+// Tokenize splits a subject into its dot-separated tokens, validating wildcard placement
+// when wildcards are allowed and rejecting them otherwise.
+func Tokenize(subject string, allowWildcards bool) ([]string, error) {
+	if subject == "" {
+		return nil, ErrInvalidSubject
+	}
+	tokens := strings.Split(subject, tokenSeparator)
+	for i, tok := range tokens {
+		switch {
+		case tok == "":
+			return nil, ErrInvalidSubject
+		case tok == SingleWildcard || tok == TailWildcard:
+			if !allowWildcards || (tok == TailWildcard && i != len(tokens)-1) {
+				return nil, ErrInvalidSubject
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// IsLiteral reports whether the subject contains no wildcard tokens.
+func IsLiteral(subject string) bool {
+	for _, tok := range strings.Split(subject, tokenSeparator) {
+		if tok == SingleWildcard || tok == TailWildcard {
+			return false
+		}
+	}
+	return true
+}
+
+// Match reports whether a literal subject is matched by the subject pattern.
+func Match(pattern, subject string) bool {
+	p, err := Tokenize(pattern, true)
+	if err != nil {
+		return false
+	}
+	s, err := Tokenize(subject, false)
+	if err != nil {
+		return false
+	}
+	for i, tok := range p {
+		if tok == TailWildcard {
+			return len(s) > i
+		}
+		if i >= len(s) || (tok != SingleWildcard && tok != s[i]) {
+			return false
+		}
+	}
+	return len(p) == len(s)
+}
+
+// Covers reports whether every subject matched by the pattern sub is also matched
+// by pattern. A literal sub is covered exactly when Match(pattern, sub) holds.
+func Covers(pattern, sub string) bool {
+	p, err := Tokenize(pattern, true)
+	if err != nil {
+		return false
+	}
+	s, err := Tokenize(sub, true)
+	if err != nil {
+		return false
+	}
+	for i, tok := range p {
+		if tok == TailWildcard {
+			return len(s) > i
+		}
+		if i >= len(s) || s[i] == TailWildcard {
+			return false
+		}
+		if tok != SingleWildcard && tok != s[i] {
+			return false
+		}
+	}
+	return len(p) == len(s)
+}
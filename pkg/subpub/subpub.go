@@ -0,0 +1,192 @@
+// Package subpub defines the publish-subscribe API shared by the in-process
+// broker and the client of the PubSub service, so that code can swap between them.
+package subpub
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrSlowConsumer is reported by a subscription that was disconnected by the Disconnect policy.
+	ErrSlowConsumer = errors.New("subpub: slow consumer disconnected")
+	// ErrInvalidQueueGroup is returned by SubscribeQueue for an empty group name.
+	ErrInvalidQueueGroup = errors.New("subpub: invalid queue group")
+	// ErrSubscriptionNotFound is returned by Disconnect for an unknown subscription id.
+	ErrSubscriptionNotFound = errors.New("subpub: subscription not found")
+	// ErrDisconnected is reported by a subscription ended with Disconnect.
+	ErrDisconnected = errors.New("subpub: subscription disconnected")
+	// ErrDrained is reported by a subscription ended with Drain.
+	ErrDrained = errors.New("subpub: subscription drained")
+	// ErrNoLog is returned when a subscription asks for replay but no message log is configured.
+	ErrNoLog = errors.New("subpub: message log is not configured")
+	// ErrReplayWildcard is returned when replay is requested for a wildcard subject.
+	ErrReplayWildcard = errors.New("subpub: replay requires a literal subject")
+	// ErrNotPersistable is returned when a message of an unsupported type is published to a persisted SubPub.
+	ErrNotPersistable = errors.New("subpub: message cannot be persisted")
+)
+
+// MessageHandler is a callback function that process massages delivered to subscribers.
+type MessageHandler func(msg interface{})
+
+type Subscription interface {
+	// ID identifies the subscription in Subscriptions, e.g. for Disconnect.
+	ID() string
+
+	// Unsubscribe will remove interest in the current subject subscription is for.
+	// Messages still buffered for the subscription are discarded.
+	Unsubscribe()
+
+	// Done returns a channel that is closed once the subscription has been torn down
+	// and its handler will not be invoked again.
+	Done() <-chan struct{}
+
+	// Err returns the reason the subscription was torn down by the sub-pub system, if any.
+	Err() error
+
+	// Stats returns the delivery counters of the subscription.
+	Stats() Stats
+}
+
+// Stats holds the delivery counters of a subscription.
+type Stats struct {
+	// Delivered is the number of messages passed to the handler.
+	Delivered uint64
+	// Dropped is the number of messages discarded because the buffer was full.
+	Dropped uint64
+	// Evicted is the number of buffered messages discarded by the DropOldest policy.
+	Evicted uint64
+	// Expired is the number of messages discarded because their TTL passed before
+	// they reached the handler.
+	Expired uint64
+}
+
+// PublishStatus is the outcome of publishing a single message.
+type PublishStatus int
+
+const (
+	// Accepted means the message was enqueued for at least one subscriber.
+	Accepted PublishStatus = iota
+	// Dropped means subscribers matched the subject but none of them had room for the message.
+	Dropped
+	// NoSubscribers means no subscription matched the subject.
+	NoSubscribers
+)
+
+func (s PublishStatus) String() string {
+	switch s {
+	case Accepted:
+		return "accepted"
+	case Dropped:
+		return "dropped"
+	case NoSubscribers:
+		return "no_subscribers"
+	default:
+		return "unknown"
+	}
+}
+
+// PublishResult reports how a published message was delivered. A queue group
+// counts as a single matched subscriber, since only one member receives the message.
+type PublishResult struct {
+	// Matched is the number of subscriptions the message was routed to.
+	Matched int
+	// Enqueued is the number of subscriptions that buffered the message.
+	Enqueued int
+	// Dropped is the number of subscriptions that had no room for the message.
+	Dropped int
+}
+
+// Status summarizes the result.
+func (r PublishResult) Status() PublishStatus {
+	switch {
+	case r.Matched == 0:
+		return NoSubscribers
+	case r.Enqueued == 0:
+		return Dropped
+	default:
+		return Accepted
+	}
+}
+
+// BatchMessage is a single message published with PublishBatch.
+type BatchMessage struct {
+	Subject string
+	Msg     interface{}
+}
+
+// BatchResult reports what happened to a message published with PublishBatch.
+type BatchResult struct {
+	// PublishResult is only meaningful when Err is nil.
+	PublishResult
+	// Err is set when the message was rejected, e.g. for an invalid subject.
+	Err error
+}
+
+type SubPub interface {
+	// Subscribe creates an asynchronous queue subscribers on the given subject.
+	// Subjects are dot-separated tokens; the pattern may use "*" to match a single
+	// token and a trailing ">" to match one or more remaining tokens.
+	Subscribe(subject string, cb MessageHandler, opts ...SubscribeOption) (Subscription, error)
+
+	// SubscribeQueue creates a subscription that joins the named queue group on the subject.
+	// Each message is handed to exactly one member of every matching group,
+	// while plain subscribers still receive every message. A group is identified
+	// by the subject pattern and the name together: groups of the same name on
+	// different patterns receive and balance messages separately.
+	SubscribeQueue(subject, group string, cb MessageHandler, opts ...SubscribeOption) (Subscription, error)
+
+	// Publish publishes the msg argument to the give subject.
+	// The subject must be literal, wildcards are rejected with ErrInvalidSubject.
+	// Every subscriber receives the messages of a publisher in the order of its
+	// calls, and the messages of a subject in the order they were logged, however
+	// many goroutines publish concurrently.
+	Publish(subject string, msg interface{}) error
+
+	// PublishWithResult publishes like Publish and reports how many subscribers
+	// matched the subject and how many of them buffered or dropped the message.
+	PublishWithResult(subject string, msg interface{}) (PublishResult, error)
+
+	// PublishBatch publishes the messages in order, routing the whole batch before
+	// delivering any of it, and returns one result per message. A rejected message
+	// does not stop the rest of the batch.
+	PublishBatch(msgs []BatchMessage) []BatchResult
+
+	// Request publishes msg with a unique reply subject and waits for the first
+	// reply published to it, or until ctx is done.
+	Request(ctx context.Context, subject string, msg interface{}) (interface{}, error)
+
+	// Subscriptions returns a snapshot of every live subscription.
+	Subscriptions() []SubscriptionInfo
+
+	// ClearRetained forgets the retained messages of every subject matched by the
+	// pattern and returns how many were cleared.
+	ClearRetained(pattern string) (int, error)
+
+	// Disconnect forcibly ends the subscription with the id reported by Subscriptions.
+	Disconnect(id string) error
+
+	// Drain ends every subscription whose subject is covered by the pattern once it
+	// has delivered its buffered messages, and waits for them until ctx is done.
+	Drain(ctx context.Context, pattern string) (int, error)
+
+	// Close will shutdown the sub-pub system.
+	// May be blocked by data deliver until the context is canceled.
+	Close(ctx context.Context) error
+}
+
+// SubscriptionInfo describes a live subscription.
+type SubscriptionInfo struct {
+	// ID identifies the subscription for Disconnect.
+	ID      string
+	Subject string
+	// Queue is the queue group of the subscription, empty for plain subscribers.
+	Queue string
+	// Pending is the number of buffered messages waiting for the handler.
+	Pending int
+	// Capacity is the size of the buffer.
+	Capacity int
+	// Peer is who holds the subscription, as given with the Peer option.
+	Peer string
+	Stats
+}